@@ -7,12 +7,16 @@ import (
 	"github.com/DomeLiquid/core/utils"
 	"github.com/facebookgo/clock"
 	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
 	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
 )
 
 type (
 	AccountStore interface {
 		GetAccountById(ctx context.Context, accountId uuid.UUID) (*Account, error)
+		// ListAccountByPubkey may return closed accounts, use ListOpenAccountsByPubkey for the
+		// accounts pubkey owns now
 		ListAccountByPubkey(ctx context.Context, groupId uuid.UUID, pubkey string) ([]*Account, error)
 		GetAccountByPubkey(ctx context.Context, groupId uuid.UUID, pubkey string, index uint8) (*Account, error)
 		CreateAccount(ctx context.Context, account *Account) error
@@ -25,6 +29,8 @@ type (
 		PubKey       string       `json:"pubKey"`
 		AccountFlags AccountFlags `json:"accountFlags"`
 		Index        uint8        `json:"index"`
+		Seq          uint32       `json:"seq"`
+		Label        string       `json:"label,omitempty"`
 
 		CreatedAt int64 `json:"createdAt"`
 		UpdatedAt int64 `json:"updatedAt"`
@@ -38,8 +44,20 @@ const (
 	InFlashloanFlag              AccountFlags = 1 << 1
 	FlashloanEnabledFlag         AccountFlags = 1 << 2
 	TransferAuthorityAllowedFlag AccountFlags = 1 << 3
+	// ClosedFlag is final, a closed account keeps its id and a new account at the same index gets the next seq
+	ClosedFlag AccountFlags = 1 << 4
 )
 
+// AdminAccountFlags can be set and unset by the group admin, the other flags are internal
+const AdminAccountFlags = DisabledFlag | FlashloanEnabledFlag | TransferAuthorityAllowedFlag
+
+func ValidateAdminAccountFlag(flag AccountFlags) error {
+	if flag == 0 || flag&^AdminAccountFlags != 0 {
+		return IllegalFlag
+	}
+	return nil
+}
+
 func (a *Account) SetFlag(flag AccountFlags) {
 	a.AccountFlags |= flag
 }
@@ -52,17 +70,82 @@ func (a *Account) GetFlag(flag AccountFlags) bool {
 	return a.AccountFlags&flag != 0
 }
 
+type AccountOptionFunc func(a *Account)
+
+func WithAccountLabel(label string) AccountOptionFunc {
+	return func(a *Account) {
+		a.Label = label
+	}
+}
+
+// Deprecated: NewAccount always derives the seq 0 id, which a closed or transferred account may
+// still hold. Use CreateAccountForPubkey.
 func NewAccount(clk clock.Clock, groupId uuid.UUID, pubKey string, index uint8) *Account {
+	return NewAccountWithSeq(clk, groupId, pubKey, index, 0)
+}
+
+func NewAccountWithSeq(clk clock.Clock, groupId uuid.UUID, pubKey string, index uint8, seq uint32) *Account {
 	return &Account{
-		Id:        uuid.Must(uuid.FromString(utils.GenUuidFromStrings(groupId.String(), pubKey, strconv.Itoa(int(index))))),
+		Id:        NewAccountId(groupId, pubKey, index, seq),
 		GroupId:   groupId,
 		PubKey:    pubKey,
 		Index:     index,
+		Seq:       seq,
 		CreatedAt: clk.Now().Unix(),
 		UpdatedAt: clk.Now().Unix(),
 	}
 }
 
+// NewAccountId derives the account id from its first owner and index. An account keeps its id when
+// its authority is transferred, so seq moves later accounts of the same owner and index to a new id.
+func NewAccountId(groupId uuid.UUID, pubKey string, index uint8, seq uint32) uuid.UUID {
+	if seq == 0 {
+		return uuid.Must(uuid.FromString(utils.GenUuidFromStrings(groupId.String(), pubKey, strconv.Itoa(int(index)))))
+	}
+	return uuid.Must(uuid.FromString(utils.GenUuidFromStrings(groupId.String(), pubKey, strconv.Itoa(int(index)), "seq:"+strconv.FormatUint(uint64(seq), 10))))
+}
+
+// CreateAccountForPubkey creates the account of pubKey at index with the first seq whose id is not
+// held by another account
+func CreateAccountForPubkey(ctx context.Context, clk clock.Clock, accountStore AccountStore, groupId uuid.UUID, pubKey string, index uint8, opts ...AccountOptionFunc) (*Account, error) {
+	for seq := uint32(0); ; seq++ {
+		account := NewAccountWithSeq(clk, groupId, pubKey, index, seq)
+		for _, opt := range opts {
+			opt(account)
+		}
+		_, err := accountStore.GetAccountById(ctx, account.Id)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+
+		if err := accountStore.CreateAccount(ctx, account); err != nil {
+			return nil, err
+		}
+		return account, nil
+	}
+}
+
+// ListOpenAccountsByPubkey returns the open accounts pubKey owns in the group. The id is derived
+// from the first owner, so ownership is read from Account.PubKey and not from the id.
+func ListOpenAccountsByPubkey(ctx context.Context, accountStore AccountStore, groupId uuid.UUID, pubKey string) ([]*Account, error) {
+	accounts, err := accountStore.ListAccountByPubkey(ctx, groupId, pubKey)
+	if err != nil {
+		return nil, err
+	}
+
+	open := make([]*Account, 0, len(accounts))
+	for _, account := range accounts {
+		if account.PubKey != pubKey || account.GetFlag(ClosedFlag) {
+			continue
+		}
+		open = append(open, account)
+	}
+	return open, nil
+}
+
 func GetAccountHealth(totalAssets, totalLiabilities decimal.Decimal) decimal.Decimal {
 	health := ONE
 
@@ -0,0 +1,243 @@
+package core
+
+import (
+	"context"
+
+	"github.com/facebookgo/clock"
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+type (
+	AccountAuthorityTransferStore interface {
+		CreateAccountAuthorityTransfer(ctx context.Context, transfer *AccountAuthorityTransfer) error
+		UpdateAccountAuthorityTransfer(ctx context.Context, transfer *AccountAuthorityTransfer) error
+		GetPendingAccountAuthorityTransfer(ctx context.Context, accountId uuid.UUID) (*AccountAuthorityTransfer, error)
+	}
+
+	// AccountAuthorityTransfer moves an account to a new pubkey. The current owner requests it and the
+	// new owner has to accept it before it expires, the account keeps its id and balances.
+	AccountAuthorityTransfer struct {
+		Id         uuid.UUID                      `json:"id"`
+		AccountId  uuid.UUID                      `json:"accountId"`
+		GroupId    uuid.UUID                      `json:"groupId"`
+		FromPubKey string                         `json:"fromPubKey"`
+		ToPubKey   string                         `json:"toPubKey"`
+		Status     AccountAuthorityTransferStatus `json:"status"`
+
+		CreatedAt int64 `json:"createdAt"`
+		UpdatedAt int64 `json:"updatedAt"`
+		ExpiredAt int64 `json:"expiredAt"`
+	}
+
+	AccountAuthorityTransferStatus string
+)
+
+const (
+	AccountAuthorityTransferStatusPending   AccountAuthorityTransferStatus = "pending"
+	AccountAuthorityTransferStatusCompleted AccountAuthorityTransferStatus = "completed"
+	AccountAuthorityTransferStatusCancelled AccountAuthorityTransferStatus = "cancelled"
+)
+
+func NewAccountAuthorityTransfer(clk clock.Clock, account *Account, newPubKey string) (*AccountAuthorityTransfer, error) {
+	if err := account.CanTransferAuthority(newPubKey); err != nil {
+		return nil, err
+	}
+
+	now := clk.Now().Unix()
+	return &AccountAuthorityTransfer{
+		Id:         uuid.Must(uuid.NewV4()),
+		AccountId:  account.Id,
+		GroupId:    account.GroupId,
+		FromPubKey: account.PubKey,
+		ToPubKey:   newPubKey,
+		Status:     AccountAuthorityTransferStatusPending,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+		ExpiredAt:  now + ACCOUNT_AUTHORITY_TRANSFER_TTL,
+	}, nil
+}
+
+func (a *Account) CanTransferAuthority(newPubKey string) error {
+	if !a.GetFlag(TransferAuthorityAllowedFlag) {
+		return IllegalAccountAuthorityTransfer
+	}
+	if a.GetFlag(InFlashloanFlag) {
+		return AccountInFlashloan
+	}
+	if a.GetFlag(DisabledFlag) {
+		return AccountDisabled
+	}
+	if a.GetFlag(ClosedFlag) {
+		return AccountClosed
+	}
+	if newPubKey == "" || newPubKey == a.PubKey {
+		return IllegalAccountAuthorityTransfer
+	}
+	return nil
+}
+
+func (t *AccountAuthorityTransfer) IsExpired(clk clock.Clock) bool {
+	return clk.Now().Unix() > t.ExpiredAt
+}
+
+// Accept hands the account over to the new owner, the allow flag is cleared so the new owner
+// has to opt in again before the account can move on
+func (t *AccountAuthorityTransfer) Accept(clk clock.Clock, account *Account, pubKey string) error {
+	if t.Status != AccountAuthorityTransferStatusPending || t.IsExpired(clk) {
+		return IllegalAccountAuthorityTransfer
+	}
+	if t.AccountId != account.Id || t.FromPubKey != account.PubKey || t.ToPubKey != pubKey {
+		return Unauthorized
+	}
+	if err := account.CanTransferAuthority(pubKey); err != nil {
+		return err
+	}
+
+	account.PubKey = t.ToPubKey
+	account.UnsetFlag(TransferAuthorityAllowedFlag)
+	account.UpdatedAt = clk.Now().Unix()
+
+	t.Status = AccountAuthorityTransferStatusCompleted
+	t.UpdatedAt = clk.Now().Unix()
+	return nil
+}
+
+// Cancel can be called by either side while the transfer is pending
+func (t *AccountAuthorityTransfer) Cancel(clk clock.Clock, pubKey string) error {
+	if t.Status != AccountAuthorityTransferStatusPending {
+		return IllegalAccountAuthorityTransfer
+	}
+	if pubKey != t.FromPubKey && pubKey != t.ToPubKey {
+		return Unauthorized
+	}
+
+	t.Status = AccountAuthorityTransferStatusCancelled
+	t.UpdatedAt = clk.Now().Unix()
+	return nil
+}
+
+// RequestAccountAuthorityTransfer records the current owner's side of a transfer and writes an audit
+// Operate for it. The new owner must not hold an account at the same index, since lookups go through
+// pubkey and index.
+func RequestAccountAuthorityTransfer(ctx context.Context, clk clock.Clock, accountStore AccountStore, transferStore AccountAuthorityTransferStore, operateStore OperateStore, account *Account, newPubKey string) (*AccountAuthorityTransfer, error) {
+	transfer, err := NewAccountAuthorityTransfer(clk, account, newPubKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAuthoritySlotFree(ctx, accountStore, account, newPubKey); err != nil {
+		return nil, err
+	}
+
+	pending, err := transferStore.GetPendingAccountAuthorityTransfer(ctx, account.Id)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if pending != nil {
+		if err := pending.Cancel(clk, account.PubKey); err != nil {
+			return nil, err
+		}
+		if err := transferStore.UpdateAccountAuthorityTransfer(ctx, pending); err != nil {
+			return nil, err
+		}
+		if err := createAuthorityOperate(ctx, clk, operateStore, account.PubKey, MATTransferAuthority, pending); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := transferStore.CreateAccountAuthorityTransfer(ctx, transfer); err != nil {
+		return nil, err
+	}
+	if err := createAuthorityOperate(ctx, clk, operateStore, account.PubKey, MATTransferAuthority, transfer); err != nil {
+		return nil, err
+	}
+	return transfer, nil
+}
+
+// CancelAccountAuthorityTransfer cancels the pending transfer of the account on behalf of either side
+// and writes an audit Operate for it
+func CancelAccountAuthorityTransfer(ctx context.Context, clk clock.Clock, transferStore AccountAuthorityTransferStore, operateStore OperateStore, accountId uuid.UUID, pubKey string) (*AccountAuthorityTransfer, error) {
+	transfer, err := transferStore.GetPendingAccountAuthorityTransfer(ctx, accountId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, IllegalAccountAuthorityTransfer
+		}
+		return nil, err
+	}
+
+	if err := transfer.Cancel(clk, pubKey); err != nil {
+		return nil, err
+	}
+	if err := transferStore.UpdateAccountAuthorityTransfer(ctx, transfer); err != nil {
+		return nil, err
+	}
+	if err := createAuthorityOperate(ctx, clk, operateStore, pubKey, MATTransferAuthority, transfer); err != nil {
+		return nil, err
+	}
+	return transfer, nil
+}
+
+// AcceptAccountAuthorityTransfer records the new owner's side of a transfer, moves the account to
+// pubKey and writes an audit Operate for it
+func AcceptAccountAuthorityTransfer(ctx context.Context, clk clock.Clock, accountStore AccountStore, transferStore AccountAuthorityTransferStore, operateStore OperateStore, accountId uuid.UUID, pubKey string) (*Account, error) {
+	transfer, err := transferStore.GetPendingAccountAuthorityTransfer(ctx, accountId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, IllegalAccountAuthorityTransfer
+		}
+		return nil, err
+	}
+
+	account, err := accountStore.GetAccountById(ctx, accountId)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAuthoritySlotFree(ctx, accountStore, account, pubKey); err != nil {
+		return nil, err
+	}
+
+	if err := transfer.Accept(clk, account, pubKey); err != nil {
+		return nil, err
+	}
+
+	if err := accountStore.UpsertAccount(ctx, account); err != nil {
+		return nil, err
+	}
+	if err := transferStore.UpdateAccountAuthorityTransfer(ctx, transfer); err != nil {
+		return nil, err
+	}
+
+	if err := createAuthorityOperate(ctx, clk, operateStore, pubKey, MATAcceptAuthority, transfer); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+func createAuthorityOperate(ctx context.Context, clk clock.Clock, operateStore OperateStore, pubKey string, action MemoActionType, transfer *AccountAuthorityTransfer) error {
+	operate := NewOperate(clk, pubKey, transfer.AccountId, action, OperateDetail{
+		Type:      action,
+		AccountId: transfer.AccountId,
+		Actions:   []ActionDetail{},
+		Authority: &AuthorityDetail{
+			TransferId: transfer.Id,
+			FromPubKey: transfer.FromPubKey,
+			ToPubKey:   transfer.ToPubKey,
+			Status:     transfer.Status,
+		},
+	})
+	return operateStore.CreateOperate(ctx, &operate)
+}
+
+func checkAuthoritySlotFree(ctx context.Context, accountStore AccountStore, account *Account, pubKey string) error {
+	accounts, err := ListOpenAccountsByPubkey(ctx, accountStore, account.GroupId, pubKey)
+	if err != nil {
+		return err
+	}
+	for _, existing := range accounts {
+		if existing.Index == account.Index {
+			return IllegalAccountAuthorityTransfer
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/clock"
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+type authorityStore struct {
+	*loopStore
+	authorityTransfers []*AccountAuthorityTransfer
+}
+
+func (s *authorityStore) CreateAccountAuthorityTransfer(ctx context.Context, transfer *AccountAuthorityTransfer) error {
+	for _, existing := range s.authorityTransfers {
+		if existing.Id == transfer.Id {
+			return errors.New("duplicate transfer id")
+		}
+	}
+	s.authorityTransfers = append(s.authorityTransfers, transfer)
+	return nil
+}
+
+func (s *authorityStore) UpdateAccountAuthorityTransfer(ctx context.Context, transfer *AccountAuthorityTransfer) error {
+	return nil
+}
+
+func (s *authorityStore) GetPendingAccountAuthorityTransfer(ctx context.Context, accountId uuid.UUID) (*AccountAuthorityTransfer, error) {
+	for _, transfer := range s.authorityTransfers {
+		if transfer.AccountId == accountId && transfer.Status == AccountAuthorityTransferStatusPending {
+			return transfer, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func newAuthorityTest() (*authorityStore, *Account) {
+	store := &authorityStore{loopStore: newLoopStore()}
+	account := &Account{Id: uuid.Must(uuid.NewV4()), PubKey: "alice", AccountFlags: TransferAuthorityAllowedFlag}
+	store.accounts[account.Id] = account
+	return store, account
+}
+
+func TestAccountAuthorityTransferAccept(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewMock()
+	store, account := newAuthorityTest()
+
+	transfer, err := RequestAccountAuthorityTransfer(ctx, clk, store, store, store, account, "bob")
+	assert.NoError(t, err)
+	assert.Equal(t, AccountAuthorityTransferStatusPending, transfer.Status)
+
+	_, err = AcceptAccountAuthorityTransfer(ctx, clk, store, store, store, account.Id, "carol")
+	assert.ErrorIs(t, err, Unauthorized)
+
+	accepted, err := AcceptAccountAuthorityTransfer(ctx, clk, store, store, store, account.Id, "bob")
+	assert.NoError(t, err)
+	assert.Equal(t, "bob", accepted.PubKey)
+	assert.False(t, accepted.GetFlag(TransferAuthorityAllowedFlag))
+	assert.Equal(t, AccountAuthorityTransferStatusCompleted, transfer.Status)
+
+	assert.Len(t, store.operates, 2)
+	assert.Equal(t, MATTransferAuthority, store.operates[0].Op)
+	assert.Equal(t, "alice", store.operates[0].PubKey)
+	assert.Equal(t, MATAcceptAuthority, store.operates[1].Op)
+	assert.Equal(t, transfer.Id, store.operates[1].Extra.Authority.TransferId)
+	assert.Equal(t, AccountAuthorityTransferStatusCompleted, store.operates[1].Extra.Authority.Status)
+}
+
+func TestAccountAuthorityTransferCancelAndRequestAgain(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewMock()
+	store, account := newAuthorityTest()
+
+	first, err := RequestAccountAuthorityTransfer(ctx, clk, store, store, store, account, "bob")
+	assert.NoError(t, err)
+	_, err = CancelAccountAuthorityTransfer(ctx, clk, store, store, account.Id, "carol")
+	assert.ErrorIs(t, err, Unauthorized)
+	cancelled, err := CancelAccountAuthorityTransfer(ctx, clk, store, store, account.Id, "bob")
+	assert.NoError(t, err)
+	assert.Equal(t, AccountAuthorityTransferStatusCancelled, cancelled.Status)
+
+	// the same pubkey can be asked again, the new request does not collide with the cancelled one
+	second, err := RequestAccountAuthorityTransfer(ctx, clk, store, store, store, account, "bob")
+	assert.NoError(t, err)
+	assert.NotEqual(t, first.Id, second.Id)
+
+	// requesting again while pending cancels the pending transfer
+	third, err := RequestAccountAuthorityTransfer(ctx, clk, store, store, store, account, "bob")
+	assert.NoError(t, err)
+	assert.NotEqual(t, second.Id, third.Id)
+	assert.Equal(t, AccountAuthorityTransferStatusCancelled, second.Status)
+	assert.Len(t, store.authorityTransfers, 3)
+	// request, cancel, request, cancel and request
+	assert.Len(t, store.operates, 5)
+}
+
+func TestAccountAuthorityTransferExpired(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewMock()
+	store, account := newAuthorityTest()
+
+	_, err := RequestAccountAuthorityTransfer(ctx, clk, store, store, store, account, "bob")
+	assert.NoError(t, err)
+
+	clk.Add((ACCOUNT_AUTHORITY_TRANSFER_TTL + 1) * time.Second)
+	_, err = AcceptAccountAuthorityTransfer(ctx, clk, store, store, store, account.Id, "bob")
+	assert.ErrorIs(t, err, IllegalAccountAuthorityTransfer)
+	assert.Equal(t, "alice", store.accounts[account.Id].PubKey)
+
+	// an expired transfer can be replaced by a new request
+	transfer, err := RequestAccountAuthorityTransfer(ctx, clk, store, store, store, account, "bob")
+	assert.NoError(t, err)
+	_, err = AcceptAccountAuthorityTransfer(ctx, clk, store, store, store, account.Id, "bob")
+	assert.NoError(t, err)
+	assert.Equal(t, AccountAuthorityTransferStatusCompleted, transfer.Status)
+}
@@ -0,0 +1,104 @@
+package core
+
+import (
+	"context"
+
+	"github.com/DomeLiquid/core/utils"
+	"github.com/facebookgo/clock"
+	"github.com/gofrs/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// EmissionsPayout is the emissions settled for a balance that still has to be sent to the owner
+type EmissionsPayout struct {
+	BankId  uuid.UUID       `json:"bankId"`
+	AssetId string          `json:"assetId"`
+	Amount  decimal.Decimal `json:"amount"`
+}
+
+// CloseAccount settles the emissions of every active balance, closes the balances and marks the
+// account closed. Nothing is stored unless every balance is empty. The settled emissions are
+// recorded on the close Operate and sent to uid.
+func CloseAccount(ctx context.Context, clk clock.Clock, log Log, bankAccountService BankAccountService, bankAccountStore BankAccountWrapperStore, operateStore OperateStore, transferService TransferService, account *Account, requestId, uid string) ([]EmissionsPayout, error) {
+	if account.GetFlag(InFlashloanFlag) {
+		return nil, AccountInFlashloan
+	}
+	if account.GetFlag(DisabledFlag) {
+		return nil, AccountDisabled
+	}
+	if account.GetFlag(ClosedFlag) {
+		return nil, AccountClosed
+	}
+
+	balances, err := bankAccountService.ListBalances(ctx, account.Id, uuid.Nil)
+	if err != nil {
+		return nil, err
+	}
+
+	bankAccounts := make([]*BankAccountWrapper, 0, len(balances))
+	payouts := []EmissionsPayout{}
+	for _, balance := range balances {
+		if !balance.Active {
+			continue
+		}
+		bank, err := bankAccountService.GetBankById(ctx, balance.BankId)
+		if err != nil {
+			return nil, err
+		}
+		if err := bank.AccrueInterest(log, clk.Now().Unix()); err != nil {
+			return nil, err
+		}
+
+		ba := NewBankAccountWrapper(balance, bank, WithClock(clk), WithAccount(account))
+		if amount := ba.SettleEmissionsAndGetTransferAmount(log); amount.IsPositive() {
+			payouts = append(payouts, EmissionsPayout{
+				BankId:  bank.Id,
+				AssetId: bank.EmissionsMixinSafeAssetId,
+				Amount:  amount,
+			})
+		}
+		if err := ba.CloseBalance(log); err != nil {
+			return nil, err
+		}
+		bankAccounts = append(bankAccounts, ba)
+	}
+
+	for _, ba := range bankAccounts {
+		if err := bankAccountStore.StorageBankAccount(ctx, ba); err != nil {
+			return nil, err
+		}
+	}
+
+	account.SetFlag(ClosedFlag)
+	account.UpdatedAt = clk.Now().Unix()
+	if err := bankAccountService.UpsertAccount(ctx, account); err != nil {
+		return nil, err
+	}
+
+	operate := NewOperate(clk, account.PubKey, account.Id, MATAccountClose, OperateDetail{
+		Type:      MATAccountClose,
+		AccountId: account.Id,
+		Actions:   []ActionDetail{},
+		Emissions: payouts,
+	})
+	if err := operateStore.CreateOperate(ctx, &operate); err != nil {
+		return nil, err
+	}
+
+	if err := TransferEmissionsPayouts(ctx, transferService, requestId, uid, payouts); err != nil {
+		return nil, err
+	}
+	return payouts, nil
+}
+
+// TransferEmissionsPayouts sends the payouts to uid. The transfer ids derive from requestId and the
+// bank, so sending the payouts of the same request again is a no-op.
+func TransferEmissionsPayouts(ctx context.Context, transferService TransferService, requestId, uid string, payouts []EmissionsPayout) error {
+	for _, payout := range payouts {
+		transferId := utils.GenUuidFromStrings(requestId, payout.BankId.String(), "emissions")
+		if err := transferService.Transfer(ctx, transferId, uid, payout.AssetId, payout.Amount, "emissions"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
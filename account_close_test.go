@@ -0,0 +1,55 @@
+package core
+
+import (
+	"context"
+	"testing"
+
+	"github.com/facebookgo/clock"
+	"github.com/rs/zerolog"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCloseAccount(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewMock()
+	log := zerolog.Nop()
+	store, btc, usdt, account := newLoopTest()
+	btc.EmissionsMixinSafeAssetId = "emissions"
+	store.balances = append(store.balances, &Balance{
+		AccountId:            account.Id,
+		BankId:               btc.Id,
+		Active:               true,
+		AssetShares:          decimal.Zero,
+		LiabilityShares:      decimal.Zero,
+		EmissionsOutstanding: decimal.NewFromFloat(0.25),
+	})
+
+	payouts, err := CloseAccount(ctx, clk, &log, store.service(), store, store, store, account, "close", "uid")
+	assert.NoError(t, err)
+	assert.Len(t, payouts, 1)
+	assert.True(t, payouts[0].Amount.Equal(decimal.NewFromFloat(0.25)))
+	assert.True(t, account.GetFlag(ClosedFlag))
+
+	assert.Len(t, store.transfers, 1)
+	assert.Equal(t, "emissions", store.transfers[0].assetId)
+	assert.True(t, store.transfers[0].amount.Equal(decimal.NewFromFloat(0.25)))
+	assert.Len(t, store.operates, 1)
+	assert.Equal(t, payouts, store.operates[0].Extra.Emissions)
+
+	_, err = CloseAccount(ctx, clk, &log, store.service(), store, store, store, account, "close", "uid")
+	assert.ErrorIs(t, err, AccountClosed)
+
+	// a balance that is not empty keeps the account open and nothing is sent
+	store, btc, usdt, account = newLoopTest()
+	openLoopPosition(store, account, btc, usdt, decimal.NewFromInt(3), decimal.NewFromInt(200))
+	_, err = CloseAccount(ctx, clk, &log, store.service(), store, store, store, account, "close", "uid")
+	assert.ErrorIs(t, err, IllegalBalanceState)
+	assert.False(t, account.GetFlag(ClosedFlag))
+	assert.Empty(t, store.transfers)
+	assert.Empty(t, store.operates)
+
+	account.SetFlag(InFlashloanFlag)
+	_, err = CloseAccount(ctx, clk, &log, store.service(), store, store, store, account, "close", "uid")
+	assert.ErrorIs(t, err, AccountInFlashloan)
+}
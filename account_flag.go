@@ -0,0 +1,54 @@
+package core
+
+import (
+	"context"
+
+	"github.com/facebookgo/clock"
+)
+
+// SetAccountFlag lets the group admin set one of the AdminAccountFlags on an account
+func SetAccountFlag(ctx context.Context, clk clock.Clock, accountStore AccountStore, operateStore OperateStore, group *Group, adminKey string, account *Account, flag AccountFlags) error {
+	return updateAccountFlag(ctx, clk, accountStore, operateStore, group, adminKey, account, flag, MATSetAccountFlag)
+}
+
+// UnsetAccountFlag lets the group admin unset one of the AdminAccountFlags on an account
+func UnsetAccountFlag(ctx context.Context, clk clock.Clock, accountStore AccountStore, operateStore OperateStore, group *Group, adminKey string, account *Account, flag AccountFlags) error {
+	return updateAccountFlag(ctx, clk, accountStore, operateStore, group, adminKey, account, flag, MATUnsetAccountFlag)
+}
+
+func updateAccountFlag(ctx context.Context, clk clock.Clock, accountStore AccountStore, operateStore OperateStore, group *Group, adminKey string, account *Account, flag AccountFlags, action MemoActionType) error {
+	if err := ValidateAdminAccountFlag(flag); err != nil {
+		return err
+	}
+	if adminKey == "" || adminKey != group.AdminKey {
+		return Unauthorized
+	}
+	if account.GroupId != group.Id {
+		return InvalidAction
+	}
+	if account.GetFlag(ClosedFlag) {
+		return AccountClosed
+	}
+
+	switch action {
+	case MATSetAccountFlag:
+		account.SetFlag(flag)
+	case MATUnsetAccountFlag:
+		account.UnsetFlag(flag)
+	default:
+		return InvalidAction
+	}
+	account.UpdatedAt = clk.Now().Unix()
+
+	if err := accountStore.UpsertAccount(ctx, account); err != nil {
+		return err
+	}
+
+	operate := NewOperate(clk, adminKey, account.Id, action, OperateDetail{
+		Type:        action,
+		AccountId:   account.Id,
+		Actions:     []ActionDetail{},
+		AccountFlag: flag,
+	})
+	return operateStore.CreateOperate(ctx, &operate)
+}
@@ -0,0 +1,117 @@
+package core
+
+import (
+	"context"
+	"testing"
+
+	"github.com/facebookgo/clock"
+	"github.com/gofrs/uuid"
+	"github.com/rs/zerolog"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateAdminAccountFlag(t *testing.T) {
+	tests := []struct {
+		name    string
+		flag    AccountFlags
+		wantErr bool
+	}{
+		{name: "disabled", flag: DisabledFlag},
+		{name: "flashloan enabled", flag: FlashloanEnabledFlag},
+		{name: "transfer authority allowed", flag: TransferAuthorityAllowedFlag},
+		{name: "disabled and transfer authority allowed", flag: DisabledFlag | TransferAuthorityAllowedFlag},
+		{name: "empty", flag: 0, wantErr: true},
+		{name: "in flashloan", flag: InFlashloanFlag, wantErr: true},
+		{name: "closed", flag: ClosedFlag, wantErr: true},
+		{name: "disabled and in flashloan", flag: DisabledFlag | InFlashloanFlag, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateAdminAccountFlag(tt.flag)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, IllegalFlag)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestBankAccountWrapperDisabledAccount(t *testing.T) {
+	log := zerolog.Nop()
+	clk := clock.NewMock()
+	account := &Account{Id: uuid.Must(uuid.NewV4())}
+	account.SetFlag(DisabledFlag)
+
+	newWrapper := func(assetShares, liabilityShares int64) *BankAccountWrapper {
+		bank := newLoopBank("usdt", 0.9, 0.95)
+		bank.TotalAssetShares = decimal.NewFromInt(1000 + assetShares)
+		bank.TotalLiabilityShares = decimal.NewFromInt(liabilityShares)
+		balance := &Balance{
+			AccountId:       account.Id,
+			BankId:          bank.Id,
+			Active:          true,
+			AssetShares:     decimal.NewFromInt(assetShares),
+			LiabilityShares: decimal.NewFromInt(liabilityShares),
+		}
+		return NewBankAccountWrapper(balance, bank, WithClock(clk), WithAccount(account))
+	}
+	ten := decimal.NewFromInt(10)
+
+	// new deposits and borrows are blocked
+	assert.ErrorIs(t, newWrapper(0, 0).Deposit(&log, ten), AccountDisabled)
+	assert.ErrorIs(t, newWrapper(0, 0).Borrow(&log, ten), AccountDisabled)
+	assert.ErrorIs(t, newWrapper(5, 0).Borrow(&log, ten), AccountDisabled)
+	assert.ErrorIs(t, newWrapper(0, 5).Deposit(&log, ten), AccountDisabled)
+
+	// the account can still unwind and be liquidated
+	assert.NoError(t, newWrapper(0, 10).Repay(&log, ten))
+	assert.NoError(t, newWrapper(10, 0).Withdraw(&log, ten))
+	assert.NoError(t, newWrapper(0, 0).IncreaseBalanceInLiquidation(&log, ten))
+	assert.NoError(t, newWrapper(0, 0).DecreaseBalanceInLiquidation(&log, ten))
+
+	account.UnsetFlag(DisabledFlag)
+	assert.NoError(t, newWrapper(0, 0).Deposit(&log, ten))
+	assert.NoError(t, newWrapper(0, 0).Borrow(&log, ten))
+}
+
+// rawAccountStore returns every account of the group from ListAccountByPubkey, like a store
+// that looks accounts up by their derived id
+type rawAccountStore struct {
+	*loopStore
+}
+
+func (s *rawAccountStore) ListAccountByPubkey(ctx context.Context, groupId uuid.UUID, pubkey string) ([]*Account, error) {
+	accounts := []*Account{}
+	for _, account := range s.accounts {
+		if account.GroupId == groupId {
+			accounts = append(accounts, account)
+		}
+	}
+	return accounts, nil
+}
+
+func TestListOpenAccountsByPubkey(t *testing.T) {
+	ctx := context.Background()
+	store := &rawAccountStore{loopStore: newLoopStore()}
+	open := &Account{Id: uuid.Must(uuid.NewV4()), PubKey: "alice"}
+	closed := &Account{Id: uuid.Must(uuid.NewV4()), PubKey: "alice", Index: 1, AccountFlags: ClosedFlag}
+	transferred := &Account{Id: NewAccountId(uuid.Nil, "alice", 2, 0), PubKey: "bob", Index: 2}
+	for _, account := range []*Account{open, closed, transferred} {
+		store.accounts[account.Id] = account
+	}
+
+	accounts, err := ListOpenAccountsByPubkey(ctx, store, uuid.Nil, "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, []*Account{open}, accounts)
+
+	// the index of the closed account is free again, under a new id
+	account, err := CreateAccountForPubkey(ctx, clock.NewMock(), store, uuid.Nil, "alice", 1)
+	assert.NoError(t, err)
+	assert.NotEqual(t, closed.Id, account.Id)
+	account, err = CreateAccountForPubkey(ctx, clock.NewMock(), store, uuid.Nil, "alice", 2)
+	assert.NoError(t, err)
+	assert.NotEqual(t, transferred.Id, account.Id)
+}
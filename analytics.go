@@ -0,0 +1,259 @@
+package core
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/facebookgo/clock"
+	"github.com/gofrs/uuid"
+	"github.com/shopspring/decimal"
+)
+
+type (
+	AnalyticsStore interface {
+		CreateAnalytics(ctx context.Context, analytics []*Analytics) error
+		// ListAnalytics lists the snapshots of the group created in [from, to], oldest first, uuid.Nil
+		// is the protocol
+		ListAnalytics(ctx context.Context, groupId uuid.UUID, from, to int64) ([]*Analytics, error)
+	}
+
+	// AnalyticsAmounts are the totals of a set of banks, either in the asset or in USD
+	AnalyticsAmounts struct {
+		Deposits        decimal.Decimal `json:"deposits"`
+		Borrows         decimal.Decimal `json:"borrows"`
+		Tvl             decimal.Decimal `json:"tvl"`
+		UtilizationRate decimal.Decimal `json:"utilizationRate"`
+		// FeeRevenue is the group fees collected into the fee vault plus the outstanding ones
+		FeeRevenue decimal.Decimal `json:"feeRevenue"`
+		// InsuranceFund is the insurance vault plus the outstanding insurance fees
+		InsuranceFund decimal.Decimal `json:"insuranceFund"`
+	}
+
+	BankAnalytics struct {
+		BankId           uuid.UUID        `json:"bankId"`
+		GroupId          uuid.UUID        `json:"groupId"`
+		Name             string           `json:"name"`
+		MixinSafeAssetId string           `json:"mixinSafeAssetId"`
+		Price            decimal.Decimal  `json:"price"`
+		Native           AnalyticsAmounts `json:"native"`
+		Usd              AnalyticsAmounts `json:"usd"`
+	}
+
+	AssetAnalytics struct {
+		MixinSafeAssetId string           `json:"mixinSafeAssetId"`
+		Native           AnalyticsAmounts `json:"native"`
+		Usd              AnalyticsAmounts `json:"usd"`
+	}
+
+	// Analytics is a snapshot of a group, or of the protocol when GroupId is uuid.Nil
+	Analytics struct {
+		Id        uuid.UUID         `json:"id"`
+		GroupId   uuid.UUID         `json:"groupId"`
+		Usd       AnalyticsAmounts  `json:"usd"`
+		Banks     []*BankAnalytics  `json:"banks"`
+		Assets    []*AssetAnalytics `json:"assets"`
+		CreatedAt int64             `json:"createdAt"`
+	}
+
+	AnalyticsSnapshotter struct {
+		clk            clock.Clock
+		log            Log
+		bankStore      BankStore
+		analyticsStore AnalyticsStore
+		priceFeedMgr   PriceAdapterMgr
+	}
+)
+
+func NewAnalyticsAmounts() AnalyticsAmounts {
+	return AnalyticsAmounts{
+		Deposits:        decimal.Zero,
+		Borrows:         decimal.Zero,
+		Tvl:             decimal.Zero,
+		UtilizationRate: decimal.Zero,
+		FeeRevenue:      decimal.Zero,
+		InsuranceFund:   decimal.Zero,
+	}
+}
+
+func (a AnalyticsAmounts) Add(other AnalyticsAmounts) AnalyticsAmounts {
+	sum := AnalyticsAmounts{
+		Deposits:      a.Deposits.Add(other.Deposits),
+		Borrows:       a.Borrows.Add(other.Borrows),
+		Tvl:           a.Tvl.Add(other.Tvl),
+		FeeRevenue:    a.FeeRevenue.Add(other.FeeRevenue),
+		InsuranceFund: a.InsuranceFund.Add(other.InsuranceFund),
+	}
+	sum.UtilizationRate = computeAnalyticsUtilization(sum.Deposits, sum.Borrows)
+	return sum
+}
+
+func (a AnalyticsAmounts) Mul(price decimal.Decimal) AnalyticsAmounts {
+	return AnalyticsAmounts{
+		Deposits:        a.Deposits.Mul(price),
+		Borrows:         a.Borrows.Mul(price),
+		Tvl:             a.Tvl.Mul(price),
+		UtilizationRate: a.UtilizationRate,
+		FeeRevenue:      a.FeeRevenue.Mul(price),
+		InsuranceFund:   a.InsuranceFund.Mul(price),
+	}
+}
+
+func computeAnalyticsUtilization(deposits, borrows decimal.Decimal) decimal.Decimal {
+	if deposits.IsZero() {
+		return decimal.Zero
+	}
+	return borrows.Div(deposits)
+}
+
+// ComputeBankAnalytics returns the totals of the bank in the asset and at price
+func (b *Bank) ComputeBankAnalytics(price decimal.Decimal) *BankAnalytics {
+	deposits := b.GetTotalAssetQuantity()
+	borrows := b.GetTotalLiabilityQuantity()
+	native := AnalyticsAmounts{
+		Deposits:        deposits,
+		Borrows:         borrows,
+		Tvl:             deposits.Sub(borrows),
+		UtilizationRate: b.ComputeUtilizationRate(),
+		FeeRevenue:      b.FeeVault.Add(b.CollectedGroupFeesOutstanding),
+		InsuranceFund:   b.InsuranceVault.Add(b.CollectedInsuranceFeesOutstanding),
+	}
+
+	usd := native.Mul(price)
+	usd.Tvl = b.ComputeTvl(price)
+	return &BankAnalytics{
+		BankId:           b.Id,
+		GroupId:          b.GroupId,
+		Name:             b.Name,
+		MixinSafeAssetId: b.MixinSafeAssetId,
+		Price:            price,
+		Native:           native,
+		Usd:              usd,
+	}
+}
+
+// ComputeAnalytics aggregates the banks per asset and in total, the banks are priced with the
+// real time price of priceFeedMgr
+func ComputeAnalytics(priceFeedMgr PriceAdapterMgr, groupId uuid.UUID, banks []*Bank, createdAt int64) (*Analytics, error) {
+	analytics := &Analytics{
+		Id:        uuid.Must(uuid.NewV4()),
+		GroupId:   groupId,
+		Usd:       NewAnalyticsAmounts(),
+		Banks:     make([]*BankAnalytics, 0, len(banks)),
+		Assets:    []*AssetAnalytics{},
+		CreatedAt: createdAt,
+	}
+
+	assets := map[string]*AssetAnalytics{}
+	for _, bank := range banks {
+		price, err := getRealTimePrice(priceFeedMgr, bank)
+		if err != nil {
+			return nil, err
+		}
+		bankAnalytics := bank.ComputeBankAnalytics(price)
+		analytics.Banks = append(analytics.Banks, bankAnalytics)
+		analytics.Usd = analytics.Usd.Add(bankAnalytics.Usd)
+
+		asset, ok := assets[bank.MixinSafeAssetId]
+		if !ok {
+			asset = &AssetAnalytics{
+				MixinSafeAssetId: bank.MixinSafeAssetId,
+				Native:           NewAnalyticsAmounts(),
+				Usd:              NewAnalyticsAmounts(),
+			}
+			assets[bank.MixinSafeAssetId] = asset
+			analytics.Assets = append(analytics.Assets, asset)
+		}
+		asset.Native = asset.Native.Add(bankAnalytics.Native)
+		asset.Usd = asset.Usd.Add(bankAnalytics.Usd)
+	}
+
+	sort.Slice(analytics.Assets, func(i, j int) bool {
+		return analytics.Assets[i].Usd.Tvl.GreaterThan(analytics.Assets[j].Usd.Tvl)
+	})
+	return analytics, nil
+}
+
+// ComputeGroupAnalytics returns the analytics of the banks of the group
+func ComputeGroupAnalytics(ctx context.Context, clk clock.Clock, bankStore BankStore, priceFeedMgr PriceAdapterMgr, groupId uuid.UUID) (*Analytics, error) {
+	banks, err := bankStore.ListBankByGroupId(ctx, groupId)
+	if err != nil {
+		return nil, err
+	}
+	return ComputeAnalytics(priceFeedMgr, groupId, ExcludeDeletedBanks(banks), clk.Now().Unix())
+}
+
+// ComputeProtocolAnalytics returns the analytics of all banks
+func ComputeProtocolAnalytics(ctx context.Context, clk clock.Clock, bankStore BankStore, priceFeedMgr PriceAdapterMgr) (*Analytics, error) {
+	banks, err := bankStore.ListBank(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ComputeAnalytics(priceFeedMgr, uuid.Nil, ExcludeDeletedBanks(banks), clk.Now().Unix())
+}
+
+func NewAnalyticsSnapshotter(clk clock.Clock, log Log, bankStore BankStore, analyticsStore AnalyticsStore, priceFeedMgr PriceAdapterMgr) *AnalyticsSnapshotter {
+	return &AnalyticsSnapshotter{
+		clk:            clk,
+		log:            log,
+		bankStore:      bankStore,
+		analyticsStore: analyticsStore,
+		priceFeedMgr:   priceFeedMgr,
+	}
+}
+
+// Snapshot stores the protocol analytics and the analytics of every group with banks, all with
+// the same prices and timestamp
+func (s *AnalyticsSnapshotter) Snapshot(ctx context.Context) ([]*Analytics, error) {
+	banks, err := s.bankStore.ListBank(ctx)
+	if err != nil {
+		return nil, err
+	}
+	banks = ExcludeDeletedBanks(banks)
+	createdAt := s.clk.Now().Unix()
+	prices := NewPriceSnapshot(s.priceFeedMgr)
+
+	protocol, err := ComputeAnalytics(prices, uuid.Nil, banks, createdAt)
+	if err != nil {
+		return nil, err
+	}
+	snapshots := []*Analytics{protocol}
+
+	groupBanks := map[uuid.UUID][]*Bank{}
+	groupIds := []uuid.UUID{}
+	for _, bank := range banks {
+		if _, ok := groupBanks[bank.GroupId]; !ok {
+			groupIds = append(groupIds, bank.GroupId)
+		}
+		groupBanks[bank.GroupId] = append(groupBanks[bank.GroupId], bank)
+	}
+	for _, groupId := range groupIds {
+		group, err := ComputeAnalytics(prices, groupId, groupBanks[groupId], createdAt)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, group)
+	}
+
+	if err := s.analyticsStore.CreateAnalytics(ctx, snapshots); err != nil {
+		return nil, err
+	}
+	return snapshots, nil
+}
+
+// Run takes a snapshot every interval until ctx is done
+func (s *AnalyticsSnapshotter) Run(ctx context.Context, interval time.Duration) error {
+	ticker := s.clk.Ticker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if _, err := s.Snapshot(ctx); err != nil {
+				s.log.Error().Msgf("analytics snapshot failed: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package core
+
+import (
+	"context"
+	"testing"
+
+	"github.com/facebookgo/clock"
+	"github.com/rs/zerolog"
+
+	"github.com/gofrs/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+type analyticsStore struct {
+	ratesStore
+	AnalyticsStore
+	analytics []*Analytics
+}
+
+func (s *analyticsStore) ListBank(ctx context.Context) ([]*Bank, error) {
+	return s.banks, nil
+}
+
+func (s *analyticsStore) CreateAnalytics(ctx context.Context, analytics []*Analytics) error {
+	s.analytics = append(s.analytics, analytics...)
+	return nil
+}
+
+// movingPriceFeedMgr moves the price up on every adapter it hands out
+type movingPriceFeedMgr struct {
+	price decimal.Decimal
+}
+
+func (m *movingPriceFeedMgr) GetPriceAdapter(bank *Bank) (PriceAdapter, error) {
+	m.price = m.price.Add(ONE)
+	return ratesPriceAdapter(m.price), nil
+}
+
+func TestComputeAnalytics(t *testing.T) {
+	newBank := func(groupId uuid.UUID, assetId string, deposits, borrows int64) *Bank {
+		return &Bank{
+			Id:                   uuid.Must(uuid.NewV4()),
+			GroupId:              groupId,
+			MixinSafeAssetId:     assetId,
+			AssetShareValue:      ONE,
+			LiabilityShareValue:  ONE,
+			TotalAssetShares:     decimal.NewFromInt(deposits),
+			TotalLiabilityShares: decimal.NewFromInt(borrows),
+			FeeVault:             ONE,
+			InsuranceVault:       decimal.NewFromInt(2),
+		}
+	}
+	groupA, groupB := uuid.Must(uuid.NewV4()), uuid.Must(uuid.NewV4())
+	banks := []*Bank{
+		newBank(groupA, "btc", 10, 5),
+		newBank(groupA, "usdt", 1000, 800),
+		newBank(groupB, "btc", 30, 0),
+	}
+	prices := ratesPriceFeedMgr{"btc": decimal.NewFromInt(100), "usdt": ONE}
+
+	analytics, err := ComputeAnalytics(prices, uuid.Nil, banks, 0)
+	assert.NoError(t, err)
+	assert.Len(t, analytics.Banks, 3)
+	assert.True(t, analytics.Usd.Deposits.Equal(decimal.NewFromInt(5000)))
+	assert.True(t, analytics.Usd.Borrows.Equal(decimal.NewFromInt(1300)))
+	assert.True(t, analytics.Usd.Tvl.Equal(decimal.NewFromInt(3700)))
+	assert.True(t, analytics.Usd.UtilizationRate.Equal(decimal.NewFromFloat(0.26)))
+	assert.True(t, analytics.Usd.FeeRevenue.Equal(decimal.NewFromInt(201)))
+
+	assert.Len(t, analytics.Assets, 2)
+	btc := analytics.Assets[0]
+	assert.Equal(t, "btc", btc.MixinSafeAssetId)
+	assert.True(t, btc.Native.Deposits.Equal(decimal.NewFromInt(40)))
+	assert.True(t, btc.Native.InsuranceFund.Equal(decimal.NewFromInt(4)))
+	assert.True(t, btc.Usd.Tvl.Equal(decimal.NewFromInt(3500)))
+}
+
+func TestAnalyticsSnapshotterSamePrices(t *testing.T) {
+	log := zerolog.Nop()
+	groupA, groupB := uuid.Must(uuid.NewV4()), uuid.Must(uuid.NewV4())
+	banks := []*Bank{
+		{Id: uuid.Must(uuid.NewV4()), GroupId: groupA, MixinSafeAssetId: "btc", AssetShareValue: ONE, LiabilityShareValue: ONE, TotalAssetShares: decimal.NewFromInt(10)},
+		{Id: uuid.Must(uuid.NewV4()), GroupId: groupB, MixinSafeAssetId: "usdt", AssetShareValue: ONE, LiabilityShareValue: ONE, TotalAssetShares: decimal.NewFromInt(100)},
+	}
+	store := &analyticsStore{ratesStore: ratesStore{banks: banks}}
+	snapshotter := NewAnalyticsSnapshotter(clock.NewMock(), &log, store, store, &movingPriceFeedMgr{price: decimal.Zero})
+
+	snapshots, err := snapshotter.Snapshot(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, snapshots, 3)
+	// the groups add up to the protocol because every bank is priced once
+	groups := snapshots[1].Usd.Deposits.Add(snapshots[2].Usd.Deposits)
+	assert.True(t, snapshots[0].Usd.Deposits.Equal(groups), "expected %s, got %s", snapshots[0].Usd.Deposits, groups)
+	assert.Len(t, store.analytics, 3)
+}
@@ -6,7 +6,6 @@ import (
 	"github.com/facebookgo/clock"
 	"github.com/gofrs/uuid"
 	"github.com/shopspring/decimal"
-	"gorm.io/gorm"
 )
 
 type (
@@ -41,22 +40,43 @@ func NewBalance(clk clock.Clock, accountId, bankId uuid.UUID) *Balance {
 	}
 }
 
-func FindOrCreateBalance(ctx context.Context, clk clock.Clock, bankAccountService BankAccountService, bank *Bank, account *Account) (*Balance, error) {
+// FindOrCreateBalance returns the active balance of the account in the bank, a new or reopened
+// balance counts against maxActiveBalances of the group
+func FindOrCreateBalance(ctx context.Context, clk clock.Clock, bankAccountService BankAccountService, bank *Bank, account *Account, maxActiveBalances int) (*Balance, error) {
 	_, err := bankAccountService.GetBankById(ctx, bank.Id)
 	if err != nil {
 		return nil, BankAccountNotFound
 	}
 
-	balance, err := bankAccountService.FindBalance(ctx, bank.Id, account.Id)
+	// one read finds the balance and counts the active ones
+	balances, err := bankAccountService.ListBalances(ctx, account.Id, uuid.Nil)
 	if err != nil {
-		if err == gorm.ErrRecordNotFound {
-			balance = NewBalance(clk, account.Id, bank.Id)
-			err = bankAccountService.UpsertBalance(ctx, balance)
-			if err != nil {
-				return nil, err
-			}
-			return balance, nil
+		return nil, err
+	}
+	var balance *Balance
+	active := 0
+	for _, b := range balances {
+		if b.BankId == bank.Id {
+			balance = b
 		}
+		if b.Active {
+			active++
+		}
+	}
+	if balance != nil && balance.Active {
+		return balance, nil
+	}
+	if active >= maxActiveBalances {
+		return nil, AccountTempActiveBalanceLimitExceeded
+	}
+
+	if balance == nil {
+		balance = NewBalance(clk, account.Id, bank.Id)
+	} else {
+		balance.Active = true
+		balance.LastUpdate = clk.Now().Unix()
+	}
+	if err := bankAccountService.UpsertBalance(ctx, balance); err != nil {
 		return nil, err
 	}
 	return balance, nil
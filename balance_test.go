@@ -0,0 +1,44 @@
+package core
+
+import (
+	"context"
+	"testing"
+
+	"github.com/facebookgo/clock"
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindOrCreateBankAccountWrapperActiveBalanceLimit(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewMock()
+	btc, eth, usdt := newLoopBank("btc", 0.8, 0.9), newLoopBank("eth", 0.8, 0.9), newLoopBank("usdt", 0.9, 0.95)
+	store := newLoopStore(btc, eth, usdt)
+	store.group.Config.MaxActiveBalances = 2
+	account := &Account{Id: uuid.Must(uuid.NewV4())}
+	svc := store.service()
+
+	_, err := FindOrCreateBankAccountWrapper(ctx, clk, svc, btc, account)
+	assert.NoError(t, err)
+	_, err = FindOrCreateBankAccountWrapper(ctx, clk, svc, eth, account)
+	assert.NoError(t, err)
+	_, err = FindOrCreateBankAccountWrapper(ctx, clk, svc, usdt, account)
+	assert.ErrorIs(t, err, AccountTempActiveBalanceLimitExceeded)
+
+	// an active balance is returned as is and a closed one frees its slot
+	_, err = FindOrCreateBankAccountWrapper(ctx, clk, svc, btc, account)
+	assert.NoError(t, err)
+	balance, err := store.FindBalance(ctx, btc.Id, account.Id)
+	assert.NoError(t, err)
+	balance.Active = false
+	_, err = FindOrCreateBankAccountWrapper(ctx, clk, svc, usdt, account)
+	assert.NoError(t, err)
+	_, err = FindOrCreateBankAccountWrapper(ctx, clk, svc, btc, account)
+	assert.ErrorIs(t, err, AccountTempActiveBalanceLimitExceeded)
+
+	// a service without a GroupStore runs on the default limit
+	svc.GroupStore = nil
+	_, err = FindOrCreateBankAccountWrapper(ctx, clk, svc, btc, account)
+	assert.NoError(t, err)
+	assert.True(t, balance.Active)
+}
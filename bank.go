@@ -2,6 +2,7 @@ package core
 
 import (
 	"context"
+	"errors"
 	"math"
 	"time"
 
@@ -12,6 +13,8 @@ import (
 )
 
 type (
+	// BankStore listings return deleted banks too, the lookups by id have to resolve the bank of old
+	// balances and operates. Callers that only want live banks filter with ExcludeDeletedBanks.
 	BankStore interface {
 		CreateBank(ctx context.Context, bank *Bank) error
 		UpsertBank(ctx context.Context, bank *Bank) error
@@ -23,6 +26,7 @@ type (
 		GetBankByMixinSafeAssetId(ctx context.Context, mixinSafeAssetId string) (*Bank, error)
 		UpdateBankConfig(ctx context.Context, bankId uuid.UUID, bankConfig *BankConfig) error
 		UpdateBank(ctx context.Context, bankId uuid.UUID, bank *Bank) error
+		SoftDeleteBank(ctx context.Context, bankId uuid.UUID, deletedAt int64) error
 	}
 
 	Bank struct {
@@ -56,7 +60,12 @@ type (
 		CreatedAt  int64 `json:"createdAt"`
 		LastUpdate int64 `json:"lastUpdate"`
 
+		// DelistAt is set when the bank is deprecated, it is reduce only from then on
+		DelistAt  int64 `json:"delistAt"`
 		DeletedAt int64 `json:"deletedAt"`
+
+		ledger   *Ledger            `json:"-"`
+		accruals []*InterestAccrual `json:"-"`
 	}
 
 	BankConfig struct {
@@ -267,19 +276,10 @@ func (bs BalanceSide) String() string {
 	}
 }
 
+// ValidateBankConfig checks the bank config against the default group config,
+// use GroupConfig.ValidateBankConfig for a bank of a configured group
 func ValidateBankConfig(bankConfig *BankConfig) error {
-	oracleAis := bankConfig.OracleSetup
-	oracleMaxAge := bankConfig.OracleMaxAge
-
-	switch oracleAis {
-	case MixinOracle:
-		if oracleMaxAge > 90 {
-			return ErrOracleMaxAgeTooLong
-		}
-	default:
-		return ErrUnknownOracleSetup
-	}
-	return nil
+	return DefaultGroupConfig().ValidateBankConfig(bankConfig)
 }
 
 func (bc *BankConfig) GetWeights(requirementType RequirementType) (decimal.Decimal, decimal.Decimal) {
@@ -338,6 +338,10 @@ func (bc *BankConfig) Validate() error {
 		return err
 	}
 
+	if bc.OperationalState > BankOperationalStateNone || bc.RiskTier > Isolated {
+		return InvalidConfig
+	}
+
 	if bc.RiskTier == Isolated {
 		if !assetInitW.Equal(decimal.Zero) {
 			return InvalidConfig
@@ -388,6 +392,20 @@ func NewBankWithCreateTime(clk clock.Clock, groupId uuid.UUID, name string, mixi
 	}
 }
 
+// AccruedClones returns clones of banks with the interest accrued up to now, for valuations that
+// must not change the stored banks. A liquidity deficit does not stop the accrual of a clone.
+func AccruedClones(log Log, now int64, banks []*Bank) ([]*Bank, error) {
+	clones := make([]*Bank, 0, len(banks))
+	for _, bank := range banks {
+		clone := bank.Clone()
+		if err := clone.AccrueInterest(log, now); err != nil && !errors.Is(err, ErrBankLiquidityDeficit) {
+			return nil, err
+		}
+		clones = append(clones, clone)
+	}
+	return clones, nil
+}
+
 func (b *Bank) Clone() *Bank {
 	return &Bank{
 		Id:                                b.Id,
@@ -410,9 +428,19 @@ func (b *Bank) Clone() *Bank {
 		EmissionsRemaining:                b.EmissionsRemaining,
 		CreatedAt:                         b.CreatedAt,
 		LastUpdate:                        b.LastUpdate,
+		DelistAt:                          b.DelistAt,
+		DeletedAt:                         b.DeletedAt,
 	}
 }
 
+func (b *Bank) IsDeprecated() bool {
+	return b.DelistAt != 0
+}
+
+func (b *Bank) IsDeleted() bool {
+	return b.DeletedAt != 0
+}
+
 func (b *Bank) GetFlag(flag BankFlags) bool {
 	return b.Flags&flag == flag
 }
@@ -577,6 +605,7 @@ func (b *Bank) AccrueInterest(log Log, currentTimestamp int64) error {
 	if timeDelta <= 0 {
 		return nil
 	}
+	accrual := b.newInterestAccrual(currentTimestamp)
 	b.LastUpdate = currentTimestamp
 
 	totalAssets, err := b.GetAssetAmount(b.TotalAssetShares)
@@ -587,26 +616,43 @@ func (b *Bank) AccrueInterest(log Log, currentTimestamp int64) error {
 	if err != nil {
 		return err
 	}
-	if totalAssets.IsZero() || totalLiabilities.IsZero() {
+	// without liabilities nothing accrues and the rates stay zero, the curve is not evaluated
+	if !totalAssets.IsPositive() || !totalLiabilities.IsPositive() {
+		b.recordInterestAccrual(accrual)
 		return nil
 	}
 
+	accrual.UtilizationRate = totalLiabilities.Div(totalAssets)
+	accrual.LendingApr, accrual.BorrowingApr, accrual.GroupFeeApr, accrual.InsuranceFeeApr, err = b.BankConfig.InterestRateConfig.CalcInterestRate(accrual.UtilizationRate)
+	if err != nil {
+		return err
+	}
+	log.Debug().Msgf("timeDelta: %d,utilizationRate: %s, lendingApr: %s, borrowingApr: %s, groupFeeApr: %s, insuranceFeeApr: %s", timeDelta, accrual.UtilizationRate, accrual.LendingApr, accrual.BorrowingApr, accrual.GroupFeeApr, accrual.InsuranceFeeApr)
+
 	accruedAssetShareValue, accruedLiabilityShareValue, groupFeePaymentForPeriod, insuranceFeePaymentForPeriod, err :=
-		CalcInterestRateAccrualStateChanges(log, uint64(timeDelta), totalAssets, totalLiabilities, b.BankConfig.InterestRateConfig, b.AssetShareValue, b.LiabilityShareValue)
+		CalcInterestAccrualForRates(uint64(timeDelta), totalLiabilities, accrual.LendingApr, accrual.BorrowingApr, accrual.GroupFeeApr, accrual.InsuranceFeeApr, b.AssetShareValue, b.LiabilityShareValue)
 	if err != nil {
 		return err
 	}
 
+	accrual.GroupFeesCollected = groupFeePaymentForPeriod
+	accrual.InsuranceFeesCollected = insuranceFeePaymentForPeriod
+
 	b.AssetShareValue = accruedAssetShareValue
 	b.LiabilityShareValue = accruedLiabilityShareValue
 	b.CollectedGroupFeesOutstanding = b.CollectedGroupFeesOutstanding.Add(groupFeePaymentForPeriod)
 	b.CollectedInsuranceFeesOutstanding = b.CollectedInsuranceFeesOutstanding.Add(insuranceFeePaymentForPeriod)
 
 	// If the liquidity vault is positive, reduce the liquidity vault
+	feesFrom := LedgerAccountAccruedInterest
 	if b.LiquidityVault.IsPositive() {
 		b.LiquidityVault = b.LiquidityVault.Sub(insuranceFeePaymentForPeriod).Sub(groupFeePaymentForPeriod)
 		b.NormalizeLiquidityVault()
+		feesFrom = LedgerAccountLiquidityVault
 	}
+	b.ledger.Transfer(b, LedgerKindInterestFees, feesFrom, LedgerAccountGroupFeesOutstanding, groupFeePaymentForPeriod)
+	b.ledger.Transfer(b, LedgerKindInterestFees, feesFrom, LedgerAccountInsuranceFeesOutstanding, insuranceFeePaymentForPeriod)
+	b.recordInterestAccrual(accrual)
 
 	if b.LiquidityVault.IsNegative() {
 		return ErrBankLiquidityDeficit
@@ -618,11 +664,13 @@ func (b *Bank) AccrueInterest(log Log, currentTimestamp int64) error {
 func (b *Bank) DepositSplTransfer(amount decimal.Decimal, from, to *decimal.Decimal) {
 	*from = from.Sub(amount)
 	*to = to.Add(amount)
+	b.recordTransfer(LedgerKindDepositTransfer, amount, from, to)
 }
 
 func (b *Bank) WithdrawSplTransfer(amount decimal.Decimal, from, to *decimal.Decimal) {
 	*from = from.Sub(amount)
 	*to = to.Add(amount)
+	b.recordTransfer(LedgerKindWithdrawTransfer, amount, from, to)
 }
 
 func (b *Bank) SocializeLoss(lossAmount decimal.Decimal) error {
@@ -661,17 +709,20 @@ func (b *Bank) AssertOperationalMode(isAssetOrLiabilityAmountIncreasing bool) er
 func (b *Bank) TransferFromInsuranceToLiquidity(amount decimal.Decimal) error {
 	b.InsuranceVault = b.InsuranceVault.Sub(amount)
 	b.LiquidityVault = b.LiquidityVault.Add(amount)
+	b.recordTransfer(LedgerKindInsuranceToLiquidity, amount, &b.InsuranceVault, &b.LiquidityVault)
 	return nil
 }
 
 func (b *Bank) DepositTransfer(amount decimal.Decimal, from, to *decimal.Decimal) {
 	*from = from.Sub(amount)
 	*to = to.Add(amount)
+	b.recordTransfer(LedgerKindDepositTransfer, amount, from, to)
 }
 
 func (b *Bank) WithdrawTransfer(amount decimal.Decimal, from, to *decimal.Decimal) {
 	*from = from.Sub(amount)
 	*to = to.Add(amount)
+	b.recordTransfer(LedgerKindWithdrawTransfer, amount, from, to)
 }
 
 func (b *Bank) GetTotalAssetQuantity() decimal.Decimal {
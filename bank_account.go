@@ -15,7 +15,9 @@ type (
 	}
 
 	BankAccountWrapper struct {
-		clk clock.Clock `json:"-"`
+		clk          clock.Clock  `json:"-"`
+		accountFlags AccountFlags `json:"-"`
+		ledger       *Ledger      `json:"-"`
 
 		Balance *Balance `json:"balance"`
 		Bank    *Bank    `json:"bank"`
@@ -30,6 +32,35 @@ func WithClock(clk clock.Clock) OptionFunc {
 	}
 }
 
+// WithAccount lets the wrapper enforce the account flags, a disabled account can only repay,
+// withdraw and be liquidated
+func WithAccount(account *Account) OptionFunc {
+	return func(ba *BankAccountWrapper) {
+		ba.accountFlags = account.AccountFlags
+	}
+}
+
+// WithLedger records the vault movements of the wrapper in ledger. The ledger stays on the wrapper
+// and is only attached to the shared bank while one of its operations runs.
+func WithLedger(ledger *Ledger) OptionFunc {
+	return func(ba *BankAccountWrapper) {
+		ba.ledger = ledger
+	}
+}
+
+// attachLedger attaches the ledger of the wrapper to the bank, the returned func restores the
+// ledger the bank had before
+func (ba *BankAccountWrapper) attachLedger() func() {
+	if ba.ledger == nil {
+		return func() {}
+	}
+	previous := ba.Bank.ledger
+	ba.Bank.AttachLedger(ba.ledger)
+	return func() {
+		ba.Bank.AttachLedger(previous)
+	}
+}
+
 func NewBankAccountWrapper(balance *Balance, bank *Bank, opts ...OptionFunc) *BankAccountWrapper {
 	ba := &BankAccountWrapper{
 		Balance: balance,
@@ -48,27 +79,47 @@ func FindBankAccountWrapper(ctx context.Context, bankAccountService BankAccountS
 	if err != nil {
 		return nil, BankAccountNotFound
 	}
+	if _, err := assertGroupOperational(ctx, bankAccountService, bank); err != nil {
+		return nil, err
+	}
 
 	balance, err := bankAccountService.FindBalance(ctx, bank.Id, account.Id)
 	if err != nil {
 		return nil, LendingAccountBalanceNotFound
 	}
 
-	return NewBankAccountWrapper(balance, bank, opts...), nil
+	return NewBankAccountWrapper(balance, bank, append([]OptionFunc{WithAccount(account)}, opts...)...), nil
 }
 
-func FindOrCreateBankAccountWrapper(ctx context.Context, clk clock.Clock, bankAccountService BankAccountService, bank *Bank, account *Account) (*BankAccountWrapper, error) {
+func FindOrCreateBankAccountWrapper(ctx context.Context, clk clock.Clock, bankAccountService BankAccountService, bank *Bank, account *Account, opts ...OptionFunc) (*BankAccountWrapper, error) {
 	_, err := bankAccountService.GetBankById(ctx, bank.Id)
 	if err != nil {
 		return nil, BankAccountNotFound
 	}
+	group, err := assertGroupOperational(ctx, bankAccountService, bank)
+	if err != nil {
+		return nil, err
+	}
 
-	balance, err := FindOrCreateBalance(ctx, clk, bankAccountService, bank, account)
+	balance, err := FindOrCreateBalance(ctx, clk, bankAccountService, bank, account, group.GetConfig().MaxActiveBalances)
 	if err != nil {
 		return nil, err
 	}
 
-	return NewBankAccountWrapper(balance, bank, WithClock(clk)), nil
+	return NewBankAccountWrapper(balance, bank, append([]OptionFunc{WithClock(clk), WithAccount(account)}, opts...)...), nil
+}
+
+// assertGroupOperational rejects every balance change while the group of the bank is paused and
+// returns the group for the other limits
+func assertGroupOperational(ctx context.Context, bankAccountService BankAccountService, bank *Bank) (*Group, error) {
+	group, err := bankAccountService.GetGroup(ctx, bank.GroupId)
+	if err != nil {
+		return nil, err
+	}
+	if group.GetConfig().Paused {
+		return nil, GroupPaused
+	}
+	return group, nil
 }
 
 func (ba *BankAccountWrapper) Deposit(log Log, amount decimal.Decimal) error {
@@ -102,6 +153,7 @@ func (ba *BankAccountWrapper) DecreaseBalanceInLiquidation(log Log, amount decim
 }
 
 func (ba *BankAccountWrapper) WithdrawAll(log Log) (decimal.Decimal, error) {
+	defer ba.attachLedger()()
 	currentTimestamp := ba.clk.Now().Unix()
 	if err := ba.ClaimEmissions(log, currentTimestamp); err != nil {
 		return decimal.Zero, err
@@ -149,11 +201,13 @@ func (ba *BankAccountWrapper) WithdrawAll(log Log) (decimal.Decimal, error) {
 
 	splWithdrawAmount := currentAssetAmount.Truncate(8)
 	bank.CollectedInsuranceFeesOutstanding = bank.CollectedInsuranceFeesOutstanding.Add(currentAssetAmount.Sub(splWithdrawAmount))
+	bank.ledger.Transfer(bank, LedgerKindRoundingDust, bank.ledgerAccount(nil), LedgerAccountInsuranceFeesOutstanding, currentAssetAmount.Sub(splWithdrawAmount))
 
 	return splWithdrawAmount, nil
 }
 
 func (ba *BankAccountWrapper) RepayAll(log Log) (decimal.Decimal, error) {
+	defer ba.attachLedger()()
 	currentTimestamp := ba.clk.Now().Unix()
 	ba.ClaimEmissions(log, currentTimestamp)
 
@@ -198,10 +252,13 @@ func (ba *BankAccountWrapper) RepayAll(log Log) (decimal.Decimal, error) {
 	insuranceFeeIncrease := splDepositAmount.Sub(currentLiabilityAmount)
 	bank.CollectedInsuranceFeesOutstanding = bank.CollectedInsuranceFeesOutstanding.Add(insuranceFeeIncrease)
 
+	dustFrom := bank.ledgerAccount(nil)
 	if bank.LiquidityVault.IsPositive() {
 		bank.LiquidityVault = bank.LiquidityVault.Sub(insuranceFeeIncrease)
 		bank.NormalizeLiquidityVault()
+		dustFrom = LedgerAccountLiquidityVault
 	}
+	bank.ledger.Transfer(bank, LedgerKindRoundingDust, dustFrom, LedgerAccountInsuranceFeesOutstanding, insuranceFeeIncrease)
 
 	if bank.LiquidityVault.IsNegative() {
 		return decimal.Zero, ErrBankLiquidityDeficit
@@ -269,6 +326,10 @@ func (ba *BankAccountWrapper) IncreaseBalanceInternal(log Log, balanceDelta deci
 	default:
 	}
 
+	if ba.accountFlags&DisabledFlag != 0 && operationType != BalanceIncreaseTypeBypassDepositLimit && assetAmountIncrease.GreaterThan(ZERO_AMOUNT_THRESHOLD) {
+		return AccountDisabled
+	}
+
 	if err := bank.AssertOperationalMode(assetAmountIncrease.GreaterThan(ZERO_AMOUNT_THRESHOLD)); err != nil {
 		return err
 	}
@@ -334,6 +395,10 @@ func (ba *BankAccountWrapper) DecreaseBalanceInternal(log Log, balanceDelta deci
 	default:
 	}
 
+	if ba.accountFlags&DisabledFlag != 0 && operationType != BalanceDecreaseTypeBypassBorrowLimit && liabilityAmountIncrease.GreaterThan(ZERO_AMOUNT_THRESHOLD) {
+		return AccountDisabled
+	}
+
 	if err := bank.AssertOperationalMode(liabilityAmountIncrease.GreaterThan(ZERO_AMOUNT_THRESHOLD)); err != nil {
 		return err
 	}
@@ -445,10 +510,12 @@ func (ba *BankAccountWrapper) SettleEmissionsAndGetTransferAmount(log Log) decim
 }
 
 func (ba *BankAccountWrapper) WithdrawSplTransfer(amount decimal.Decimal, from, to *decimal.Decimal) {
+	defer ba.attachLedger()()
 	ba.Bank.WithdrawSplTransfer(amount, from, to)
 }
 
 func (ba *BankAccountWrapper) DepositSplTransfer(amount decimal.Decimal, from, to *decimal.Decimal) {
+	defer ba.attachLedger()()
 	ba.Bank.DepositSplTransfer(amount, from, to)
 }
 
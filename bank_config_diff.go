@@ -0,0 +1,94 @@
+package core
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+type (
+	// BankConfigDiff lists the fields of a BankConfig that changed, by json name
+	BankConfigDiff []BankConfigFieldChange
+
+	BankConfigFieldChange struct {
+		Field string `json:"field"`
+		Old   string `json:"old"`
+		New   string `json:"new"`
+	}
+)
+
+var decimalType = reflect.TypeOf(decimal.Decimal{})
+
+func DiffBankConfig(before, after BankConfig) BankConfigDiff {
+	diff := BankConfigDiff{}
+	diffStruct("", reflect.ValueOf(before), reflect.ValueOf(after), &diff)
+	return diff
+}
+
+func diffStruct(prefix string, before, after reflect.Value, diff *BankConfigDiff) {
+	typ := before.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		name := prefix + strings.Split(field.Tag.Get("json"), ",")[0]
+		beforeField, afterField := before.Field(i), after.Field(i)
+
+		if field.Type.Kind() == reflect.Struct && field.Type != decimalType {
+			diffStruct(name+".", beforeField, afterField, diff)
+			continue
+		}
+
+		equal := false
+		if field.Type == decimalType {
+			equal = beforeField.Interface().(decimal.Decimal).Equal(afterField.Interface().(decimal.Decimal))
+		} else {
+			equal = beforeField.Interface() == afterField.Interface()
+		}
+		if equal {
+			continue
+		}
+
+		*diff = append(*diff, BankConfigFieldChange{
+			Field: name,
+			Old:   fmt.Sprint(beforeField.Interface()),
+			New:   fmt.Sprint(afterField.Interface()),
+		})
+	}
+}
+
+func (d BankConfigDiff) IsEmpty() bool {
+	return len(d) == 0
+}
+
+func (d BankConfigDiff) Has(field string) bool {
+	for _, change := range d {
+		if change.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+func (d BankConfigDiff) Value() (driver.Value, error) {
+	valueString, err := json.Marshal(d)
+	return string(valueString), err
+}
+
+func (d *BankConfigDiff) Scan(value any) error {
+	if err := json.Unmarshal(value.([]byte), &d); err != nil {
+		return err
+	}
+	return nil
+}
+
+// IsBankConfigRiskIncreasing reports whether the change lowers an asset weight or raises a liability
+// weight, which can make existing accounts unhealthy
+func IsBankConfigRiskIncreasing(before, after BankConfig) bool {
+	return after.AssetWeightInit.LessThan(before.AssetWeightInit) ||
+		after.AssetWeightMaint.LessThan(before.AssetWeightMaint) ||
+		after.LiabilityWeightInit.GreaterThan(before.LiabilityWeightInit) ||
+		after.LiabilityWeightMaint.GreaterThan(before.LiabilityWeightMaint)
+}
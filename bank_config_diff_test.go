@@ -0,0 +1,55 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffBankConfig(t *testing.T) {
+	before := BankConfig{
+		AssetWeightInit:  decimal.NewFromFloat(0.8),
+		AssetWeightMaint: decimal.NewFromFloat(0.9),
+		InterestRateConfig: InterestRateConfig{
+			OptimalUtilizationRate: decimal.NewFromFloat(0.8),
+		},
+		OperationalState: BankOperationalStateOperational,
+	}
+
+	after := before
+	after.AssetWeightMaint = decimal.NewFromFloat(0.90)
+	assert.True(t, DiffBankConfig(before, after).IsEmpty())
+
+	after.AssetWeightInit = decimal.NewFromFloat(0.7)
+	after.InterestRateConfig.OptimalUtilizationRate = decimal.NewFromFloat(0.9)
+	after.OperationalState = BankOperationalStateReduceOnly
+
+	diff := DiffBankConfig(before, after)
+	assert.Equal(t, BankConfigDiff{
+		{Field: "assetWeightInit", Old: "0.8", New: "0.7"},
+		{Field: "interestRateConfig.optimalUtilizationRate", Old: "0.8", New: "0.9"},
+		{Field: "operationalState", Old: "Operational", New: "Reduce Only"},
+	}, diff)
+}
+
+func TestIsBankConfigRiskIncreasing(t *testing.T) {
+	before := BankConfig{
+		AssetWeightInit:      decimal.NewFromFloat(0.8),
+		AssetWeightMaint:     decimal.NewFromFloat(0.9),
+		LiabilityWeightInit:  decimal.NewFromFloat(1.2),
+		LiabilityWeightMaint: decimal.NewFromFloat(1.1),
+	}
+
+	after := before
+	after.AssetWeightInit = decimal.NewFromFloat(0.85)
+	assert.False(t, IsBankConfigRiskIncreasing(before, after))
+
+	after = before
+	after.AssetWeightMaint = decimal.NewFromFloat(0.85)
+	assert.True(t, IsBankConfigRiskIncreasing(before, after))
+
+	after = before
+	after.LiabilityWeightMaint = decimal.NewFromFloat(1.15)
+	assert.True(t, IsBankConfigRiskIncreasing(before, after))
+}
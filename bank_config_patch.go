@@ -0,0 +1,102 @@
+package core
+
+import (
+	"github.com/shopspring/decimal"
+)
+
+type (
+	// BankConfigPatch is a partial BankConfig update, nil fields are left unchanged. Unlike
+	// Configure it can set a field to its zero value.
+	BankConfigPatch struct {
+		AssetWeightInit  *decimal.Decimal `json:"assetWeightInit,omitempty"`
+		AssetWeightMaint *decimal.Decimal `json:"assetWeightMaint,omitempty"`
+
+		LiabilityWeightInit  *decimal.Decimal `json:"liabilityWeightInit,omitempty"`
+		LiabilityWeightMaint *decimal.Decimal `json:"liabilityWeightMaint,omitempty"`
+
+		DepositLimit   *decimal.Decimal `json:"depositLimit,omitempty"`
+		LiabilityLimit *decimal.Decimal `json:"liabilityLimit,omitempty"`
+
+		InterestRateConfig *InterestRateConfigPatch `json:"interestRateConfig,omitempty"`
+
+		OperationalState *BankOperationalState `json:"operationalState,omitempty"`
+
+		RiskTier                 *RiskTier        `json:"riskTier,omitempty"`
+		TotalAssetValueInitLimit *decimal.Decimal `json:"totalAssetValueInitLimit,omitempty"`
+
+		OracleSetup  *OracleSetup `json:"oracleSetup,omitempty"`
+		OracleMaxAge *int64       `json:"oracleMaxAge,omitempty"`
+	}
+
+	InterestRateConfigPatch struct {
+		OptimalUtilizationRate *decimal.Decimal `json:"optimalUtilizationRate,omitempty"`
+		PlateauInterestRate    *decimal.Decimal `json:"plateauInterestRate,omitempty"`
+		MaxInterestRate        *decimal.Decimal `json:"maxInterestRate,omitempty"`
+
+		InsuranceFeeFixedApr *decimal.Decimal `json:"insuranceFeeFixedApr,omitempty"`
+		InsuranceIrFee       *decimal.Decimal `json:"insuranceIrFee,omitempty"`
+		ProtocolFixedFeeApr  *decimal.Decimal `json:"protocolFixedFeeApr,omitempty"`
+		ProtocolIrFee        *decimal.Decimal `json:"protocolIrFee,omitempty"`
+	}
+)
+
+// Apply returns config with the patch applied, config itself is not changed
+func (p *BankConfigPatch) Apply(config BankConfig) BankConfig {
+	setDecimal(&config.AssetWeightInit, p.AssetWeightInit)
+	setDecimal(&config.AssetWeightMaint, p.AssetWeightMaint)
+	setDecimal(&config.LiabilityWeightInit, p.LiabilityWeightInit)
+	setDecimal(&config.LiabilityWeightMaint, p.LiabilityWeightMaint)
+	setDecimal(&config.DepositLimit, p.DepositLimit)
+	setDecimal(&config.LiabilityLimit, p.LiabilityLimit)
+	if p.InterestRateConfig != nil {
+		config.InterestRateConfig = p.InterestRateConfig.Apply(config.InterestRateConfig)
+	}
+	if p.OperationalState != nil {
+		config.OperationalState = *p.OperationalState
+	}
+	if p.RiskTier != nil {
+		config.RiskTier = *p.RiskTier
+	}
+	setDecimal(&config.TotalAssetValueInitLimit, p.TotalAssetValueInitLimit)
+	if p.OracleSetup != nil {
+		config.OracleSetup = *p.OracleSetup
+	}
+	if p.OracleMaxAge != nil {
+		config.OracleMaxAge = *p.OracleMaxAge
+	}
+	return config
+}
+
+func (p *InterestRateConfigPatch) Apply(config InterestRateConfig) InterestRateConfig {
+	setDecimal(&config.OptimalUtilizationRate, p.OptimalUtilizationRate)
+	setDecimal(&config.PlateauInterestRate, p.PlateauInterestRate)
+	setDecimal(&config.MaxInterestRate, p.MaxInterestRate)
+	setDecimal(&config.InsuranceFeeFixedApr, p.InsuranceFeeFixedApr)
+	setDecimal(&config.InsuranceIrFee, p.InsuranceIrFee)
+	setDecimal(&config.ProtocolFixedFeeApr, p.ProtocolFixedFeeApr)
+	setDecimal(&config.ProtocolIrFee, p.ProtocolIrFee)
+	return config
+}
+
+func setDecimal(dst *decimal.Decimal, value *decimal.Decimal) {
+	if value != nil {
+		*dst = *value
+	}
+}
+
+// ApplyPatch validates the patched config against the bank rules and the group and only then
+// writes it to the bank. It returns the fields that changed. It does not store anything, admin
+// changes are stored through BankConfigGovernor.ProposePatch so they are timelocked and recorded.
+func (b *Bank) ApplyPatch(patch *BankConfigPatch, groupConfig GroupConfig) (BankConfigDiff, error) {
+	config := patch.Apply(b.BankConfig)
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	if err := groupConfig.ValidateBankConfig(&config); err != nil {
+		return nil, err
+	}
+
+	diff := DiffBankConfig(b.BankConfig, config)
+	b.BankConfig = config
+	return diff, nil
+}
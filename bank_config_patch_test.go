@@ -0,0 +1,78 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBankApplyPatch(t *testing.T) {
+	newBank := func() *Bank {
+		return &Bank{BankConfig: BankConfig{
+			AssetWeightInit:      decimal.NewFromFloat(0.8),
+			AssetWeightMaint:     decimal.NewFromFloat(0.9),
+			LiabilityWeightInit:  decimal.NewFromFloat(1.2),
+			LiabilityWeightMaint: decimal.NewFromFloat(1.1),
+			InterestRateConfig: InterestRateConfig{
+				OptimalUtilizationRate: decimal.NewFromFloat(0.8),
+				PlateauInterestRate:    decimal.NewFromFloat(0.1),
+				MaxInterestRate:        decimal.NewFromFloat(1),
+				ProtocolIrFee:          decimal.NewFromFloat(0.05),
+			},
+			OperationalState: BankOperationalStateOperational,
+			RiskTier:         Collateral,
+			OracleSetup:      MixinOracle,
+			OracleMaxAge:     60,
+		}}
+	}
+	zero := decimal.Zero
+	reduceOnly := BankOperationalStateReduceOnly
+	isolated := Isolated
+	maxAge := int64(120)
+
+	t.Run("zero values and operational state", func(t *testing.T) {
+		bank := newBank()
+		diff, err := bank.ApplyPatch(&BankConfigPatch{
+			InterestRateConfig: &InterestRateConfigPatch{ProtocolIrFee: &zero},
+			OperationalState:   &reduceOnly,
+		}, DefaultGroupConfig())
+		assert.NoError(t, err)
+		assert.True(t, bank.InterestRateConfig.ProtocolIrFee.IsZero())
+		assert.Equal(t, BankOperationalStateReduceOnly, bank.OperationalState)
+		assert.True(t, diff.Has("interestRateConfig.protocolIrFee"))
+		assert.True(t, diff.Has("operationalState"))
+		assert.Len(t, diff, 2)
+	})
+
+	t.Run("isolated needs zero asset weights", func(t *testing.T) {
+		bank := newBank()
+		_, err := bank.ApplyPatch(&BankConfigPatch{RiskTier: &isolated}, DefaultGroupConfig())
+		assert.ErrorIs(t, err, InvalidConfig)
+		assert.Equal(t, Collateral, bank.RiskTier)
+
+		diff, err := bank.ApplyPatch(&BankConfigPatch{RiskTier: &isolated, AssetWeightInit: &zero, AssetWeightMaint: &zero}, DefaultGroupConfig())
+		assert.NoError(t, err)
+		assert.Len(t, diff, 3)
+	})
+
+	t.Run("oracle max age of group", func(t *testing.T) {
+		bank := newBank()
+		_, err := bank.ApplyPatch(&BankConfigPatch{OracleMaxAge: &maxAge}, DefaultGroupConfig())
+		assert.ErrorIs(t, err, ErrOracleMaxAgeTooLong)
+		assert.Equal(t, int64(60), bank.OracleMaxAge)
+	})
+
+	t.Run("undefined operational state and risk tier", func(t *testing.T) {
+		bank := newBank()
+		state := BankOperationalStateNone + 1
+		_, err := bank.ApplyPatch(&BankConfigPatch{OperationalState: &state}, DefaultGroupConfig())
+		assert.ErrorIs(t, err, InvalidConfig)
+		assert.Equal(t, BankOperationalStateOperational, bank.OperationalState)
+
+		tier := Isolated + 1
+		_, err = bank.ApplyPatch(&BankConfigPatch{RiskTier: &tier}, DefaultGroupConfig())
+		assert.ErrorIs(t, err, InvalidConfig)
+		assert.Equal(t, Collateral, bank.RiskTier)
+	})
+}
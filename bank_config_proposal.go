@@ -0,0 +1,256 @@
+package core
+
+import (
+	"context"
+
+	"github.com/facebookgo/clock"
+	"github.com/gofrs/uuid"
+)
+
+type (
+	BankConfigProposalStore interface {
+		CreateBankConfigProposal(ctx context.Context, proposal *BankConfigProposal) error
+		UpdateBankConfigProposal(ctx context.Context, proposal *BankConfigProposal) error
+		ListPendingBankConfigProposals(ctx context.Context, bankId uuid.UUID) ([]*BankConfigProposal, error)
+	}
+
+	BankConfigRevisionStore interface {
+		CreateBankConfigRevision(ctx context.Context, revision *BankConfigRevision) error
+		ListBankConfigRevisions(ctx context.Context, bankId uuid.UUID) ([]*BankConfigRevision, error)
+	}
+
+	// BankConfigProposal is a queued config change, Base is the bank config it was proposed against
+	BankConfigProposal struct {
+		Id         uuid.UUID                `json:"id"`
+		BankId     uuid.UUID                `json:"bankId"`
+		GroupId    uuid.UUID                `json:"groupId"`
+		ProposedBy string                   `json:"proposedBy"`
+		Base       BankConfig               `json:"base"`
+		Config     BankConfig               `json:"config"`
+		Diff       BankConfigDiff           `json:"diff"`
+		Status     BankConfigProposalStatus `json:"status"`
+
+		CreatedAt   int64 `json:"createdAt"`
+		UpdatedAt   int64 `json:"updatedAt"`
+		EffectiveAt int64 `json:"effectiveAt"`
+	}
+
+	BankConfigProposalStatus string
+
+	// BankConfigRevision records an applied bank config with who changed it, ProposalId is Nil for
+	// a change that did not go through a proposal
+	BankConfigRevision struct {
+		Id         uuid.UUID      `json:"id"`
+		BankId     uuid.UUID      `json:"bankId"`
+		ProposalId uuid.UUID      `json:"proposalId"`
+		ChangedBy  string         `json:"changedBy"`
+		Config     BankConfig     `json:"config"`
+		Diff       BankConfigDiff `json:"diff"`
+		CreatedAt  int64          `json:"createdAt"`
+	}
+
+	// BankConfigGovernor queues bank config changes and applies them once they are effective
+	BankConfigGovernor struct {
+		clk           clock.Clock
+		log           Log
+		bankStore     BankStore
+		proposalStore BankConfigProposalStore
+		revisionStore BankConfigRevisionStore
+	}
+)
+
+const (
+	BankConfigProposalStatusPending   BankConfigProposalStatus = "pending"
+	BankConfigProposalStatusApplied   BankConfigProposalStatus = "applied"
+	BankConfigProposalStatusCancelled BankConfigProposalStatus = "cancelled"
+)
+
+func NewBankConfigGovernor(clk clock.Clock, log Log, bankStore BankStore, proposalStore BankConfigProposalStore, revisionStore BankConfigRevisionStore) *BankConfigGovernor {
+	return &BankConfigGovernor{
+		clk:           clk,
+		log:           log,
+		bankStore:     bankStore,
+		proposalStore: proposalStore,
+		revisionStore: revisionStore,
+	}
+}
+
+// Propose queues config for the bank with Configure semantics. A change that lowers a weight has to
+// wait at least the ConfigTimelock of the group, other changes can be effective right away.
+func (g *BankConfigGovernor) Propose(ctx context.Context, group *Group, adminKey string, bank *Bank, config *BankConfig, effectiveAt int64) (*BankConfigProposal, error) {
+	target := bank.Clone()
+	if err := target.Configure(config); err != nil {
+		return nil, err
+	}
+	return g.propose(ctx, group, adminKey, bank, target.BankConfig, effectiveAt)
+}
+
+// ProposePatch queues a BankConfigPatch, it follows the same timelock rules as Propose
+func (g *BankConfigGovernor) ProposePatch(ctx context.Context, group *Group, adminKey string, bank *Bank, patch *BankConfigPatch, effectiveAt int64) (*BankConfigProposal, error) {
+	target := bank.Clone()
+	if _, err := target.ApplyPatch(patch, group.GetConfig()); err != nil {
+		return nil, err
+	}
+	return g.propose(ctx, group, adminKey, bank, target.BankConfig, effectiveAt)
+}
+
+func (g *BankConfigGovernor) propose(ctx context.Context, group *Group, adminKey string, bank *Bank, config BankConfig, effectiveAt int64) (*BankConfigProposal, error) {
+	if adminKey == "" || adminKey != group.AdminKey {
+		return nil, Unauthorized
+	}
+	if bank.GroupId != group.Id {
+		return nil, InvalidAction
+	}
+	if bank.IsDeleted() {
+		return nil, ErrBankDeleted
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	if err := group.GetConfig().ValidateBankConfig(&config); err != nil {
+		return nil, err
+	}
+
+	diff := DiffBankConfig(bank.BankConfig, config)
+	if diff.IsEmpty() {
+		return nil, InvalidConfig
+	}
+
+	now := g.clk.Now().Unix()
+	if effectiveAt < now {
+		effectiveAt = now
+	}
+	if IsBankConfigRiskIncreasing(bank.BankConfig, config) && effectiveAt < now+group.GetConfig().ConfigTimelock {
+		return nil, ErrBankConfigTimelock
+	}
+
+	proposal := &BankConfigProposal{
+		Id:          uuid.Must(uuid.NewV4()),
+		BankId:      bank.Id,
+		GroupId:     bank.GroupId,
+		ProposedBy:  adminKey,
+		Base:        bank.BankConfig,
+		Config:      config,
+		Diff:        diff,
+		Status:      BankConfigProposalStatusPending,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		EffectiveAt: effectiveAt,
+	}
+	if err := g.proposalStore.CreateBankConfigProposal(ctx, proposal); err != nil {
+		return nil, err
+	}
+	return proposal, nil
+}
+
+func (g *BankConfigGovernor) Cancel(ctx context.Context, group *Group, adminKey string, proposal *BankConfigProposal) error {
+	if adminKey == "" || adminKey != group.AdminKey || proposal.GroupId != group.Id {
+		return Unauthorized
+	}
+	if proposal.Status != BankConfigProposalStatusPending {
+		return ErrBankConfigProposalState
+	}
+
+	proposal.Status = BankConfigProposalStatusCancelled
+	proposal.UpdatedAt = g.clk.Now().Unix()
+	return g.proposalStore.UpdateBankConfigProposal(ctx, proposal)
+}
+
+// Apply writes an effective proposal to the bank and stores the revision. Unless the proposal
+// changes it, the current operational state is kept; any other change of the bank config since the
+// proposal makes it stale.
+func (g *BankConfigGovernor) Apply(ctx context.Context, proposal *BankConfigProposal) (*BankConfigRevision, error) {
+	if proposal.Status != BankConfigProposalStatusPending {
+		return nil, ErrBankConfigProposalState
+	}
+	now := g.clk.Now().Unix()
+	if now < proposal.EffectiveAt {
+		return nil, ErrBankConfigProposalEarly
+	}
+
+	bank, err := g.bankStore.GetBankById(ctx, proposal.BankId)
+	if err != nil {
+		return nil, err
+	}
+	if bank.IsDeleted() {
+		return nil, ErrBankDeleted
+	}
+
+	base := proposal.Base
+	base.OperationalState = bank.OperationalState
+	if !DiffBankConfig(base, bank.BankConfig).IsEmpty() {
+		return nil, ErrBankConfigProposalStale
+	}
+
+	config := proposal.Config
+	if config.OperationalState == proposal.Base.OperationalState {
+		config.OperationalState = bank.OperationalState
+	}
+	revision := newBankConfigRevision(g.clk, bank.Id, proposal.Id, proposal.ProposedBy, bank.BankConfig, config)
+
+	bank.BankConfig = config
+	if err := g.bankStore.UpdateBankConfig(ctx, bank.Id, &bank.BankConfig); err != nil {
+		return nil, err
+	}
+	if err := g.revisionStore.CreateBankConfigRevision(ctx, revision); err != nil {
+		return nil, err
+	}
+
+	proposal.Status = BankConfigProposalStatusApplied
+	proposal.UpdatedAt = now
+	if err := g.proposalStore.UpdateBankConfigProposal(ctx, proposal); err != nil {
+		return nil, err
+	}
+
+	g.log.Info().Msgf("bank %s config proposal %s applied: %d fields changed", bank.Id, proposal.Id, len(revision.Diff))
+	return revision, nil
+}
+
+// ApplyDue applies the effective proposals of the bank in order, a stale proposal is skipped and
+// stays pending until it is cancelled
+func (g *BankConfigGovernor) ApplyDue(ctx context.Context, bankId uuid.UUID) ([]*BankConfigRevision, error) {
+	proposals, err := g.proposalStore.ListPendingBankConfigProposals(ctx, bankId)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := []*BankConfigRevision{}
+	now := g.clk.Now().Unix()
+	for _, proposal := range proposals {
+		if now < proposal.EffectiveAt {
+			continue
+		}
+		revision, err := g.Apply(ctx, proposal)
+		if err == ErrBankConfigProposalStale {
+			g.log.Warn().Msgf("bank %s config proposal %s is stale", bankId, proposal.Id)
+			continue
+		}
+		if err != nil {
+			return revisions, err
+		}
+		revisions = append(revisions, revision)
+	}
+	return revisions, nil
+}
+
+// RecordBankConfigRevision stores the revision of a bank config that was written without a
+// proposal, like a circuit breaker trip. Nothing is recorded when config equals previous.
+func RecordBankConfigRevision(ctx context.Context, clk clock.Clock, revisionStore BankConfigRevisionStore, bankId uuid.UUID, changedBy string, previous, config BankConfig) error {
+	revision := newBankConfigRevision(clk, bankId, uuid.Nil, changedBy, previous, config)
+	if revision.Diff.IsEmpty() {
+		return nil
+	}
+	return revisionStore.CreateBankConfigRevision(ctx, revision)
+}
+
+func newBankConfigRevision(clk clock.Clock, bankId, proposalId uuid.UUID, changedBy string, previous, config BankConfig) *BankConfigRevision {
+	return &BankConfigRevision{
+		Id:         uuid.Must(uuid.NewV4()),
+		BankId:     bankId,
+		ProposalId: proposalId,
+		ChangedBy:  changedBy,
+		Config:     config,
+		Diff:       DiffBankConfig(previous, config),
+		CreatedAt:  clk.Now().Unix(),
+	}
+}
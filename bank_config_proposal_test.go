@@ -0,0 +1,141 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/clock"
+	"github.com/gofrs/uuid"
+	"github.com/rs/zerolog"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+type revisionsStore struct {
+	revisions []*BankConfigRevision
+}
+
+func (s *revisionsStore) CreateBankConfigRevision(ctx context.Context, revision *BankConfigRevision) error {
+	s.revisions = append(s.revisions, revision)
+	return nil
+}
+
+func (s *revisionsStore) ListBankConfigRevisions(ctx context.Context, bankId uuid.UUID) ([]*BankConfigRevision, error) {
+	revisions := []*BankConfigRevision{}
+	for _, revision := range s.revisions {
+		if revision.BankId == bankId {
+			revisions = append(revisions, revision)
+		}
+	}
+	return revisions, nil
+}
+
+type proposalStore struct {
+	ratesStore
+	revisionsStore
+	proposals []*BankConfigProposal
+}
+
+func (s *proposalStore) UpdateBankConfig(ctx context.Context, bankId uuid.UUID, bankConfig *BankConfig) error {
+	return nil
+}
+
+func (s *proposalStore) CreateBankConfigProposal(ctx context.Context, proposal *BankConfigProposal) error {
+	s.proposals = append(s.proposals, proposal)
+	return nil
+}
+
+func (s *proposalStore) UpdateBankConfigProposal(ctx context.Context, proposal *BankConfigProposal) error {
+	return nil
+}
+
+func (s *proposalStore) ListPendingBankConfigProposals(ctx context.Context, bankId uuid.UUID) ([]*BankConfigProposal, error) {
+	proposals := []*BankConfigProposal{}
+	for _, proposal := range s.proposals {
+		if proposal.BankId == bankId && proposal.Status == BankConfigProposalStatusPending {
+			proposals = append(proposals, proposal)
+		}
+	}
+	return proposals, nil
+}
+
+func newGovernorTest() (*BankConfigGovernor, *proposalStore, *clock.Mock, *Group, *Bank) {
+	clk := clock.NewMock()
+	clk.Add(time.Hour)
+	log := zerolog.Nop()
+	btc := newLoopBank("btc", 0.8, 0.9)
+	btc.InterestRateConfig = InterestRateConfig{
+		OptimalUtilizationRate: decimal.NewFromFloat(0.8),
+		PlateauInterestRate:    decimal.NewFromFloat(0.1),
+		MaxInterestRate:        ONE,
+	}
+	btc.OracleSetup, btc.OracleMaxAge = MixinOracle, 60
+	store := &proposalStore{ratesStore: ratesStore{banks: []*Bank{btc}}}
+	group := &Group{AdminKey: "admin"}
+	return NewBankConfigGovernor(clk, &log, store, store, store), store, clk, group, btc
+}
+
+func TestBankConfigGovernorTimelock(t *testing.T) {
+	ctx := context.Background()
+	governor, store, clk, group, btc := newGovernorTest()
+	timelock := group.GetConfig().ConfigTimelock
+	lower := decimal.NewFromFloat(0.7)
+	higher := decimal.NewFromFloat(0.85)
+
+	_, err := governor.ProposePatch(ctx, group, "other", btc, &BankConfigPatch{AssetWeightInit: &lower}, 0)
+	assert.ErrorIs(t, err, Unauthorized)
+
+	// a lower weight has to wait for the timelock, a higher one does not
+	_, err = governor.ProposePatch(ctx, group, "admin", btc, &BankConfigPatch{AssetWeightInit: &lower}, clk.Now().Unix()+timelock-1)
+	assert.ErrorIs(t, err, ErrBankConfigTimelock)
+	proposal, err := governor.ProposePatch(ctx, group, "admin", btc, &BankConfigPatch{AssetWeightInit: &lower}, clk.Now().Unix()+timelock)
+	assert.NoError(t, err)
+	_, err = governor.ProposePatch(ctx, group, "admin", btc, &BankConfigPatch{AssetWeightInit: &higher}, 0)
+	assert.NoError(t, err)
+
+	_, err = governor.Apply(ctx, proposal)
+	assert.ErrorIs(t, err, ErrBankConfigProposalEarly)
+	assert.True(t, btc.AssetWeightInit.Equal(decimal.NewFromFloat(0.8)))
+
+	// the effective increase is applied first, which makes the decrease stale
+	revisions, err := governor.ApplyDue(ctx, btc.Id)
+	assert.NoError(t, err)
+	assert.Len(t, revisions, 1)
+	assert.True(t, btc.AssetWeightInit.Equal(higher))
+
+	clk.Add(time.Duration(timelock) * time.Second)
+	revisions, err = governor.ApplyDue(ctx, btc.Id)
+	assert.NoError(t, err)
+	assert.Empty(t, revisions)
+	_, err = governor.Apply(ctx, proposal)
+	assert.ErrorIs(t, err, ErrBankConfigProposalStale)
+	assert.Equal(t, BankConfigProposalStatusPending, proposal.Status)
+	assert.True(t, btc.AssetWeightInit.Equal(higher))
+	assert.Len(t, store.revisions, 1)
+}
+
+func TestBankConfigGovernorRecordsRevision(t *testing.T) {
+	ctx := context.Background()
+	governor, store, clk, group, btc := newGovernorTest()
+	limit := decimal.NewFromInt(1000)
+
+	proposal, err := governor.ProposePatch(ctx, group, "admin", btc, &BankConfigPatch{DepositLimit: &limit}, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, clk.Now().Unix(), proposal.EffectiveAt)
+
+	// an operational state change since the proposal does not make it stale and is kept
+	btc.OperationalState = BankOperationalStateReduceOnly
+	revision, err := governor.Apply(ctx, proposal)
+	assert.NoError(t, err)
+	assert.Equal(t, BankConfigProposalStatusApplied, proposal.Status)
+	assert.Equal(t, BankOperationalStateReduceOnly, btc.OperationalState)
+	assert.True(t, btc.DepositLimit.Equal(limit))
+
+	assert.Equal(t, []*BankConfigRevision{revision}, store.revisions)
+	assert.Equal(t, proposal.Id, revision.ProposalId)
+	assert.Equal(t, "admin", revision.ChangedBy)
+	assert.Len(t, revision.Diff, 1)
+	_, err = governor.Apply(ctx, proposal)
+	assert.ErrorIs(t, err, ErrBankConfigProposalState)
+}
@@ -0,0 +1,129 @@
+package core
+
+import (
+	"context"
+	"time"
+
+	"github.com/facebookgo/clock"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// BankLifecycle creates, deprecates and deletes the banks of a group
+type BankLifecycle struct {
+	clk           clock.Clock
+	log           Log
+	bankStore     BankStore
+	revisionStore BankConfigRevisionStore
+}
+
+const (
+	BankLifecycleChangedByDeprecate = "lifecycle: deprecate"
+	BankLifecycleChangedByDelete    = "lifecycle: delete"
+)
+
+func NewBankLifecycle(clk clock.Clock, log Log, bankStore BankStore, revisionStore BankConfigRevisionStore) *BankLifecycle {
+	return &BankLifecycle{
+		clk:           clk,
+		log:           log,
+		bankStore:     bankStore,
+		revisionStore: revisionStore,
+	}
+}
+
+// CreateBank validates the config against the group and rejects a second bank of the same asset
+// or name in the group
+func (l *BankLifecycle) CreateBank(ctx context.Context, group *Group, name string, mixinSafeAssetId string, bankConfig BankConfig) (*Bank, error) {
+	if err := bankConfig.Validate(); err != nil {
+		return nil, err
+	}
+	if err := group.GetConfig().ValidateBankConfig(&bankConfig); err != nil {
+		return nil, err
+	}
+
+	bank := NewBankWithCreateTime(l.clk, group.Id, name, mixinSafeAssetId, bankConfig, time.Unix(l.clk.Now().Unix(), 0))
+
+	// the id is derived from group, name and asset, so it also catches a deleted bank
+	_, err := l.bankStore.GetBankById(ctx, bank.Id)
+	if err == nil {
+		return nil, BankAlreadyExists
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	banks, err := l.bankStore.ListBankByGroupId(ctx, group.Id)
+	if err != nil {
+		return nil, err
+	}
+	for _, existing := range ExcludeDeletedBanks(banks) {
+		if existing.MixinSafeAssetId == mixinSafeAssetId || existing.Name == name {
+			return nil, BankAlreadyExists
+		}
+	}
+
+	if err := l.bankStore.CreateBank(ctx, bank); err != nil {
+		return nil, err
+	}
+	return bank, nil
+}
+
+// DeprecateBank moves the bank to reduce only and schedules its delisting, users can still repay
+// and withdraw until then
+func (l *BankLifecycle) DeprecateBank(ctx context.Context, bank *Bank, delistAt int64) error {
+	if bank.IsDeleted() {
+		return ErrBankDeleted
+	}
+	if delistAt <= l.clk.Now().Unix() {
+		return ErrInvalidDelistTime
+	}
+
+	previous := bank.BankConfig
+	bank.OperationalState = BankOperationalStateReduceOnly
+	bank.DelistAt = delistAt
+	if err := l.bankStore.UpdateBank(ctx, bank.Id, bank); err != nil {
+		return err
+	}
+	return RecordBankConfigRevision(ctx, l.clk, l.revisionStore, bank.Id, BankLifecycleChangedByDeprecate, previous, bank.BankConfig)
+}
+
+// DeleteBank soft deletes a deprecated bank once its delist time passed. The bank must not hold any
+// asset or liability shares and its fees and vaults must be swept.
+func (l *BankLifecycle) DeleteBank(ctx context.Context, bank *Bank) error {
+	if bank.IsDeleted() {
+		return ErrBankDeleted
+	}
+	if !bank.IsDeprecated() || l.clk.Now().Unix() < bank.DelistAt {
+		return ErrBankNotDelisted
+	}
+	if !bank.TotalAssetShares.IsZero() || !bank.TotalLiabilityShares.IsZero() {
+		return ErrBankNotEmpty
+	}
+	if !bank.FeeVault.IsZero() || !bank.InsuranceVault.IsZero() ||
+		!bank.CollectedGroupFeesOutstanding.IsZero() || !bank.CollectedInsuranceFeesOutstanding.IsZero() {
+		return ErrBankVaultsNotSwept
+	}
+
+	now := l.clk.Now().Unix()
+	if err := updateBankOperationalState(ctx, l.clk, l.bankStore, l.revisionStore, bank, BankOperationalStatePaused, BankLifecycleChangedByDelete); err != nil {
+		return err
+	}
+	if err := l.bankStore.SoftDeleteBank(ctx, bank.Id, now); err != nil {
+		return err
+	}
+
+	bank.DeletedAt = now
+	l.log.Info().Msgf("bank %s of group %s deleted", bank.Id, bank.GroupId)
+	return nil
+}
+
+// ExcludeDeletedBanks returns the banks that are not deleted
+func ExcludeDeletedBanks(banks []*Bank) []*Bank {
+	live := make([]*Bank, 0, len(banks))
+	for _, bank := range banks {
+		if !bank.IsDeleted() {
+			live = append(live, bank)
+		}
+	}
+	return live
+}
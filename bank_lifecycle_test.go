@@ -0,0 +1,74 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/clock"
+	"github.com/gofrs/uuid"
+	"github.com/rs/zerolog"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+type lifecycleStore struct {
+	ratesStore
+	revisionsStore
+	deleted map[uuid.UUID]int64
+}
+
+func (s *lifecycleStore) UpdateBank(ctx context.Context, bankId uuid.UUID, bank *Bank) error {
+	return nil
+}
+
+func (s *lifecycleStore) UpdateBankConfig(ctx context.Context, bankId uuid.UUID, bankConfig *BankConfig) error {
+	return nil
+}
+
+func (s *lifecycleStore) SoftDeleteBank(ctx context.Context, bankId uuid.UUID, deletedAt int64) error {
+	s.deleted[bankId] = deletedAt
+	return nil
+}
+
+func TestBankLifecycleDeleteBank(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewMock()
+	clk.Add(time.Hour)
+	log := zerolog.Nop()
+	btc, usdt := newLoopBank("btc", 0.8, 0.9), newLoopBank("usdt", 0.9, 0.95)
+	store := &lifecycleStore{ratesStore: ratesStore{banks: []*Bank{btc, usdt}}, deleted: map[uuid.UUID]int64{}}
+	lifecycle := NewBankLifecycle(clk, &log, store, store)
+
+	// only a deprecated bank past its delist time can be deleted
+	assert.ErrorIs(t, lifecycle.DeleteBank(ctx, btc), ErrBankNotDelisted)
+	assert.NoError(t, lifecycle.DeprecateBank(ctx, btc, clk.Now().Unix()+60))
+	assert.Equal(t, BankOperationalStateReduceOnly, btc.OperationalState)
+	assert.ErrorIs(t, lifecycle.DeleteBank(ctx, btc), ErrBankNotDelisted)
+	clk.Add(time.Minute)
+
+	btc.TotalAssetShares = ONE
+	assert.ErrorIs(t, lifecycle.DeleteBank(ctx, btc), ErrBankNotEmpty)
+	btc.TotalAssetShares = decimal.Zero
+
+	for _, vault := range []*decimal.Decimal{&btc.FeeVault, &btc.InsuranceVault, &btc.CollectedGroupFeesOutstanding, &btc.CollectedInsuranceFeesOutstanding} {
+		*vault = ONE
+		assert.ErrorIs(t, lifecycle.DeleteBank(ctx, btc), ErrBankVaultsNotSwept)
+		*vault = decimal.Zero
+	}
+
+	assert.NoError(t, lifecycle.DeleteBank(ctx, btc))
+	assert.Equal(t, clk.Now().Unix(), store.deleted[btc.Id])
+	assert.Equal(t, BankOperationalStatePaused, btc.OperationalState)
+	assert.ErrorIs(t, lifecycle.DeleteBank(ctx, btc), ErrBankDeleted)
+	assert.ErrorIs(t, lifecycle.DeprecateBank(ctx, btc, clk.Now().Unix()+60), ErrBankDeleted)
+
+	// the reduce only and the paused state are both recorded
+	assert.Len(t, store.revisions, 2)
+	assert.Equal(t, BankLifecycleChangedByDeprecate, store.revisions[0].ChangedBy)
+	assert.Equal(t, BankOperationalStateReduceOnly, store.revisions[0].Config.OperationalState)
+	assert.Equal(t, BankLifecycleChangedByDelete, store.revisions[1].ChangedBy)
+	assert.Equal(t, BankOperationalStatePaused, store.revisions[1].Config.OperationalState)
+
+	assert.Equal(t, []*Bank{usdt}, ExcludeDeletedBanks(store.banks))
+}
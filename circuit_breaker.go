@@ -0,0 +1,273 @@
+package core
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"sync"
+
+	"github.com/facebookgo/clock"
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+)
+
+type (
+	// CircuitBreakerState is kept on the group while it is tripped. PreviousStates holds the
+	// operational state every bank had before the trip, so Restore can put it back.
+	CircuitBreakerState struct {
+		State          BankOperationalState               `json:"state"`
+		Reason         string                             `json:"reason"`
+		TrippedAt      int64                              `json:"trippedAt"`
+		PreviousStates map[uuid.UUID]BankOperationalState `json:"previousStates"`
+	}
+
+	// CircuitBreaker trips groups on the triggers of their CircuitBreakerConfig. It keeps the
+	// observed prices of the last window in memory.
+	CircuitBreaker struct {
+		clk           clock.Clock
+		log           Log
+		bankStore     BankStore
+		revisionStore BankConfigRevisionStore
+		groupStore    GroupStore
+
+		mu     sync.Mutex
+		prices map[uuid.UUID][]pricePoint
+	}
+
+	pricePoint struct {
+		price     decimal.Decimal
+		timestamp int64
+	}
+)
+
+const (
+	CircuitBreakerReasonAdmin            = "admin"
+	CircuitBreakerReasonPriceMove        = "price move"
+	CircuitBreakerReasonUtilization      = "utilization"
+	CircuitBreakerReasonLiquidityDeficit = "liquidity deficit"
+
+	// CircuitBreakerChangedBy prefixes the ChangedBy of the bank config revisions of a trip or restore
+	CircuitBreakerChangedBy = "circuit breaker: "
+)
+
+func IsCircuitBreakerState(state BankOperationalState) bool {
+	return state == BankOperationalStatePaused || state == BankOperationalStateReduceOnly
+}
+
+func (g *Group) IsCircuitBreakerTripped() bool {
+	return g.CircuitBreaker != nil
+}
+
+// TripGroup switches every bank of the group to state. Tripping a tripped group again only changes
+// the state, the bank states from before the first trip are kept. The previous states of all banks
+// are stored with the group before any bank changes, so a trip that fails halfway can be restored
+// or tripped again. Every bank change is recorded as a BankConfigRevision.
+func TripGroup(ctx context.Context, clk clock.Clock, bankStore BankStore, revisionStore BankConfigRevisionStore, groupStore GroupStore, group *Group, state BankOperationalState, reason string) error {
+	if !IsCircuitBreakerState(state) {
+		return InvalidConfig
+	}
+
+	banks, err := bankStore.ListBankByGroupId(ctx, group.Id)
+	if err != nil {
+		return err
+	}
+	banks = ExcludeDeletedBanks(banks)
+
+	breaker := &CircuitBreakerState{
+		State:          state,
+		Reason:         reason,
+		TrippedAt:      clk.Now().Unix(),
+		PreviousStates: make(map[uuid.UUID]BankOperationalState, len(banks)),
+	}
+	if previous := group.CircuitBreaker; previous != nil {
+		breaker.TrippedAt = previous.TrippedAt
+		for bankId, state := range previous.PreviousStates {
+			breaker.PreviousStates[bankId] = state
+		}
+	}
+	for _, bank := range banks {
+		if _, ok := breaker.PreviousStates[bank.Id]; !ok {
+			breaker.PreviousStates[bank.Id] = bank.OperationalState
+		}
+	}
+
+	previous, updatedAt := group.CircuitBreaker, group.UpdatedAt
+	group.CircuitBreaker = breaker
+	group.UpdatedAt = clk.Now().Unix()
+	if err := groupStore.UpdateGroup(ctx, group.Name, group); err != nil {
+		group.CircuitBreaker, group.UpdatedAt = previous, updatedAt
+		return err
+	}
+
+	for _, bank := range banks {
+		if err := updateBankOperationalState(ctx, clk, bankStore, revisionStore, bank, state, CircuitBreakerChangedBy+reason); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RestoreGroup puts every bank of a tripped group back into the state it had before the trip.
+// Banks added while the group was tripped keep their state. The group stays tripped until every
+// bank is restored, so a restore that fails halfway can be run again.
+func RestoreGroup(ctx context.Context, clk clock.Clock, bankStore BankStore, revisionStore BankConfigRevisionStore, groupStore GroupStore, group *Group) error {
+	if group.CircuitBreaker == nil {
+		return CircuitBreakerNotTripped
+	}
+
+	banks, err := bankStore.ListBankByGroupId(ctx, group.Id)
+	if err != nil {
+		return err
+	}
+	for _, bank := range ExcludeDeletedBanks(banks) {
+		state, ok := group.CircuitBreaker.PreviousStates[bank.Id]
+		if !ok {
+			continue
+		}
+		if err := updateBankOperationalState(ctx, clk, bankStore, revisionStore, bank, state, CircuitBreakerChangedBy+"restore"); err != nil {
+			return err
+		}
+	}
+
+	group.CircuitBreaker = nil
+	group.UpdatedAt = clk.Now().Unix()
+	return groupStore.UpdateGroup(ctx, group.Name, group)
+}
+
+// updateBankOperationalState stores the new state of the bank and records the revision
+func updateBankOperationalState(ctx context.Context, clk clock.Clock, bankStore BankStore, revisionStore BankConfigRevisionStore, bank *Bank, state BankOperationalState, changedBy string) error {
+	previous := bank.BankConfig
+	bank.OperationalState = state
+	if err := bankStore.UpdateBankConfig(ctx, bank.Id, &bank.BankConfig); err != nil {
+		return err
+	}
+	return RecordBankConfigRevision(ctx, clk, revisionStore, bank.Id, changedBy, previous, bank.BankConfig)
+}
+
+// AdminTripGroup is the admin action that pauses or reduces every bank of the group at once
+func AdminTripGroup(ctx context.Context, clk clock.Clock, bankStore BankStore, revisionStore BankConfigRevisionStore, groupStore GroupStore, group *Group, adminKey string, state BankOperationalState) error {
+	if adminKey == "" || adminKey != group.AdminKey {
+		return Unauthorized
+	}
+	return TripGroup(ctx, clk, bankStore, revisionStore, groupStore, group, state, CircuitBreakerReasonAdmin)
+}
+
+func AdminRestoreGroup(ctx context.Context, clk clock.Clock, bankStore BankStore, revisionStore BankConfigRevisionStore, groupStore GroupStore, group *Group, adminKey string) error {
+	if adminKey == "" || adminKey != group.AdminKey {
+		return Unauthorized
+	}
+	return RestoreGroup(ctx, clk, bankStore, revisionStore, groupStore, group)
+}
+
+func NewCircuitBreaker(clk clock.Clock, log Log, bankStore BankStore, revisionStore BankConfigRevisionStore, groupStore GroupStore) *CircuitBreaker {
+	return &CircuitBreaker{
+		clk:           clk,
+		log:           log,
+		bankStore:     bankStore,
+		revisionStore: revisionStore,
+		groupStore:    groupStore,
+		prices:        make(map[uuid.UUID][]pricePoint),
+	}
+}
+
+// ObservePrice records the oracle price of the bank and trips the group when the price moved more
+// than PriceMoveThreshold within PriceMoveWindow. It reports whether the group was tripped.
+func (c *CircuitBreaker) ObservePrice(ctx context.Context, group *Group, bank *Bank, price decimal.Decimal) (bool, error) {
+	config := group.GetConfig().CircuitBreaker
+	if !price.IsPositive() || !config.PriceMoveThreshold.IsPositive() {
+		return false, nil
+	}
+
+	move := c.recordPrice(bank.Id, price, config.PriceMoveWindow)
+	if move.LessThanOrEqual(config.PriceMoveThreshold) {
+		return false, nil
+	}
+
+	c.log.Warn().Msgf("circuit breaker: bank %s price moved %s within %ds", bank.Id, move, config.PriceMoveWindow)
+	return c.trip(ctx, group, config, CircuitBreakerReasonPriceMove)
+}
+
+// ObserveError trips the group when err is one of the configured triggers. It reports whether the
+// group was tripped.
+func (c *CircuitBreaker) ObserveError(ctx context.Context, group *Group, err error) (bool, error) {
+	config := group.GetConfig().CircuitBreaker
+	switch {
+	case config.TripOnUtilization && errors.Is(err, IllegalUtilizationRatio):
+		return c.trip(ctx, group, config, CircuitBreakerReasonUtilization)
+	case config.TripOnLiquidityDeficit && errors.Is(err, ErrBankLiquidityDeficit):
+		return c.trip(ctx, group, config, CircuitBreakerReasonLiquidityDeficit)
+	default:
+		return false, nil
+	}
+}
+
+// ObserveBank feeds the real time price of the bank to ObservePrice and then runs CheckBank, it is
+// called for every bank of the group on each price update
+func (c *CircuitBreaker) ObserveBank(ctx context.Context, group *Group, bank *Bank, priceFeed PriceAdapter) (bool, error) {
+	price, err := priceFeed.GetPriceOfType(RealTime, Original)
+	if err != nil {
+		return false, err
+	}
+	if tripped, err := c.ObservePrice(ctx, group, bank, price); err != nil || tripped {
+		return tripped, err
+	}
+	return c.CheckBank(ctx, group, bank)
+}
+
+// CheckBank runs the utilization and liquidity triggers against the current bank state
+func (c *CircuitBreaker) CheckBank(ctx context.Context, group *Group, bank *Bank) (bool, error) {
+	if err := bank.CheckUtilizationRatio(); err != nil {
+		return c.ObserveError(ctx, group, err)
+	}
+	if bank.LiquidityVault.IsNegative() {
+		return c.ObserveError(ctx, group, ErrBankLiquidityDeficit)
+	}
+	return false, nil
+}
+
+func (c *CircuitBreaker) trip(ctx context.Context, group *Group, config CircuitBreakerConfig, reason string) (bool, error) {
+	// an admin trip or an earlier automatic one is not overridden
+	if group.IsCircuitBreakerTripped() {
+		return false, nil
+	}
+
+	c.log.Warn().Msgf("circuit breaker: tripping group %s to %s, reason %s", group.Id, config.TripState, reason)
+	if err := TripGroup(ctx, c.clk, c.bankStore, c.revisionStore, c.groupStore, group, config.TripState, reason); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// recordPrice adds the price to the window of the bank and returns the largest relative move
+// between the new price and the prices still in the window
+func (c *CircuitBreaker) recordPrice(bankId uuid.UUID, price decimal.Decimal, window int64) decimal.Decimal {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clk.Now().Unix()
+	points := c.prices[bankId][:0]
+	move := decimal.Zero
+	for _, point := range c.prices[bankId] {
+		if now-point.timestamp > window {
+			continue
+		}
+		points = append(points, point)
+		move = decimal.Max(move, price.Sub(point.price).Abs().Div(point.price))
+	}
+	c.prices[bankId] = append(points, pricePoint{price: price, timestamp: now})
+
+	return move
+}
+
+func (s CircuitBreakerState) Value() (driver.Value, error) {
+	valueString, err := json.Marshal(s)
+	return string(valueString), err
+}
+
+func (s *CircuitBreakerState) Scan(value any) error {
+	if err := json.Unmarshal(value.([]byte), &s); err != nil {
+		return err
+	}
+	return nil
+}
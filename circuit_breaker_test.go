@@ -0,0 +1,167 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/clock"
+	"github.com/gofrs/uuid"
+	"github.com/rs/zerolog"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+type breakerStore struct {
+	scannerStore
+	revisionsStore
+	failBankId  uuid.UUID
+	failGroup   bool
+	bankStates  map[uuid.UUID]BankOperationalState
+	groupStates []*CircuitBreakerState
+}
+
+func newBreakerStore(banks ...*Bank) *breakerStore {
+	store := &breakerStore{
+		scannerStore: scannerStore{group: &Group{Id: uuid.Must(uuid.NewV4())}},
+		bankStates:   map[uuid.UUID]BankOperationalState{},
+	}
+	store.banks = banks
+	for _, bank := range banks {
+		store.bankStates[bank.Id] = bank.OperationalState
+	}
+	return store
+}
+
+func (s *breakerStore) UpdateBankConfig(ctx context.Context, bankId uuid.UUID, bankConfig *BankConfig) error {
+	if bankId == s.failBankId {
+		return errors.New("update bank failed")
+	}
+	s.bankStates[bankId] = bankConfig.OperationalState
+	return nil
+}
+
+func (s *breakerStore) UpdateGroup(ctx context.Context, name string, group *Group) error {
+	if s.failGroup {
+		return errors.New("update group failed")
+	}
+	s.groupStates = append(s.groupStates, group.CircuitBreaker)
+	return nil
+}
+
+func TestTripAndRestoreGroup(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewMock()
+	btc, usdt := newLoopBank("btc", 0.8, 0.9), newLoopBank("usdt", 0.9, 0.95)
+	usdt.OperationalState = BankOperationalStateReduceOnly
+	store := newBreakerStore(btc, usdt)
+	group := store.group
+
+	assert.NoError(t, TripGroup(ctx, clk, store, &store.revisionsStore, store, group, BankOperationalStatePaused, CircuitBreakerReasonAdmin))
+	assert.Equal(t, BankOperationalStatePaused, store.bankStates[btc.Id])
+	assert.Equal(t, BankOperationalStatePaused, store.bankStates[usdt.Id])
+
+	// a second trip keeps the states from before the first one
+	assert.NoError(t, TripGroup(ctx, clk, store, &store.revisionsStore, store, group, BankOperationalStateReduceOnly, CircuitBreakerReasonPriceMove))
+	assert.Equal(t, BankOperationalStateReduceOnly, store.bankStates[btc.Id])
+	assert.Equal(t, BankOperationalStateOperational, group.CircuitBreaker.PreviousStates[btc.Id])
+
+	assert.NoError(t, RestoreGroup(ctx, clk, store, &store.revisionsStore, store, group))
+	assert.Equal(t, BankOperationalStateOperational, store.bankStates[btc.Id])
+	assert.Equal(t, BankOperationalStateReduceOnly, store.bankStates[usdt.Id])
+	assert.Nil(t, group.CircuitBreaker)
+
+	// every bank change is recorded
+	revisions, err := store.ListBankConfigRevisions(ctx, btc.Id)
+	assert.NoError(t, err)
+	assert.Len(t, revisions, 3)
+	assert.Equal(t, CircuitBreakerChangedBy+CircuitBreakerReasonAdmin, revisions[0].ChangedBy)
+	assert.Equal(t, CircuitBreakerChangedBy+CircuitBreakerReasonPriceMove, revisions[1].ChangedBy)
+	assert.Equal(t, CircuitBreakerChangedBy+"restore", revisions[2].ChangedBy)
+	assert.Equal(t, BankOperationalStateOperational, revisions[2].Config.OperationalState)
+	assert.Equal(t, uuid.Nil, revisions[2].ProposalId)
+	// usdt was reduce only before the trips, its restore changes nothing and is not recorded
+	revisions, err = store.ListBankConfigRevisions(ctx, usdt.Id)
+	assert.NoError(t, err)
+	assert.Len(t, revisions, 2)
+	assert.ErrorIs(t, RestoreGroup(ctx, clk, store, &store.revisionsStore, store, group), CircuitBreakerNotTripped)
+}
+
+func TestTripGroupPartialFailure(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewMock()
+	btc, usdt := newLoopBank("btc", 0.8, 0.9), newLoopBank("usdt", 0.9, 0.95)
+	store := newBreakerStore(btc, usdt)
+	group := store.group
+
+	// nothing changes when the group can not be saved
+	store.failGroup = true
+	assert.Error(t, TripGroup(ctx, clk, store, &store.revisionsStore, store, group, BankOperationalStatePaused, CircuitBreakerReasonAdmin))
+	assert.Nil(t, group.CircuitBreaker)
+	assert.Equal(t, BankOperationalStateOperational, store.bankStates[btc.Id])
+
+	// the previous states of every bank are saved before the first bank is switched
+	store.failGroup, store.failBankId = false, usdt.Id
+	assert.Error(t, TripGroup(ctx, clk, store, &store.revisionsStore, store, group, BankOperationalStatePaused, CircuitBreakerReasonAdmin))
+	assert.Len(t, store.groupStates, 1)
+	assert.Len(t, store.groupStates[0].PreviousStates, 2)
+	assert.Equal(t, BankOperationalStatePaused, store.bankStates[btc.Id])
+
+	// a failed restore keeps the group tripped so it can run again
+	assert.Error(t, RestoreGroup(ctx, clk, store, &store.revisionsStore, store, group))
+	assert.NotNil(t, group.CircuitBreaker)
+
+	store.failBankId = uuid.Nil
+	assert.NoError(t, RestoreGroup(ctx, clk, store, &store.revisionsStore, store, group))
+	assert.Equal(t, BankOperationalStateOperational, store.bankStates[btc.Id])
+	assert.Equal(t, BankOperationalStateOperational, store.bankStates[usdt.Id])
+	assert.Nil(t, group.CircuitBreaker)
+}
+
+func TestLiquidationScannerTripsCircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewMock()
+	log := zerolog.Nop()
+	btc := newLoopBank("btc", 0.8, 0.9)
+	store := newBreakerStore(btc)
+	store.group.Config.CircuitBreaker = CircuitBreakerConfig{
+		PriceMoveThreshold: decimal.NewFromFloat(0.1),
+		PriceMoveWindow:    60,
+		TripState:          BankOperationalStatePaused,
+	}
+	prices := ratesPriceFeedMgr{"btc": decimal.NewFromInt(100)}
+	svc := BankAccountService{BalanceStore: store, BankStore: store, AccountStore: store, GroupStore: store}
+	breaker := NewCircuitBreaker(clk, &log, store, &store.revisionsStore, store)
+	scanner := NewLiquidationScanner(clk, &log, svc, prices, WithScannerCircuitBreaker(breaker))
+
+	_, err := scanner.Scan(ctx, store.group.Id)
+	assert.NoError(t, err)
+	assert.False(t, store.group.IsCircuitBreakerTripped())
+
+	clk.Add(30 * time.Second)
+	prices["btc"] = decimal.NewFromInt(120)
+	_, err = scanner.Scan(ctx, store.group.Id)
+	assert.NoError(t, err)
+	assert.True(t, store.group.IsCircuitBreakerTripped())
+	assert.Equal(t, CircuitBreakerReasonPriceMove, store.group.CircuitBreaker.Reason)
+	assert.Equal(t, BankOperationalStatePaused, store.bankStates[btc.Id])
+}
+
+func TestCircuitBreakerRecordPrice(t *testing.T) {
+	clk := clock.NewMock()
+	breaker := NewCircuitBreaker(clk, nil, nil, nil, nil)
+	bankId := uuid.Must(uuid.NewV4())
+
+	move := breaker.recordPrice(bankId, decimal.NewFromInt(100), 60)
+	assert.True(t, move.IsZero(), "expected 0, got %s", move)
+
+	clk.Add(30 * time.Second)
+	move = breaker.recordPrice(bankId, decimal.NewFromInt(110), 60)
+	assert.True(t, move.Equal(decimal.NewFromFloat(0.1)), "expected 0.1, got %s", move)
+
+	// the first price left the window
+	clk.Add(40 * time.Second)
+	move = breaker.recordPrice(bankId, decimal.NewFromInt(121), 60)
+	assert.True(t, move.Equal(decimal.NewFromFloat(0.1)), "expected 0.1, got %s", move)
+}
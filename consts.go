@@ -11,9 +11,26 @@ const (
 
 const (
 	SECONDS_PER_YEAR         = 31_536_000
+	SECONDS_PER_DAY          = 24 * 60 * 60
 	MIN_EMISSIONS_START_TIME = 1681989983
 
 	HOURS_PER_YEAR = 365.25 * 24
+
+	ACCOUNT_AUTHORITY_TRANSFER_TTL = 24 * 60 * 60
+
+	MAX_ACCOUNTS_PER_PUBKEY  = 16
+	MAX_ACCOUNT_LABEL_LENGTH = 32
+
+	DEFAULT_MAX_ACTIVE_BALANCES = 16
+	DEFAULT_ORACLE_MAX_AGE      = 90
+
+	MAX_PENDING_INTEREST_ACCRUALS = 1024
+
+	DEFAULT_CIRCUIT_BREAKER_WINDOW = 5 * 60
+	DEFAULT_BANK_CONFIG_TIMELOCK   = 24 * 60 * 60
+	DEFAULT_HEALTH_ALERT_COOLDOWN  = 60 * 60
+
+	DEFAULT_KEEPER_IN_FLIGHT_TIMEOUT = 10 * 60
 )
 
 var (
@@ -26,4 +43,10 @@ var (
 
 	LIQUIDATION_LIQUIDATOR_FEE = decimal.NewFromFloat(0.0025)
 	LIQUIDATION_INSURANCE_FEE  = decimal.NewFromFloat(0.0025)
+
+	DEFAULT_SWAP_SLIPPAGE = decimal.NewFromFloat(0.01)
+
+	DEFAULT_KEEPER_MAX_SLIPPAGE = decimal.NewFromFloat(0.005)
+
+	DEFAULT_CIRCUIT_BREAKER_PRICE_MOVE = decimal.NewFromFloat(0.2)
 )
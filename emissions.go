@@ -0,0 +1,108 @@
+package core
+
+import (
+	"context"
+
+	"github.com/gofrs/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// EmissionsApr reports the emissions of a bank in USD. EmissionsRate is paid in the emissions
+// asset per unit of the bank asset and year, Apr is the same rate in USD per USD.
+type EmissionsApr struct {
+	BankId                    uuid.UUID `json:"bankId"`
+	EmissionsMixinSafeAssetId string    `json:"emissionsMixinSafeAssetId"`
+
+	LendingEmissions   bool `json:"lendingEmissions"`
+	BorrowingEmissions bool `json:"borrowingEmissions"`
+
+	EmissionsPrice decimal.Decimal `json:"emissionsPrice"`
+	AssetPrice     decimal.Decimal `json:"assetPrice"`
+	Apr            decimal.Decimal `json:"apr"`
+
+	// EmittingAmount is the amount of the bank asset earning emissions, the deposits and/or borrows
+	EmittingAmount     decimal.Decimal `json:"emittingAmount"`
+	EmittingUsdValue   decimal.Decimal `json:"emittingUsdValue"`
+	EmissionsPerDay    decimal.Decimal `json:"emissionsPerDay"`
+	EmissionsRemaining decimal.Decimal `json:"emissionsRemaining"`
+	// RunwayDays is zero when nothing is emitted at the current amount
+	RunwayDays decimal.Decimal `json:"runwayDays"`
+}
+
+// ComputeEmissionsApr converts the emissions of the bank with the prices of the emissions asset and
+// the bank asset, and projects how long EmissionsRemaining lasts at the current deposits and borrows
+func (b *Bank) ComputeEmissionsApr(emissionsPrice, assetPrice decimal.Decimal) *EmissionsApr {
+	emissions := &EmissionsApr{
+		BankId:                    b.Id,
+		EmissionsMixinSafeAssetId: b.EmissionsMixinSafeAssetId,
+		LendingEmissions:          b.GetFlag(BankFlagsLendingActive),
+		BorrowingEmissions:        b.GetFlag(BankFlagsBorrowActive),
+		EmissionsPrice:            emissionsPrice,
+		AssetPrice:                assetPrice,
+		Apr:                       decimal.Zero,
+		EmittingAmount:            decimal.Zero,
+		EmittingUsdValue:          decimal.Zero,
+		EmissionsPerDay:           decimal.Zero,
+		EmissionsRemaining:        b.EmissionsRemaining,
+		RunwayDays:                decimal.Zero,
+	}
+	if emissions.LendingEmissions {
+		emissions.EmittingAmount = emissions.EmittingAmount.Add(b.GetTotalAssetQuantity())
+	}
+	if emissions.BorrowingEmissions {
+		emissions.EmittingAmount = emissions.EmittingAmount.Add(b.GetTotalLiabilityQuantity())
+	}
+	emissions.EmittingUsdValue = emissions.EmittingAmount.Mul(assetPrice)
+
+	if !b.EmissionsRate.IsPositive() || !b.EmissionsRemaining.IsPositive() {
+		return emissions
+	}
+	if !emissions.LendingEmissions && !emissions.BorrowingEmissions {
+		return emissions
+	}
+
+	if assetPrice.IsPositive() {
+		emissions.Apr = b.EmissionsRate.Mul(emissionsPrice).Div(assetPrice)
+	}
+	emissions.EmissionsPerDay = emissions.EmittingAmount.Mul(b.EmissionsRate).Mul(decimal.NewFromInt(SECONDS_PER_DAY)).Div(decimal.NewFromInt(SECONDS_PER_YEAR))
+	if emissions.EmissionsPerDay.IsPositive() {
+		emissions.RunwayDays = b.EmissionsRemaining.Div(emissions.EmissionsPerDay).Round(2)
+	}
+	return emissions
+}
+
+// GetEmissionsApr reads both prices from priceFeedMgr, the emissions asset is priced through the
+// bank of that asset in the same group. A missing emissions bank or a zero price gives a zero apr,
+// store and oracle errors are returned.
+func GetEmissionsApr(ctx context.Context, bankStore BankStore, priceFeedMgr PriceAdapterMgr, bank *Bank) (*EmissionsApr, error) {
+	assetPrice, err := getRealTimePrice(priceFeedMgr, bank)
+	if err != nil {
+		return nil, err
+	}
+	emissionsPrice, err := getEmissionsPrice(ctx, bankStore, priceFeedMgr, bank)
+	if err != nil {
+		return nil, err
+	}
+	return bank.ComputeEmissionsApr(emissionsPrice, assetPrice), nil
+}
+
+// ListEmissionsApr returns the emissions of every bank of the group with an emissions asset
+func ListEmissionsApr(ctx context.Context, bankStore BankStore, priceFeedMgr PriceAdapterMgr, groupId uuid.UUID) ([]*EmissionsApr, error) {
+	banks, err := bankStore.ListBankByGroupId(ctx, groupId)
+	if err != nil {
+		return nil, err
+	}
+
+	emissions := []*EmissionsApr{}
+	for _, bank := range ExcludeDeletedBanks(banks) {
+		if bank.EmissionsMixinSafeAssetId == "" {
+			continue
+		}
+		bankEmissions, err := GetEmissionsApr(ctx, bankStore, priceFeedMgr, bank)
+		if err != nil {
+			return nil, err
+		}
+		emissions = append(emissions, bankEmissions)
+	}
+	return emissions, nil
+}
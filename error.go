@@ -26,6 +26,8 @@ var (
 	InvalidConfig                         = errors.New("Invalid group config")
 	StaleOracle                           = errors.New("Stale oracle data")
 	BankPaused                            = errors.New("Bank paused")
+	GroupPaused                           = errors.New("Group paused")
+	CircuitBreakerNotTripped              = errors.New("Circuit breaker not tripped")
 	BankReduceOnly                        = errors.New("Bank is ReduceOnly mode")
 	BankAccountNotFound                   = errors.New("Bank account not found")
 	OperationDepositOnly                  = errors.New("Operation is deposit-only")
@@ -45,8 +47,9 @@ var (
 	CannotCloseOutstandingEmissions       = errors.New("Cannot close outstanding emissions")
 	EmissionsUpdateError                  = errors.New("Update emissions error")
 	AccountDisabled                       = errors.New("Account disabled")
-	AccountTempActiveBalanceLimitExceeded = errors.New("Account can't temporarily open 3 balances, please close a balance first")
+	AccountTempActiveBalanceLimitExceeded = errors.New("Account can't temporarily open more balances, please close a balance first")
 	AccountInFlashloan                    = errors.New("Illegal action during flashloan")
+	AccountClosed                         = errors.New("Account closed")
 	IllegalFlashloan                      = errors.New("Illegal flashloan")
 	IllegalFlag                           = errors.New("Illegal flag")
 	IllegalBalanceState                   = errors.New("Illegal balance state")
@@ -100,6 +103,38 @@ var (
 	ErrPriceCacheDirty = errors.New("price cache dirty")
 )
 
+var (
+	ErrLoopOptionsMissing   = errors.New("loop options missing")
+	ErrLoopLeverageTooHigh  = errors.New("target leverage exceeds bank weights")
+	ErrLoopAlreadySettled   = errors.New("loop swap already settled")
+	ErrLoopTypeMismatch     = errors.New("loop type differs from the position")
+	ErrSwapPending          = errors.New("swap order pending")
+	ErrSwapFailed           = errors.New("swap order failed")
+	ErrSwapSlippageExceeded = errors.New("swap slippage exceeded")
+	ErrPositionNotFound     = errors.New("position not found")
+	ErrAmbiguousPosition    = errors.New("position has more than one deposit or borrow balance")
+)
+
+var (
+	ErrInvalidAccountLabel = errors.New("invalid account label")
+	ErrBankNotEmpty        = errors.New("bank has outstanding shares")
+	ErrBankDeleted         = errors.New("bank deleted")
+	ErrInvalidDelistTime   = errors.New("invalid delist time")
+	ErrBankNotDelisted     = errors.New("bank is not delisted yet")
+	ErrBankVaultsNotSwept  = errors.New("bank fees and vaults are not swept")
+
+	ErrBankConfigTimelock      = errors.New("bank config change is before the timelock")
+	ErrBankConfigProposalState = errors.New("bank config proposal is not pending")
+	ErrBankConfigProposalEarly = errors.New("bank config proposal is not effective yet")
+	ErrBankConfigProposalStale = errors.New("bank config changed since the proposal")
+
+	ErrInvariantViolated = errors.New("invariant violated")
+	ErrLedgerUnbalanced  = errors.New("ledger journal is not balanced")
+
+	ErrKeeperInventoryInsufficient = errors.New("keeper inventory insufficient")
+	ErrInvalidHealthThreshold      = errors.New("invalid health threshold")
+)
+
 var (
 	ErrNotEnoughUtxos = errors.New("not enough utxos")
 	ErrInvalidUtxos   = errors.New("invalid utxos")
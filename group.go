@@ -2,9 +2,14 @@ package core
 
 import (
 	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"math"
+	"slices"
 
 	"github.com/facebookgo/clock"
 	"github.com/gofrs/uuid"
+	"github.com/shopspring/decimal"
 )
 
 type (
@@ -27,6 +32,40 @@ type (
 		CreatedAt   int64  `json:"createdAt"`
 		UpdatedAt   int64  `json:"updatedAt"`
 		Description string `json:"description"`
+
+		Config         GroupConfig          `json:"config"`
+		CircuitBreaker *CircuitBreakerState `json:"circuitBreaker,omitempty"`
+	}
+
+	// GroupConfig holds the risk limits of a group, unset fields fall back to the defaults in consts.go.
+	// The fees and the bankrupt threshold are pointers so that an explicit zero is kept.
+	GroupConfig struct {
+		LiquidationLiquidatorFee *decimal.Decimal `json:"liquidationLiquidatorFee,omitempty"`
+		LiquidationInsuranceFee  *decimal.Decimal `json:"liquidationInsuranceFee,omitempty"`
+		BankruptThreshold        *decimal.Decimal `json:"bankruptThreshold,omitempty"`
+
+		OracleMaxAge        int64         `json:"oracleMaxAge"`
+		AllowedOracleSetups []OracleSetup `json:"allowedOracleSetups"`
+
+		MaxAccountsPerPubKey int `json:"maxAccountsPerPubKey"`
+		MaxActiveBalances    int `json:"maxActiveBalances"`
+
+		// ConfigTimelock is the minimum delay of a bank config proposal that lowers a weight
+		ConfigTimelock int64 `json:"configTimelock"`
+
+		Paused bool `json:"paused"`
+
+		CircuitBreaker CircuitBreakerConfig `json:"circuitBreaker"`
+	}
+
+	// CircuitBreakerConfig sets when the group trips automatically, a zero PriceMoveThreshold
+	// disables the price trigger
+	CircuitBreakerConfig struct {
+		PriceMoveThreshold     decimal.Decimal      `json:"priceMoveThreshold"`
+		PriceMoveWindow        int64                `json:"priceMoveWindow"`
+		TripOnUtilization      bool                 `json:"tripOnUtilization"`
+		TripOnLiquidityDeficit bool                 `json:"tripOnLiquidityDeficit"`
+		TripState              BankOperationalState `json:"tripState"`
 	}
 )
 
@@ -38,6 +77,7 @@ func NewGroup(clk clock.Clock, adminKey string, name string, description string)
 		CreatedAt:   clk.Now().Unix(),
 		UpdatedAt:   clk.Now().Unix(),
 		Description: description,
+		Config:      DefaultGroupConfig(),
 	}
 }
 
@@ -47,3 +87,126 @@ func (g *Group) Update(clk clock.Clock, adminKey string, name string, descriptio
 	g.Description = description
 	g.UpdatedAt = clk.Now().Unix()
 }
+
+func (g *Group) UpdateConfig(clk clock.Clock, config GroupConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	g.Config = config
+	g.UpdatedAt = clk.Now().Unix()
+	return nil
+}
+
+// GetConfig returns the group config with every unset field filled with its default
+func (g *Group) GetConfig() GroupConfig {
+	return g.Config.WithDefaults()
+}
+
+func DefaultGroupConfig() GroupConfig {
+	liquidatorFee, insuranceFee, bankruptThreshold := LIQUIDATION_LIQUIDATOR_FEE, LIQUIDATION_INSURANCE_FEE, BANKRUPT_THRESHOLD
+	return GroupConfig{
+		LiquidationLiquidatorFee: &liquidatorFee,
+		LiquidationInsuranceFee:  &insuranceFee,
+		BankruptThreshold:        &bankruptThreshold,
+		OracleMaxAge:             DEFAULT_ORACLE_MAX_AGE,
+		AllowedOracleSetups:      []OracleSetup{MixinOracle},
+		MaxAccountsPerPubKey:     MAX_ACCOUNTS_PER_PUBKEY,
+		MaxActiveBalances:        DEFAULT_MAX_ACTIVE_BALANCES,
+		ConfigTimelock:           DEFAULT_BANK_CONFIG_TIMELOCK,
+		CircuitBreaker: CircuitBreakerConfig{
+			PriceMoveThreshold:     DEFAULT_CIRCUIT_BREAKER_PRICE_MOVE,
+			PriceMoveWindow:        DEFAULT_CIRCUIT_BREAKER_WINDOW,
+			TripOnUtilization:      true,
+			TripOnLiquidityDeficit: true,
+			TripState:              BankOperationalStatePaused,
+		},
+	}
+}
+
+func (c GroupConfig) WithDefaults() GroupConfig {
+	defaults := DefaultGroupConfig()
+	if c.LiquidationLiquidatorFee == nil {
+		c.LiquidationLiquidatorFee = defaults.LiquidationLiquidatorFee
+	}
+	if c.LiquidationInsuranceFee == nil {
+		c.LiquidationInsuranceFee = defaults.LiquidationInsuranceFee
+	}
+	if c.BankruptThreshold == nil {
+		c.BankruptThreshold = defaults.BankruptThreshold
+	}
+	if c.OracleMaxAge <= 0 {
+		c.OracleMaxAge = defaults.OracleMaxAge
+	}
+	if len(c.AllowedOracleSetups) == 0 {
+		c.AllowedOracleSetups = defaults.AllowedOracleSetups
+	}
+	if c.MaxAccountsPerPubKey <= 0 {
+		c.MaxAccountsPerPubKey = defaults.MaxAccountsPerPubKey
+	}
+	if c.MaxActiveBalances <= 0 {
+		c.MaxActiveBalances = defaults.MaxActiveBalances
+	}
+	if c.ConfigTimelock <= 0 {
+		c.ConfigTimelock = defaults.ConfigTimelock
+	}
+	if c.CircuitBreaker.PriceMoveWindow <= 0 {
+		c.CircuitBreaker.PriceMoveWindow = defaults.CircuitBreaker.PriceMoveWindow
+	}
+	return c
+}
+
+func (c GroupConfig) Validate() error {
+	resolved := c.WithDefaults()
+	if resolved.LiquidationLiquidatorFee.IsNegative() || resolved.LiquidationInsuranceFee.IsNegative() || resolved.BankruptThreshold.IsNegative() {
+		return InvalidConfig
+	}
+	if resolved.LiquidationLiquidatorFee.Add(*resolved.LiquidationInsuranceFee).GreaterThanOrEqual(ONE) {
+		return InvalidConfig
+	}
+	if c.OracleMaxAge < 0 || c.MaxAccountsPerPubKey < 0 || c.MaxActiveBalances < 0 || c.ConfigTimelock < 0 {
+		return InvalidConfig
+	}
+	// account indexes are uint8
+	if c.MaxAccountsPerPubKey > math.MaxUint8+1 {
+		return InvalidConfig
+	}
+	if c.CircuitBreaker.PriceMoveThreshold.IsNegative() || c.CircuitBreaker.PriceMoveWindow < 0 {
+		return InvalidConfig
+	}
+	if !IsCircuitBreakerState(c.CircuitBreaker.TripState) {
+		return InvalidConfig
+	}
+	return nil
+}
+
+func (c GroupConfig) IsOracleSetupAllowed(oracleSetup OracleSetup) bool {
+	return slices.Contains(c.AllowedOracleSetups, oracleSetup)
+}
+
+// ValidateBankConfig checks a bank config against the oracle limits of the group
+func (c GroupConfig) ValidateBankConfig(bankConfig *BankConfig) error {
+	switch bankConfig.OracleSetup {
+	case MixinOracle:
+	default:
+		return ErrUnknownOracleSetup
+	}
+	if !c.IsOracleSetupAllowed(bankConfig.OracleSetup) {
+		return InvalidOracleSetup
+	}
+	if bankConfig.OracleMaxAge > c.OracleMaxAge {
+		return ErrOracleMaxAgeTooLong
+	}
+	return nil
+}
+
+func (c GroupConfig) Value() (driver.Value, error) {
+	valueString, err := json.Marshal(c)
+	return string(valueString), err
+}
+
+func (c *GroupConfig) Scan(value any) error {
+	if err := json.Unmarshal(value.([]byte), &c); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupConfigValidateBankConfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     GroupConfig
+		bankConfig BankConfig
+		wantErr    error
+	}{
+		{
+			name:       "default",
+			config:     GroupConfig{}.WithDefaults(),
+			bankConfig: BankConfig{OracleSetup: MixinOracle, OracleMaxAge: DEFAULT_ORACLE_MAX_AGE},
+		},
+		{
+			name:       "oracle max age above default",
+			config:     GroupConfig{}.WithDefaults(),
+			bankConfig: BankConfig{OracleSetup: MixinOracle, OracleMaxAge: DEFAULT_ORACLE_MAX_AGE + 1},
+			wantErr:    ErrOracleMaxAgeTooLong,
+		},
+		{
+			name:       "oracle max age of group",
+			config:     GroupConfig{OracleMaxAge: 30}.WithDefaults(),
+			bankConfig: BankConfig{OracleSetup: MixinOracle, OracleMaxAge: 60},
+			wantErr:    ErrOracleMaxAgeTooLong,
+		},
+		{
+			name:       "unknown oracle setup",
+			config:     GroupConfig{}.WithDefaults(),
+			bankConfig: BankConfig{OracleSetup: OracleSetup(9)},
+			wantErr:    ErrUnknownOracleSetup,
+		},
+		{
+			name:       "oracle setup not allowed",
+			config:     GroupConfig{AllowedOracleSetups: []OracleSetup{OracleSetup(9)}}.WithDefaults(),
+			bankConfig: BankConfig{OracleSetup: MixinOracle},
+			wantErr:    InvalidOracleSetup,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.ValidateBankConfig(&tt.bankConfig)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestGroupConfigValidate(t *testing.T) {
+	assert.NoError(t, DefaultGroupConfig().Validate())
+	assert.NoError(t, GroupConfig{}.Validate())
+
+	liquidatorFee, insuranceFee := decimal.NewFromFloat(0.6), decimal.NewFromFloat(0.4)
+	config := DefaultGroupConfig()
+	config.LiquidationLiquidatorFee = &liquidatorFee
+	config.LiquidationInsuranceFee = &insuranceFee
+	assert.ErrorIs(t, config.Validate(), InvalidConfig)
+
+	// only the liquidator fee is set, the insurance fee falls back to its default
+	liquidatorFee = ONE.Sub(LIQUIDATION_INSURANCE_FEE)
+	assert.ErrorIs(t, GroupConfig{LiquidationLiquidatorFee: &liquidatorFee}.Validate(), InvalidConfig)
+
+	config = DefaultGroupConfig()
+	config.MaxAccountsPerPubKey = 257
+	assert.ErrorIs(t, config.Validate(), InvalidConfig)
+}
+
+func TestGroupConfigWithDefaultsKeepsExplicitZero(t *testing.T) {
+	zero := decimal.Zero
+	config := GroupConfig{LiquidationInsuranceFee: &zero, BankruptThreshold: &zero}.WithDefaults()
+	assert.True(t, config.LiquidationInsuranceFee.IsZero())
+	assert.True(t, config.BankruptThreshold.IsZero())
+	assert.True(t, config.LiquidationLiquidatorFee.Equal(LIQUIDATION_LIQUIDATOR_FEE))
+	assert.NoError(t, config.Validate())
+
+	// the defaults are copies, changing a resolved config leaves them alone
+	*config.LiquidationLiquidatorFee = ONE
+	assert.True(t, DefaultGroupConfig().LiquidationLiquidatorFee.Equal(LIQUIDATION_LIQUIDATOR_FEE))
+}
@@ -0,0 +1,328 @@
+package core
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/DomeLiquid/core/utils"
+	"github.com/facebookgo/clock"
+	"github.com/fox-one/mixin-sdk-go/v2"
+	"github.com/gofrs/uuid"
+	"github.com/shopspring/decimal"
+)
+
+type (
+	HealthSubscriptionStore interface {
+		// UpsertHealthSubscription inserts the subscription or updates the one with the same Id, an
+		// existing subscription keeps its CreatedAt and AlertedAt
+		UpsertHealthSubscription(ctx context.Context, subscription *HealthSubscription) error
+		FindHealthSubscription(ctx context.Context, subscriptionId uuid.UUID) (*HealthSubscription, error)
+		UpdateHealthSubscriptionAlertedAt(ctx context.Context, subscriptionId uuid.UUID, alertedAt int64) error
+		DeleteHealthSubscription(ctx context.Context, subscriptionId uuid.UUID) error
+		ListHealthSubscriptions(ctx context.Context) ([]*HealthSubscription, error)
+	}
+
+	// HealthSubscription alerts UserId when the Maintenance health of the account, as returned by
+	// GetAccountHealth, falls below Threshold. There is one subscription per account, user and
+	// threshold.
+	HealthSubscription struct {
+		Id        uuid.UUID       `json:"id"`
+		AccountId uuid.UUID       `json:"accountId"`
+		UserId    string          `json:"userId"`
+		Threshold decimal.Decimal `json:"threshold"`
+		// AlertedAt is the last alert sent to the user for the account, zero once the health recovered
+		AlertedAt int64 `json:"alertedAt"`
+
+		CreatedAt int64 `json:"createdAt"`
+		UpdatedAt int64 `json:"updatedAt"`
+	}
+
+	HealthAlert struct {
+		Id        string          `json:"id"`
+		AccountId uuid.UUID       `json:"accountId"`
+		UserId    string          `json:"userId"`
+		Health    decimal.Decimal `json:"health"`
+		Threshold decimal.Decimal `json:"threshold"`
+		CreatedAt int64           `json:"createdAt"`
+	}
+
+	Notifier interface {
+		Notify(ctx context.Context, alert *HealthAlert) error
+	}
+
+	// MixinMessageSender is implemented by *mixin.Client
+	MixinMessageSender interface {
+		SendMessage(ctx context.Context, message *mixin.MessageRequest) error
+	}
+
+	// MixinNotifier sends the alerts as plain text messages from the app clientId
+	MixinNotifier struct {
+		clientId string
+		sender   MixinMessageSender
+	}
+
+	// LocalNotifier keeps the alerts in memory, for tests and local runs
+	LocalNotifier struct {
+		mu     sync.Mutex
+		alerts []*HealthAlert
+	}
+
+	// HealthMonitor evaluates the subscribed accounts and notifies the breaches, at most once per
+	// account and user every cooldown until the health recovers above every threshold. The time of
+	// the last alert is stored with the subscriptions so a restart does not alert again.
+	HealthMonitor struct {
+		clk clock.Clock
+		log Log
+
+		cooldown int64
+
+		bankAccountService BankAccountService
+		subscriptionStore  HealthSubscriptionStore
+		priceFeedMgr       PriceAdapterMgr
+		notifier           Notifier
+	}
+
+	HealthMonitorOptionFunc func(m *HealthMonitor)
+
+	healthAlertKey struct {
+		accountId uuid.UUID
+		userId    string
+	}
+)
+
+func NewMixinNotifier(clientId string, sender MixinMessageSender) *MixinNotifier {
+	return &MixinNotifier{
+		clientId: clientId,
+		sender:   sender,
+	}
+}
+
+func (n *MixinNotifier) Notify(ctx context.Context, alert *HealthAlert) error {
+	text := fmt.Sprintf("Account %s health is %s%%, below your alert threshold of %s%%. Repay or deposit to avoid liquidation.",
+		alert.AccountId, alert.Health.Mul(decimal.NewFromInt(100)).StringFixed(2), alert.Threshold.Mul(decimal.NewFromInt(100)).StringFixed(2))
+
+	return n.sender.SendMessage(ctx, &mixin.MessageRequest{
+		ConversationID: mixin.UniqueConversationID(n.clientId, alert.UserId),
+		RecipientID:    alert.UserId,
+		MessageID:      alert.Id,
+		Category:       mixin.MessageCategoryPlainText,
+		DataBase64:     base64.RawURLEncoding.EncodeToString([]byte(text)),
+	})
+}
+
+func NewLocalNotifier() *LocalNotifier {
+	return &LocalNotifier{alerts: []*HealthAlert{}}
+}
+
+func (n *LocalNotifier) Notify(ctx context.Context, alert *HealthAlert) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.alerts = append(n.alerts, alert)
+	return nil
+}
+
+func (n *LocalNotifier) Alerts() []*HealthAlert {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]*HealthAlert{}, n.alerts...)
+}
+
+// WithHealthAlertCooldown sets the seconds between two alerts of the same account and user
+func WithHealthAlertCooldown(cooldown int64) HealthMonitorOptionFunc {
+	return func(m *HealthMonitor) {
+		m.cooldown = cooldown
+	}
+}
+
+func NewHealthMonitor(clk clock.Clock, log Log, bankAccountService BankAccountService, subscriptionStore HealthSubscriptionStore, priceFeedMgr PriceAdapterMgr, notifier Notifier, opts ...HealthMonitorOptionFunc) *HealthMonitor {
+	m := &HealthMonitor{
+		clk:                clk,
+		log:                log,
+		cooldown:           DEFAULT_HEALTH_ALERT_COOLDOWN,
+		bankAccountService: bankAccountService,
+		subscriptionStore:  subscriptionStore,
+		priceFeedMgr:       priceFeedMgr,
+		notifier:           notifier,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Subscribe alerts userId when the health of the account falls below threshold, in (0, 1), the
+// same account, user and threshold update the existing subscription. Only the owner of the account
+// can subscribe to it.
+func (m *HealthMonitor) Subscribe(ctx context.Context, accountId uuid.UUID, userId string, threshold decimal.Decimal) (*HealthSubscription, error) {
+	if !threshold.IsPositive() || threshold.GreaterThanOrEqual(ONE) || userId == "" {
+		return nil, ErrInvalidHealthThreshold
+	}
+	account, err := m.bankAccountService.GetAccountById(ctx, accountId)
+	if err != nil {
+		return nil, err
+	}
+	if account.PubKey != userId {
+		return nil, Unauthorized
+	}
+
+	now := m.clk.Now().Unix()
+	subscription := &HealthSubscription{
+		Id:        uuid.FromStringOrNil(utils.GenUuidFromStrings(accountId.String(), userId, threshold.String())),
+		AccountId: accountId,
+		UserId:    userId,
+		Threshold: threshold,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := m.subscriptionStore.UpsertHealthSubscription(ctx, subscription); err != nil {
+		return nil, err
+	}
+	return subscription, nil
+}
+
+// Unsubscribe deletes the subscription of userId
+func (m *HealthMonitor) Unsubscribe(ctx context.Context, subscriptionId uuid.UUID, userId string) error {
+	subscription, err := m.subscriptionStore.FindHealthSubscription(ctx, subscriptionId)
+	if err != nil {
+		return err
+	}
+	if subscription.UserId != userId {
+		return Unauthorized
+	}
+	return m.subscriptionStore.DeleteHealthSubscription(ctx, subscriptionId)
+}
+
+// Evaluate computes the health of every subscribed account once with one price snapshot and
+// notifies the breached subscriptions, returning the alerts sent
+func (m *HealthMonitor) Evaluate(ctx context.Context) ([]*HealthAlert, error) {
+	subscriptions, err := m.subscriptionStore.ListHealthSubscriptions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	prices := NewPriceSnapshot(m.priceFeedMgr)
+	healths := map[uuid.UUID]decimal.Decimal{}
+	// the breached subscription with the lowest threshold of every account and user
+	breaches := map[healthAlertKey]*HealthSubscription{}
+	keys := []healthAlertKey{}
+	recovered := map[healthAlertKey]bool{}
+	alertedAt := map[healthAlertKey]int64{}
+	keySubscriptions := map[healthAlertKey][]*HealthSubscription{}
+	for _, subscription := range subscriptions {
+		health, ok := healths[subscription.AccountId]
+		if !ok {
+			health, err = m.accountHealth(ctx, prices, subscription.AccountId)
+			if err != nil {
+				m.log.Warn().Msgf("health of account %s failed: %v", subscription.AccountId, err)
+				continue
+			}
+			healths[subscription.AccountId] = health
+		}
+
+		key := healthAlertKey{accountId: subscription.AccountId, userId: subscription.UserId}
+		keySubscriptions[key] = append(keySubscriptions[key], subscription)
+		alertedAt[key] = max(alertedAt[key], subscription.AlertedAt)
+		if _, ok := recovered[key]; !ok {
+			recovered[key] = true
+		}
+		if !health.LessThan(subscription.Threshold) {
+			continue
+		}
+		recovered[key] = false
+		breach, ok := breaches[key]
+		if !ok {
+			keys = append(keys, key)
+		}
+		if !ok || subscription.Threshold.LessThan(breach.Threshold) {
+			breaches[key] = subscription
+		}
+	}
+
+	for key, ok := range recovered {
+		if ok && alertedAt[key] != 0 {
+			m.setAlertedAt(ctx, keySubscriptions[key], 0)
+		}
+	}
+
+	now := m.clk.Now().Unix()
+	alerts := []*HealthAlert{}
+	for _, key := range keys {
+		if alertedAt[key] != 0 && now-alertedAt[key] < m.cooldown {
+			continue
+		}
+
+		subscription := breaches[key]
+		alert := &HealthAlert{
+			Id:        utils.GenUuidFromStrings(key.accountId.String(), key.userId, strconv.FormatInt(now, 10)),
+			AccountId: key.accountId,
+			UserId:    key.userId,
+			Health:    healths[key.accountId],
+			Threshold: subscription.Threshold,
+			CreatedAt: now,
+		}
+		if err := m.notifier.Notify(ctx, alert); err != nil {
+			m.log.Error().Msgf("health alert of account %s failed: %v", key.accountId, err)
+			continue
+		}
+		m.setAlertedAt(ctx, keySubscriptions[key], now)
+		alerts = append(alerts, alert)
+	}
+	return alerts, nil
+}
+
+// setAlertedAt stores the alert time on every subscription of an account and user, a failure is
+// logged and the next evaluation reads the previous value
+func (m *HealthMonitor) setAlertedAt(ctx context.Context, subscriptions []*HealthSubscription, alertedAt int64) {
+	for _, subscription := range subscriptions {
+		if err := m.subscriptionStore.UpdateHealthSubscriptionAlertedAt(ctx, subscription.Id, alertedAt); err != nil {
+			m.log.Error().Msgf("health subscription %s alert time failed: %v", subscription.Id, err)
+			continue
+		}
+		subscription.AlertedAt = alertedAt
+	}
+}
+
+func (m *HealthMonitor) accountHealth(ctx context.Context, prices PriceAdapterMgr, accountId uuid.UUID) (decimal.Decimal, error) {
+	account, err := m.bankAccountService.GetAccountById(ctx, accountId)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	if account.GetFlag(ClosedFlag) {
+		return ONE, nil
+	}
+
+	riskEngine, err := NewRiskEngineNoFlashloanCheck(ctx, m.bankAccountService, account, []*BankAccountWrapper{}, prices)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	totalAssets, totalLiabilities, err := riskEngine.GetAccountHealthComponents(Maintenance)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	// GetAccountHealth reports no assets as healthy, with liabilities the account is fully underwater
+	if !totalAssets.IsPositive() && totalLiabilities.IsPositive() {
+		return decimal.Zero, nil
+	}
+	return GetAccountHealth(totalAssets, totalLiabilities), nil
+}
+
+// Run evaluates the subscriptions every interval until ctx is done
+func (m *HealthMonitor) Run(ctx context.Context, interval time.Duration) error {
+	ticker := m.clk.Ticker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if _, err := m.Evaluate(ctx); err != nil {
+				m.log.Error().Msgf("health evaluation failed: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/clock"
+	"github.com/gofrs/uuid"
+	"github.com/rs/zerolog"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+type healthSubscriptionStore struct {
+	subscriptions []*HealthSubscription
+}
+
+func (s *healthSubscriptionStore) UpsertHealthSubscription(ctx context.Context, subscription *HealthSubscription) error {
+	for i, existing := range s.subscriptions {
+		if existing.Id == subscription.Id {
+			subscription.CreatedAt, subscription.AlertedAt = existing.CreatedAt, existing.AlertedAt
+			s.subscriptions[i] = subscription
+			return nil
+		}
+	}
+	s.subscriptions = append(s.subscriptions, subscription)
+	return nil
+}
+
+func (s *healthSubscriptionStore) UpdateHealthSubscriptionAlertedAt(ctx context.Context, subscriptionId uuid.UUID, alertedAt int64) error {
+	for _, subscription := range s.subscriptions {
+		if subscription.Id == subscriptionId {
+			subscription.AlertedAt = alertedAt
+		}
+	}
+	return nil
+}
+
+func (s *healthSubscriptionStore) FindHealthSubscription(ctx context.Context, subscriptionId uuid.UUID) (*HealthSubscription, error) {
+	for _, subscription := range s.subscriptions {
+		if subscription.Id == subscriptionId {
+			return subscription, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (s *healthSubscriptionStore) DeleteHealthSubscription(ctx context.Context, subscriptionId uuid.UUID) error {
+	for i, subscription := range s.subscriptions {
+		if subscription.Id == subscriptionId {
+			s.subscriptions = append(s.subscriptions[:i], s.subscriptions[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (s *healthSubscriptionStore) ListHealthSubscriptions(ctx context.Context) ([]*HealthSubscription, error) {
+	return s.subscriptions, nil
+}
+
+func TestHealthMonitor(t *testing.T) {
+	btc, usdt := newBank("btc"), newBank("usdt")
+	accountId := uuid.Must(uuid.NewV4())
+	store := &scannerStore{accounts: map[uuid.UUID]*Account{accountId: {Id: accountId, PubKey: "user"}}}
+	store.banks = []*Bank{btc, usdt}
+	store.balances = []*Balance{
+		{AccountId: accountId, BankId: btc.Id, Active: true, AssetShares: ONE, LiabilityShares: decimal.Zero},
+		{AccountId: accountId, BankId: usdt.Id, Active: true, AssetShares: decimal.Zero, LiabilityShares: decimal.NewFromInt(60)},
+	}
+	prices := ratesPriceFeedMgr{"btc": decimal.NewFromInt(80), "usdt": ONE}
+	svc := BankAccountService{BalanceStore: store, BankStore: store, AccountStore: store, GroupStore: store}
+	log := zerolog.Nop()
+	clk := clock.NewMock()
+	// a zero AlertedAt means no alert was sent, start after the epoch
+	clk.Add(time.Hour)
+	notifier := NewLocalNotifier()
+	subscriptionStore := &healthSubscriptionStore{}
+	monitor := NewHealthMonitor(clk, &log, svc, subscriptionStore, prices, notifier)
+	ctx := context.Background()
+
+	_, err := monitor.Subscribe(ctx, accountId, "user", ONE)
+	assert.ErrorIs(t, err, ErrInvalidHealthThreshold)
+	_, err = monitor.Subscribe(ctx, accountId, "user", decimal.NewFromFloat(0.2))
+	assert.NoError(t, err)
+	_, err = monitor.Subscribe(ctx, accountId, "user", decimal.NewFromFloat(0.1))
+	assert.NoError(t, err)
+	// the same threshold again updates the subscription
+	_, err = monitor.Subscribe(ctx, accountId, "user", decimal.NewFromFloat(0.10))
+	assert.NoError(t, err)
+	assert.Len(t, subscriptionStore.subscriptions, 2)
+
+	alerts, err := monitor.Evaluate(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, alerts, 1)
+	assert.True(t, alerts[0].Health.Equal(decimal.NewFromFloat(0.0625)))
+	assert.True(t, alerts[0].Threshold.Equal(decimal.NewFromFloat(0.1)))
+
+	alerts, _ = monitor.Evaluate(ctx)
+	assert.Empty(t, alerts)
+
+	// a restarted monitor reads the last alert from the subscriptions
+	monitor = NewHealthMonitor(clk, &log, svc, subscriptionStore, prices, notifier)
+	alerts, _ = monitor.Evaluate(ctx)
+	assert.Empty(t, alerts)
+
+	clk.Add(DEFAULT_HEALTH_ALERT_COOLDOWN * time.Second)
+	alerts, _ = monitor.Evaluate(ctx)
+	assert.Len(t, alerts, 1)
+
+	prices["btc"] = decimal.NewFromInt(200)
+	alerts, _ = monitor.Evaluate(ctx)
+	assert.Empty(t, alerts)
+
+	prices["btc"] = decimal.NewFromInt(80)
+	alerts, _ = monitor.Evaluate(ctx)
+	assert.Len(t, alerts, 1)
+	assert.Len(t, notifier.Alerts(), 3)
+}
+
+func TestHealthMonitorNoAssets(t *testing.T) {
+	usdt := newBank("usdt")
+	accountId := uuid.Must(uuid.NewV4())
+	store := &scannerStore{accounts: map[uuid.UUID]*Account{accountId: {Id: accountId, PubKey: "user"}}}
+	store.banks = []*Bank{usdt}
+	store.balances = []*Balance{
+		{AccountId: accountId, BankId: usdt.Id, Active: true, AssetShares: decimal.Zero, LiabilityShares: decimal.NewFromInt(60)},
+	}
+	svc := BankAccountService{BalanceStore: store, BankStore: store, AccountStore: store, GroupStore: store}
+	log := zerolog.Nop()
+	monitor := NewHealthMonitor(clock.NewMock(), &log, svc, &healthSubscriptionStore{}, ratesPriceFeedMgr{"usdt": ONE}, NewLocalNotifier())
+	ctx := context.Background()
+
+	_, err := monitor.Subscribe(ctx, accountId, "user", decimal.NewFromFloat(0.1))
+	assert.NoError(t, err)
+	alerts, err := monitor.Evaluate(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, alerts, 1)
+	assert.True(t, alerts[0].Health.IsZero())
+}
+
+func TestHealthMonitorOwnership(t *testing.T) {
+	accountId := uuid.Must(uuid.NewV4())
+	store := &scannerStore{accounts: map[uuid.UUID]*Account{accountId: {Id: accountId, PubKey: "user"}}}
+	svc := BankAccountService{BalanceStore: store, BankStore: store, AccountStore: store, GroupStore: store}
+	log := zerolog.Nop()
+	subscriptionStore := &healthSubscriptionStore{}
+	monitor := NewHealthMonitor(clock.NewMock(), &log, svc, subscriptionStore, ratesPriceFeedMgr{}, NewLocalNotifier())
+	ctx := context.Background()
+
+	_, err := monitor.Subscribe(ctx, accountId, "other", decimal.NewFromFloat(0.1))
+	assert.ErrorIs(t, err, Unauthorized)
+	assert.Empty(t, subscriptionStore.subscriptions)
+
+	subscription, err := monitor.Subscribe(ctx, accountId, "user", decimal.NewFromFloat(0.1))
+	assert.NoError(t, err)
+	assert.ErrorIs(t, monitor.Unsubscribe(ctx, subscription.Id, "other"), Unauthorized)
+	assert.Len(t, subscriptionStore.subscriptions, 1)
+	assert.NoError(t, monitor.Unsubscribe(ctx, subscription.Id, "user"))
+	assert.Empty(t, subscriptionStore.subscriptions)
+}
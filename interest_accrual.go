@@ -0,0 +1,165 @@
+package core
+
+import (
+	"context"
+
+	"github.com/gofrs/uuid"
+	"github.com/shopspring/decimal"
+)
+
+type (
+	InterestAccrualStore interface {
+		CreateInterestAccruals(ctx context.Context, accruals []*InterestAccrual) error
+		// ListInterestAccruals lists the accruals of the bank whose period overlaps [from, to], oldest first
+		ListInterestAccruals(ctx context.Context, bankId uuid.UUID, from, to int64) ([]*InterestAccrual, error)
+	}
+
+	// InterestAccrual is one AccrueInterest call of a bank, the rates apply to the whole period
+	// from StartedAt to Timestamp and the share values are the ones after the accrual
+	InterestAccrual struct {
+		Id        uuid.UUID `json:"id"`
+		BankId    uuid.UUID `json:"bankId"`
+		StartedAt int64     `json:"startedAt"`
+		Timestamp int64     `json:"timestamp"`
+
+		UtilizationRate decimal.Decimal `json:"utilizationRate"`
+		LendingApr      decimal.Decimal `json:"lendingApr"`
+		BorrowingApr    decimal.Decimal `json:"borrowingApr"`
+		GroupFeeApr     decimal.Decimal `json:"groupFeeApr"`
+		InsuranceFeeApr decimal.Decimal `json:"insuranceFeeApr"`
+
+		AssetShareValue     decimal.Decimal `json:"assetShareValue"`
+		LiabilityShareValue decimal.Decimal `json:"liabilityShareValue"`
+
+		GroupFeesCollected     decimal.Decimal `json:"groupFeesCollected"`
+		InsuranceFeesCollected decimal.Decimal `json:"insuranceFeesCollected"`
+	}
+
+	// InterestRatePoint is the time weighted rate of a bank over [From, To]
+	InterestRatePoint struct {
+		From            int64           `json:"from"`
+		To              int64           `json:"to"`
+		UtilizationRate decimal.Decimal `json:"utilizationRate"`
+		SupplyApr       decimal.Decimal `json:"supplyApr"`
+		BorrowApr       decimal.Decimal `json:"borrowApr"`
+		SupplyApy       decimal.Decimal `json:"supplyApy"`
+		BorrowApy       decimal.Decimal `json:"borrowApy"`
+		// Covered is the number of seconds of the window with a recorded accrual
+		Covered int64 `json:"covered"`
+	}
+)
+
+func (b *Bank) newInterestAccrual(currentTimestamp int64) *InterestAccrual {
+	return &InterestAccrual{
+		Id:                     uuid.Must(uuid.NewV4()),
+		BankId:                 b.Id,
+		StartedAt:              b.LastUpdate,
+		Timestamp:              currentTimestamp,
+		UtilizationRate:        decimal.Zero,
+		LendingApr:             decimal.Zero,
+		BorrowingApr:           decimal.Zero,
+		GroupFeeApr:            decimal.Zero,
+		InsuranceFeeApr:        decimal.Zero,
+		GroupFeesCollected:     decimal.Zero,
+		InsuranceFeesCollected: decimal.Zero,
+	}
+}
+
+// recordInterestAccrual keeps the accrual until RecordInterestAccruals stores it, a bank that is
+// never recorded only keeps the latest MAX_PENDING_INTEREST_ACCRUALS
+func (b *Bank) recordInterestAccrual(accrual *InterestAccrual) {
+	accrual.AssetShareValue = b.AssetShareValue
+	accrual.LiabilityShareValue = b.LiabilityShareValue
+	b.accruals = append(b.accruals, accrual)
+	if len(b.accruals) > MAX_PENDING_INTEREST_ACCRUALS {
+		b.accruals = append([]*InterestAccrual{}, b.accruals[len(b.accruals)-MAX_PENDING_INTEREST_ACCRUALS:]...)
+	}
+}
+
+// InterestAccruals returns the accruals of the bank that are not stored yet
+func (b *Bank) InterestAccruals() []*InterestAccrual {
+	return b.accruals
+}
+
+// RecordInterestAccruals stores the pending accruals of the banks, call it once the banks are stored
+func RecordInterestAccruals(ctx context.Context, accrualStore InterestAccrualStore, banks ...*Bank) error {
+	for _, bank := range banks {
+		if len(bank.accruals) == 0 {
+			continue
+		}
+		if err := accrualStore.CreateInterestAccruals(ctx, bank.accruals); err != nil {
+			return err
+		}
+		bank.accruals = nil
+	}
+	return nil
+}
+
+// InterestRateHistory returns the rates of the bank over [from, to] for charts, one point per
+// interval, or one point per accrual when interval is not positive
+func InterestRateHistory(ctx context.Context, accrualStore InterestAccrualStore, bankId uuid.UUID, from, to, interval int64) ([]*InterestRatePoint, error) {
+	accruals, err := accrualStore.ListInterestAccruals(ctx, bankId, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	points := []*InterestRatePoint{}
+	if interval <= 0 {
+		for _, accrual := range accruals {
+			points = append(points, averageInterestRate([]*InterestAccrual{accrual}, accrual.StartedAt, accrual.Timestamp))
+		}
+		return points, nil
+	}
+
+	for start := from; start < to; start += interval {
+		end := min(start+interval, to)
+		points = append(points, averageInterestRate(accruals, start, end))
+	}
+	return points, nil
+}
+
+// AverageInterestRate returns the time weighted rates of the bank over [from, to]
+func AverageInterestRate(ctx context.Context, accrualStore InterestAccrualStore, bankId uuid.UUID, from, to int64) (*InterestRatePoint, error) {
+	accruals, err := accrualStore.ListInterestAccruals(ctx, bankId, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return averageInterestRate(accruals, from, to), nil
+}
+
+// averageInterestRate weights every accrual by its overlap with [from, to], the time without
+// accruals is left out of the average
+func averageInterestRate(accruals []*InterestAccrual, from, to int64) *InterestRatePoint {
+	point := &InterestRatePoint{
+		From:            from,
+		To:              to,
+		UtilizationRate: decimal.Zero,
+		SupplyApr:       decimal.Zero,
+		BorrowApr:       decimal.Zero,
+		SupplyApy:       decimal.Zero,
+		BorrowApy:       decimal.Zero,
+	}
+
+	for _, accrual := range accruals {
+		overlap := min(accrual.Timestamp, to) - max(accrual.StartedAt, from)
+		if overlap <= 0 {
+			continue
+		}
+		weight := decimal.NewFromInt(overlap)
+		point.UtilizationRate = point.UtilizationRate.Add(accrual.UtilizationRate.Mul(weight))
+		point.SupplyApr = point.SupplyApr.Add(accrual.LendingApr.Mul(weight))
+		point.BorrowApr = point.BorrowApr.Add(accrual.BorrowingApr.Mul(weight))
+		point.Covered += overlap
+	}
+	if point.Covered == 0 {
+		return point
+	}
+
+	covered := decimal.NewFromInt(point.Covered)
+	point.UtilizationRate = point.UtilizationRate.Div(covered)
+	point.SupplyApr = point.SupplyApr.Div(covered)
+	point.BorrowApr = point.BorrowApr.Div(covered)
+	point.SupplyApy = AprToApy(point.SupplyApr)
+	point.BorrowApy = AprToApy(point.BorrowApr)
+	return point
+}
@@ -0,0 +1,80 @@
+package core
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBankInterestAccruals(t *testing.T) {
+	log := zerolog.Nop()
+	bank := &Bank{
+		AssetShareValue:      ONE,
+		LiabilityShareValue:  ONE,
+		TotalAssetShares:     decimal.NewFromInt(100),
+		TotalLiabilityShares: decimal.Zero,
+		LiquidityVault:       decimal.NewFromInt(100),
+		BankConfig: BankConfig{InterestRateConfig: InterestRateConfig{
+			OptimalUtilizationRate: decimal.NewFromFloat(0.8),
+			PlateauInterestRate:    decimal.NewFromFloat(0.1),
+			MaxInterestRate:        ONE,
+		}},
+		LastUpdate: 0,
+	}
+
+	assert.NoError(t, bank.AccrueInterest(&log, 100))
+	bank.TotalLiabilityShares = decimal.NewFromInt(40)
+	bank.LiquidityVault = decimal.NewFromInt(60)
+	assert.NoError(t, bank.AccrueInterest(&log, 400))
+	assert.NoError(t, bank.AccrueInterest(&log, 400))
+
+	accruals := bank.InterestAccruals()
+	assert.Len(t, accruals, 2)
+	assert.True(t, accruals[0].LendingApr.IsZero())
+	assert.Equal(t, int64(100), accruals[1].StartedAt)
+	assert.Equal(t, int64(400), accruals[1].Timestamp)
+	assert.True(t, accruals[1].UtilizationRate.Equal(decimal.NewFromFloat(0.4)))
+	assert.True(t, accruals[1].BorrowingApr.Equal(decimal.NewFromFloat(0.05)))
+	assert.True(t, accruals[1].AssetShareValue.Equal(bank.AssetShareValue))
+
+	point := averageInterestRate(accruals, 0, 500)
+	assert.Equal(t, int64(400), point.Covered)
+	assert.True(t, point.BorrowApr.Equal(decimal.NewFromFloat(0.0375)))
+	assert.True(t, point.UtilizationRate.Equal(decimal.NewFromFloat(0.3)))
+
+	point = averageInterestRate(accruals, 200, 300)
+	assert.True(t, point.BorrowApr.Equal(decimal.NewFromFloat(0.05)))
+	assert.True(t, point.BorrowApy.GreaterThan(point.BorrowApr))
+}
+
+type accrualStore struct {
+	InterestAccrualStore
+	accruals []*InterestAccrual
+}
+
+func (s *accrualStore) CreateInterestAccruals(ctx context.Context, accruals []*InterestAccrual) error {
+	s.accruals = append(s.accruals, accruals...)
+	return nil
+}
+
+func TestBankInterestAccrualsPending(t *testing.T) {
+	log := zerolog.Nop()
+	// an unset curve is never evaluated while the bank has no liabilities
+	bank := &Bank{AssetShareValue: ONE, LiabilityShareValue: ONE, TotalAssetShares: decimal.NewFromInt(100), TotalLiabilityShares: decimal.Zero}
+
+	for ts := int64(1); ts <= MAX_PENDING_INTEREST_ACCRUALS+10; ts++ {
+		assert.NoError(t, bank.AccrueInterest(&log, ts))
+	}
+	accruals := bank.InterestAccruals()
+	assert.Len(t, accruals, MAX_PENDING_INTEREST_ACCRUALS)
+	assert.Equal(t, int64(MAX_PENDING_INTEREST_ACCRUALS+10), accruals[len(accruals)-1].Timestamp)
+	assert.True(t, accruals[0].UtilizationRate.IsZero())
+
+	store := &accrualStore{}
+	assert.NoError(t, RecordInterestAccruals(context.Background(), store, bank))
+	assert.Len(t, store.accruals, MAX_PENDING_INTEREST_ACCRUALS)
+	assert.Empty(t, bank.InterestAccruals())
+}
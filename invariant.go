@@ -0,0 +1,209 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/facebookgo/clock"
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+)
+
+type (
+	// UtxoBalanceService reads the app holdings from its unspent Mixin utxos
+	UtxoBalanceService interface {
+		GetUnspentAmount(ctx context.Context, assetId string) (decimal.Decimal, error)
+	}
+
+	InvariantViolation struct {
+		Invariant string          `json:"invariant"`
+		BankId    uuid.UUID       `json:"bankId,omitempty"`
+		AssetId   string          `json:"assetId"`
+		Expected  decimal.Decimal `json:"expected"`
+		Actual    decimal.Decimal `json:"actual"`
+		Drift     decimal.Decimal `json:"drift"`
+	}
+
+	InvariantReport struct {
+		CheckedAt  int64                `json:"checkedAt"`
+		Banks      int                  `json:"banks"`
+		Violations []InvariantViolation `json:"violations"`
+	}
+
+	// InvariantChecker reconciles the books of every bank: the share totals against the balances
+	// and the vaults against the utxo holdings of the asset
+	InvariantChecker struct {
+		clk          clock.Clock
+		log          Log
+		bankStore    BankStore
+		balanceStore BalanceStore
+		utxoService  UtxoBalanceService
+		tolerance    decimal.Decimal
+	}
+
+	InvariantCheckerOption func(c *InvariantChecker)
+)
+
+const (
+	InvariantTotalAssetShares     = "total asset shares"
+	InvariantTotalLiabilityShares = "total liability shares"
+	InvariantVaultHoldings        = "vault holdings"
+)
+
+// WithInvariantTolerance sets the drift that is still accepted, it defaults to EMPTY_BALANCE_THRESHOLD
+func WithInvariantTolerance(tolerance decimal.Decimal) InvariantCheckerOption {
+	return func(c *InvariantChecker) {
+		c.tolerance = tolerance
+	}
+}
+
+// NewInvariantChecker creates a checker, a nil utxoService skips the vault holdings invariant
+func NewInvariantChecker(clk clock.Clock, log Log, bankStore BankStore, balanceStore BalanceStore, utxoService UtxoBalanceService, opts ...InvariantCheckerOption) *InvariantChecker {
+	c := &InvariantChecker{
+		clk:          clk,
+		log:          log,
+		bankStore:    bankStore,
+		balanceStore: balanceStore,
+		utxoService:  utxoService,
+		tolerance:    EMPTY_BALANCE_THRESHOLD,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Check runs every invariant over all banks. Deleted banks are included, the utxos behind a vault
+// left in a deleted bank are still held by the app and count towards the holdings of the asset.
+func (c *InvariantChecker) Check(ctx context.Context) (*InvariantReport, error) {
+	banks, err := c.bankStore.ListBank(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &InvariantReport{
+		CheckedAt:  c.clk.Now().Unix(),
+		Banks:      len(banks),
+		Violations: []InvariantViolation{},
+	}
+
+	banksByAsset := map[string][]*Bank{}
+	for _, bank := range banks {
+		violations, err := c.CheckBankShares(ctx, bank)
+		if err != nil {
+			return nil, err
+		}
+		report.Violations = append(report.Violations, violations...)
+		banksByAsset[bank.MixinSafeAssetId] = append(banksByAsset[bank.MixinSafeAssetId], bank)
+	}
+
+	if c.utxoService != nil {
+		for assetId, assetBanks := range banksByAsset {
+			violation, err := c.CheckVaultHoldings(ctx, assetId, assetBanks)
+			if err != nil {
+				return nil, err
+			}
+			if violation != nil {
+				report.Violations = append(report.Violations, *violation)
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// CheckBankShares compares the share totals of the bank with the sum over its balances
+func (c *InvariantChecker) CheckBankShares(ctx context.Context, bank *Bank) ([]InvariantViolation, error) {
+	balances, err := c.balanceStore.ListBalances(ctx, uuid.Nil, bank.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	assetShares, liabilityShares := decimal.Zero, decimal.Zero
+	for _, balance := range balances {
+		assetShares = assetShares.Add(balance.AssetShares)
+		liabilityShares = liabilityShares.Add(balance.LiabilityShares)
+	}
+
+	violations := []InvariantViolation{}
+	if v := c.compare(InvariantTotalAssetShares, bank.Id, bank.MixinSafeAssetId, assetShares, bank.TotalAssetShares); v != nil {
+		violations = append(violations, *v)
+	}
+	if v := c.compare(InvariantTotalLiabilityShares, bank.Id, bank.MixinSafeAssetId, liabilityShares, bank.TotalLiabilityShares); v != nil {
+		violations = append(violations, *v)
+	}
+	return violations, nil
+}
+
+// CheckVaultHoldings compares the liquidity, fee and insurance vaults of every bank of the asset
+// with the unspent utxos of the asset
+func (c *InvariantChecker) CheckVaultHoldings(ctx context.Context, assetId string, banks []*Bank) (*InvariantViolation, error) {
+	vaults := decimal.Zero
+	for _, bank := range banks {
+		vaults = vaults.Add(bank.LiquidityVault).Add(bank.FeeVault).Add(bank.InsuranceVault)
+	}
+
+	holdings, err := c.utxoService.GetUnspentAmount(ctx, assetId)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.compare(InvariantVaultHoldings, uuid.Nil, assetId, vaults, holdings), nil
+}
+
+// Run checks the invariants every interval until ctx is done, violations are logged
+func (c *InvariantChecker) Run(ctx context.Context, interval time.Duration) error {
+	ticker := c.clk.Ticker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			report, err := c.Check(ctx)
+			if err != nil {
+				c.log.Error().Msgf("invariant check failed: %v", err)
+				continue
+			}
+			for _, v := range report.Violations {
+				c.log.Error().Msgf("invariant %s violated: bank %s asset %s expected %s actual %s drift %s", v.Invariant, v.BankId, v.AssetId, v.Expected, v.Actual, v.Drift)
+			}
+		}
+	}
+}
+
+func (c *InvariantChecker) compare(invariant string, bankId uuid.UUID, assetId string, expected, actual decimal.Decimal) *InvariantViolation {
+	drift := actual.Sub(expected)
+	if drift.Abs().LessThanOrEqual(c.tolerance) {
+		return nil
+	}
+	return &InvariantViolation{
+		Invariant: invariant,
+		BankId:    bankId,
+		AssetId:   assetId,
+		Expected:  expected,
+		Actual:    actual,
+		Drift:     drift,
+	}
+}
+
+func (r *InvariantReport) Ok() bool {
+	return len(r.Violations) == 0
+}
+
+// Err returns nil when every invariant holds, tests can assert on it after each operation
+func (r *InvariantReport) Err() error {
+	if r.Ok() {
+		return nil
+	}
+
+	details := make([]string, 0, len(r.Violations))
+	for _, v := range r.Violations {
+		details = append(details, fmt.Sprintf("%s of bank %s (%s): expected %s, actual %s", v.Invariant, v.BankId, v.AssetId, v.Expected, v.Actual))
+	}
+	return errors.Wrap(ErrInvariantViolated, strings.Join(details, "; "))
+}
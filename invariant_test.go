@@ -0,0 +1,89 @@
+package core
+
+import (
+	"context"
+	"testing"
+
+	"github.com/facebookgo/clock"
+	"github.com/gofrs/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+type invariantBankStore struct {
+	BankStore
+	banks []*Bank
+}
+
+func (s *invariantBankStore) ListBank(ctx context.Context) ([]*Bank, error) {
+	return s.banks, nil
+}
+
+type invariantBalanceStore struct {
+	BalanceStore
+	balances []*Balance
+}
+
+func (s *invariantBalanceStore) ListBalances(ctx context.Context, accountId, bankId uuid.UUID) ([]*Balance, error) {
+	balances := []*Balance{}
+	for _, balance := range s.balances {
+		if balance.BankId == bankId {
+			balances = append(balances, balance)
+		}
+	}
+	return balances, nil
+}
+
+type invariantUtxoService map[string]decimal.Decimal
+
+func (s invariantUtxoService) GetUnspentAmount(ctx context.Context, assetId string) (decimal.Decimal, error) {
+	return s[assetId], nil
+}
+
+func TestInvariantChecker(t *testing.T) {
+	bankId := uuid.Must(uuid.NewV4())
+	bank := &Bank{
+		Id:                   bankId,
+		MixinSafeAssetId:     "asset",
+		TotalAssetShares:     decimal.NewFromInt(150),
+		TotalLiabilityShares: decimal.NewFromInt(40),
+		LiquidityVault:       decimal.NewFromInt(110),
+		FeeVault:             decimal.NewFromInt(1),
+		InsuranceVault:       decimal.NewFromInt(2),
+	}
+	balanceStore := &invariantBalanceStore{balances: []*Balance{
+		{BankId: bankId, AssetShares: decimal.NewFromInt(100), LiabilityShares: decimal.Zero},
+		{BankId: bankId, AssetShares: decimal.NewFromInt(50), LiabilityShares: decimal.Zero},
+		{BankId: bankId, AssetShares: decimal.Zero, LiabilityShares: decimal.NewFromInt(40)},
+	}}
+	utxos := invariantUtxoService{"asset": decimal.NewFromInt(113)}
+	checker := NewInvariantChecker(clock.NewMock(), nil, &invariantBankStore{banks: []*Bank{bank}}, balanceStore, utxos)
+
+	report, err := checker.Check(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, report.Err())
+
+	bank.TotalAssetShares = decimal.NewFromInt(151)
+	utxos["asset"] = decimal.NewFromInt(112)
+
+	report, err = checker.Check(context.Background())
+	assert.NoError(t, err)
+	assert.ErrorIs(t, report.Err(), ErrInvariantViolated)
+	assert.Len(t, report.Violations, 2)
+	assert.Equal(t, InvariantTotalAssetShares, report.Violations[0].Invariant)
+	assert.True(t, report.Violations[0].Drift.Equal(decimal.NewFromInt(1)))
+	assert.Equal(t, InvariantVaultHoldings, report.Violations[1].Invariant)
+	assert.True(t, report.Violations[1].Drift.Equal(decimal.NewFromInt(-1)))
+}
+
+func TestInvariantCheckerIncludesDeletedBanks(t *testing.T) {
+	live := &Bank{Id: uuid.Must(uuid.NewV4()), MixinSafeAssetId: "asset", LiquidityVault: decimal.NewFromInt(100)}
+	deleted := &Bank{Id: uuid.Must(uuid.NewV4()), MixinSafeAssetId: "asset", LiquidityVault: decimal.NewFromInt(5), DeletedAt: 1}
+	utxos := invariantUtxoService{"asset": decimal.NewFromInt(105)}
+	checker := NewInvariantChecker(clock.NewMock(), nil, &invariantBankStore{banks: []*Bank{live, deleted}}, &invariantBalanceStore{}, utxos)
+
+	report, err := checker.Check(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, report.Err())
+	assert.Equal(t, 2, report.Banks)
+}
@@ -0,0 +1,634 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/DomeLiquid/core/utils"
+	"github.com/facebookgo/clock"
+	"github.com/gofrs/uuid"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+type (
+	// KeeperInventory is the amount of every asset the keeper holds to repay liquidations
+	KeeperInventory struct {
+		mu       sync.Mutex
+		balances map[string]decimal.Decimal
+	}
+
+	KeeperExecution struct {
+		RequestId           string          `json:"requestId"`
+		LiquidateeAccountId uuid.UUID       `json:"liquidateeAccountId"`
+		AssetBankId         uuid.UUID       `json:"assetBankId"`
+		LiabilityBankId     uuid.UUID       `json:"liabilityBankId"`
+		LiabilityAssetId    string          `json:"liabilityAssetId"`
+		LiabilityAmount     decimal.Decimal `json:"liabilityAmount"`
+		LiabilityUsdValue   decimal.Decimal `json:"liabilityUsdValue"`
+		ExpectedAssetAmount decimal.Decimal `json:"expectedAssetAmount"`
+		// ExpectedRepaidAmount is the liability of the liquidatee the liquidation repays, net of the
+		// insurance fee
+		ExpectedRepaidAmount decimal.Decimal `json:"expectedRepaidAmount"`
+		ExpectedProfit       decimal.Decimal `json:"expectedProfit"`
+		Memo                 string          `json:"memo"`
+		DryRun               bool            `json:"dryRun"`
+		ExecutedAt           int64           `json:"executedAt"`
+	}
+
+	// Keeper liquidates the candidates of a group with its own liquidator account. It pays the
+	// liability asset to the app with a liquidate memo, the same path as any liquidator.
+	Keeper struct {
+		clk clock.Clock
+		log Log
+		mu  sync.Mutex
+
+		groupId      uuid.UUID
+		appId        string
+		userId       string
+		accountIndex uint8
+
+		maxExposure     decimal.Decimal
+		maxSlippage     decimal.Decimal
+		minProfit       decimal.Decimal
+		inFlightTimeout int64
+		dryRun          bool
+
+		bankAccountService BankAccountService
+		priceFeedMgr       PriceAdapterMgr
+		scanner            *LiquidationScanner
+		inventory          *KeeperInventory
+		utxoService        UtxoBalanceService
+		transferService    TransferService
+		simulation         *keeperSimulation
+
+		// inFlight holds the liquidations sent for a candidate state that the scan still reports, by
+		// state id. The keeper does not pay a state again until the liquidation settles and the
+		// state changes, or until it expires and is retried under a new request id.
+		inFlight map[string]*keeperInFlight
+	}
+
+	keeperInFlight struct {
+		requestId         string
+		attempt           int
+		sentAt            int64
+		liabilityUsdValue decimal.Decimal
+	}
+
+	// keeperCandidate is a scanned candidate with its banks and the id of its current state
+	keeperCandidate struct {
+		*LiquidationCandidate
+		assetBank     *Bank
+		liabilityBank *Bank
+		stateId       string
+	}
+
+	// keeperSimulation overlays the balances changed by dry run liquidations on the balance store,
+	// nothing is written to the store
+	keeperSimulation struct {
+		BalanceStore
+		mu       sync.Mutex
+		balances map[keeperBalanceKey]*Balance
+	}
+
+	keeperBalanceKey struct {
+		accountId uuid.UUID
+		bankId    uuid.UUID
+	}
+
+	KeeperOptionFunc func(k *Keeper)
+)
+
+func NewKeeperInventory(balances map[string]decimal.Decimal) *KeeperInventory {
+	inventory := &KeeperInventory{balances: map[string]decimal.Decimal{}}
+	for assetId, amount := range balances {
+		inventory.balances[assetId] = amount
+	}
+	return inventory
+}
+
+func (i *KeeperInventory) Balance(assetId string) decimal.Decimal {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.balances[assetId]
+}
+
+func (i *KeeperInventory) Credit(assetId string, amount decimal.Decimal) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.balances[assetId] = i.balances[assetId].Add(amount)
+}
+
+func (i *KeeperInventory) Debit(assetId string, amount decimal.Decimal) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.balances[assetId].LessThan(amount) {
+		return ErrKeeperInventoryInsufficient
+	}
+	i.balances[assetId] = i.balances[assetId].Sub(amount)
+	return nil
+}
+
+// Sync replaces the balances of assetIds with the unspent utxos of the keeper wallet
+func (i *KeeperInventory) Sync(ctx context.Context, utxoService UtxoBalanceService, assetIds ...string) error {
+	for _, assetId := range assetIds {
+		amount, err := utxoService.GetUnspentAmount(ctx, assetId)
+		if err != nil {
+			return err
+		}
+		i.mu.Lock()
+		i.balances[assetId] = amount
+		i.mu.Unlock()
+	}
+	return nil
+}
+
+// WithKeeperMaxExposure caps the USD value the keeper has open: the liquidations sent and not
+// settled yet plus the collateral its liquidator account holds
+func WithKeeperMaxExposure(maxExposure decimal.Decimal) KeeperOptionFunc {
+	return func(k *Keeper) {
+		k.maxExposure = maxExposure
+	}
+}
+
+// WithKeeperMaxSlippage skips a liquidation when the live prices moved the exchange rate of the
+// suggestion by more than maxSlippage since the scan
+func WithKeeperMaxSlippage(maxSlippage decimal.Decimal) KeeperOptionFunc {
+	return func(k *Keeper) {
+		k.maxSlippage = maxSlippage
+	}
+}
+
+func WithKeeperMinProfit(minProfit decimal.Decimal) KeeperOptionFunc {
+	return func(k *Keeper) {
+		k.minProfit = minProfit
+	}
+}
+
+// WithKeeperInFlightTimeout is the number of seconds a sent liquidation blocks its candidate state,
+// after that it is taken as failed or refunded and retried under a new request id
+func WithKeeperInFlightTimeout(timeout int64) KeeperOptionFunc {
+	return func(k *Keeper) {
+		k.inFlightTimeout = timeout
+	}
+}
+
+// WithKeeperDryRun records the executions without any transfer. The inventory is debited and the
+// liquidations are applied to in-memory balances, so later candidates and runs see them settled.
+func WithKeeperDryRun() KeeperOptionFunc {
+	return func(k *Keeper) {
+		k.dryRun = true
+	}
+}
+
+// WithKeeperUtxoService syncs the inventory from the keeper wallet before every run
+func WithKeeperUtxoService(utxoService UtxoBalanceService) KeeperOptionFunc {
+	return func(k *Keeper) {
+		k.utxoService = utxoService
+	}
+}
+
+// WithKeeperCircuitBreaker feeds the prices of every run to the circuit breaker of the group
+func WithKeeperCircuitBreaker(circuitBreaker *CircuitBreaker) KeeperOptionFunc {
+	return func(k *Keeper) {
+		k.scanner.circuitBreaker = circuitBreaker
+	}
+}
+
+// NewKeeper creates the keeper of groupId, appId receives the liquidate transfers and userId with
+// accountIndex is the liquidator account of the keeper. transferService may be nil in dry run.
+func NewKeeper(
+	clk clock.Clock,
+	log Log,
+	groupId uuid.UUID,
+	appId string,
+	userId string,
+	accountIndex uint8,
+	bankAccountService BankAccountService,
+	priceFeedMgr PriceAdapterMgr,
+	inventory *KeeperInventory,
+	transferService TransferService,
+	opts ...KeeperOptionFunc,
+) *Keeper {
+	k := &Keeper{
+		clk:                clk,
+		log:                log,
+		groupId:            groupId,
+		appId:              appId,
+		userId:             userId,
+		accountIndex:       accountIndex,
+		maxExposure:        decimal.Zero,
+		maxSlippage:        DEFAULT_KEEPER_MAX_SLIPPAGE,
+		minProfit:          decimal.Zero,
+		inFlightTimeout:    DEFAULT_KEEPER_IN_FLIGHT_TIMEOUT,
+		bankAccountService: bankAccountService,
+		priceFeedMgr:       priceFeedMgr,
+		scanner:            NewLiquidationScanner(clk, log, bankAccountService, priceFeedMgr),
+		inventory:          inventory,
+		transferService:    transferService,
+		inFlight:           map[string]*keeperInFlight{},
+	}
+	for _, opt := range opts {
+		opt(k)
+	}
+	if k.dryRun {
+		k.simulation = &keeperSimulation{BalanceStore: bankAccountService.BalanceStore, balances: map[keeperBalanceKey]*Balance{}}
+		k.bankAccountService.BalanceStore = k.simulation
+		k.scanner.bankAccountService = k.bankAccountService
+	}
+	return k
+}
+
+// RunOnce scans the group and liquidates the candidates in order until the inventory or the
+// exposure runs out, a zero max exposure means no cap
+func (k *Keeper) RunOnce(ctx context.Context) ([]*KeeperExecution, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	group, err := k.bankAccountService.GetGroup(ctx, k.groupId)
+	if err != nil {
+		return nil, err
+	}
+	if group.GetConfig().Paused {
+		return nil, nil
+	}
+	banks, err := k.bankAccountService.ListBankByGroupId(ctx, k.groupId)
+	if err != nil {
+		return nil, err
+	}
+	now := k.clk.Now().Unix()
+	banks, err = AccruedClones(k.log, now, ExcludeDeletedBanks(banks))
+	if err != nil {
+		return nil, err
+	}
+	banksById := make(map[uuid.UUID]*Bank, len(banks))
+	assetIds := make([]string, 0, len(banks))
+	for _, bank := range banks {
+		banksById[bank.Id] = bank
+		assetIds = append(assetIds, bank.MixinSafeAssetId)
+	}
+	if k.utxoService != nil && !k.dryRun {
+		if err := k.inventory.Sync(ctx, k.utxoService, assetIds...); err != nil {
+			return nil, err
+		}
+	}
+
+	candidates, err := k.scanner.Scan(ctx, k.groupId)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := make([]*keeperCandidate, 0, len(candidates))
+	scanned := map[string]struct{}{}
+	for _, candidate := range candidates {
+		if candidate.Suggestion == nil || candidate.PubKey == k.userId {
+			continue
+		}
+		assetBank, liabilityBank := banksById[candidate.Suggestion.AssetBankId], banksById[candidate.Suggestion.LiabilityBankId]
+		if assetBank == nil || liabilityBank == nil {
+			continue
+		}
+
+		stateId, err := k.stateId(ctx, candidate, assetBank, liabilityBank)
+		if err != nil {
+			k.log.Warn().Msgf("keeper skips account %s: %v", candidate.AccountId, err)
+			continue
+		}
+		scanned[stateId] = struct{}{}
+		pending = append(pending, &keeperCandidate{LiquidationCandidate: candidate, assetBank: assetBank, liabilityBank: liabilityBank, stateId: stateId})
+	}
+	// a state the scan no longer reports has settled, its collateral is held by the liquidator account
+	for stateId := range k.inFlight {
+		if _, ok := scanned[stateId]; !ok {
+			delete(k.inFlight, stateId)
+		}
+	}
+
+	exposure, err := k.openExposure(ctx, banksById, now)
+	if err != nil {
+		return nil, err
+	}
+	executions := []*KeeperExecution{}
+	for _, candidate := range pending {
+		attempt := 0
+		if entry, ok := k.inFlight[candidate.stateId]; ok {
+			if now < entry.sentAt+k.inFlightTimeout {
+				continue
+			}
+			attempt = entry.attempt + 1
+			k.log.Warn().Msgf("keeper liquidation %s of account %s did not settle, retrying", entry.requestId, candidate.AccountId)
+		}
+
+		requestId := keeperRequestId(candidate.stateId, attempt)
+		execution, err := k.plan(requestId, candidate.LiquidationCandidate, candidate.assetBank, candidate.liabilityBank, group.GetConfig(), exposure)
+		if err != nil {
+			k.log.Warn().Msgf("keeper skips account %s: %v", candidate.AccountId, err)
+			continue
+		}
+		if execution == nil {
+			continue
+		}
+		if err := k.execute(ctx, execution, candidate.assetBank, candidate.liabilityBank); err != nil {
+			k.log.Error().Msgf("keeper liquidation of account %s failed: %v", candidate.AccountId, err)
+			continue
+		}
+		k.inFlight[candidate.stateId] = &keeperInFlight{
+			requestId:         requestId,
+			attempt:           attempt,
+			sentAt:            now,
+			liabilityUsdValue: execution.LiabilityUsdValue,
+		}
+		exposure = exposure.Add(execution.LiabilityUsdValue)
+		executions = append(executions, execution)
+	}
+	return executions, nil
+}
+
+// stateId is derived from the shares the liquidatee holds in both banks, the same candidate state
+// gives the same id across runs and a settled liquidation gives a new one
+func (k *Keeper) stateId(ctx context.Context, candidate *LiquidationCandidate, assetBank, liabilityBank *Bank) (string, error) {
+	balances, err := k.bankAccountService.ListBalances(ctx, candidate.AccountId, uuid.Nil)
+	if err != nil {
+		return "", err
+	}
+	assetShares, liabilityShares := decimal.Zero, decimal.Zero
+	for _, balance := range balances {
+		switch balance.BankId {
+		case assetBank.Id:
+			assetShares = balance.AssetShares
+		case liabilityBank.Id:
+			liabilityShares = balance.LiabilityShares
+		}
+	}
+	return utils.GenUuidFromStrings(k.userId, candidate.AccountId.String(), assetBank.Id.String(), liabilityBank.Id.String(), assetShares.String(), liabilityShares.String()), nil
+}
+
+// keeperRequestId is the transfer id of an attempt on a candidate state, a retry needs a new id as
+// the transfer of a failed attempt is already recorded under the previous one
+func keeperRequestId(stateId string, attempt int) string {
+	if attempt == 0 {
+		return stateId
+	}
+	return utils.GenUuidFromStrings(stateId, "attempt:"+strconv.Itoa(attempt))
+}
+
+// openExposure is the USD value of the liquidations sent and neither settled nor expired, plus the
+// collateral the liquidator account holds. It is zero without a max exposure.
+func (k *Keeper) openExposure(ctx context.Context, banksById map[uuid.UUID]*Bank, now int64) (decimal.Decimal, error) {
+	exposure := decimal.Zero
+	if !k.maxExposure.IsPositive() {
+		return exposure, nil
+	}
+	for _, entry := range k.inFlight {
+		if now < entry.sentAt+k.inFlightTimeout {
+			exposure = exposure.Add(entry.liabilityUsdValue)
+		}
+	}
+
+	accountId, err := k.liquidatorAccountId(ctx)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	balances, err := k.bankAccountService.ListBalances(ctx, accountId, uuid.Nil)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	for _, balance := range balances {
+		bank := banksById[balance.BankId]
+		if !balance.Active || bank == nil || !balance.AssetShares.IsPositive() {
+			continue
+		}
+		price, err := getRealTimePrice(k.priceFeedMgr, bank)
+		if err != nil {
+			return decimal.Zero, err
+		}
+		exposure = exposure.Add(bank.ComputeAssetUsdValue(price, balance.AssetShares, Equity, Original))
+	}
+	return exposure, nil
+}
+
+// liquidatorAccountId returns the id of the liquidator account, or the id it is created with by
+// the first liquidation
+func (k *Keeper) liquidatorAccountId(ctx context.Context) (uuid.UUID, error) {
+	account, err := k.bankAccountService.GetAccountByPubkey(ctx, k.groupId, k.userId, k.accountIndex)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return NewAccountId(k.groupId, k.userId, k.accountIndex, 0), nil
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return account.Id, nil
+}
+
+// plan sizes the liquidation of the candidate by the inventory and the remaining exposure, and
+// checks the live prices against the scanned suggestion
+func (k *Keeper) plan(requestId string, candidate *LiquidationCandidate, assetBank, liabilityBank *Bank, config GroupConfig, exposure decimal.Decimal) (*KeeperExecution, error) {
+	suggestion := candidate.Suggestion
+	if !suggestion.AssetAmount.IsPositive() || !suggestion.LiabilityAmount.IsPositive() {
+		return nil, nil
+	}
+
+	assetPrice, err := getRealTimePrice(k.priceFeedMgr, assetBank)
+	if err != nil {
+		return nil, err
+	}
+	liabilityPrice, err := getRealTimePrice(k.priceFeedMgr, liabilityBank)
+	if err != nil {
+		return nil, err
+	}
+	if !assetPrice.IsPositive() || !liabilityPrice.IsPositive() {
+		return nil, nil
+	}
+
+	// liability paid per unit of asset received, at the scanned and at the live prices
+	scannedRate := suggestion.LiabilityAmount.Div(suggestion.AssetAmount)
+	liveRate := assetPrice.Mul(ONE.Sub(*config.LiquidationLiquidatorFee)).Div(liabilityPrice)
+	if liveRate.Div(scannedRate).Sub(ONE).Abs().GreaterThan(k.maxSlippage) {
+		return nil, ErrSwapSlippageExceeded
+	}
+
+	liabilityAmount := decimal.Min(suggestion.LiabilityAmount, k.inventory.Balance(liabilityBank.MixinSafeAssetId))
+	if k.maxExposure.IsPositive() {
+		remaining := k.maxExposure.Sub(exposure)
+		if !remaining.IsPositive() {
+			return nil, nil
+		}
+		liabilityAmount = decimal.Min(liabilityAmount, remaining.Div(liabilityPrice))
+	}
+	if !liabilityAmount.IsPositive() {
+		return nil, nil
+	}
+
+	assetAmount := liabilityAmount.Div(liveRate)
+	profit := assetAmount.Mul(assetPrice).Mul(*config.LiquidationLiquidatorFee)
+	repaidAmount := assetAmount.Mul(assetPrice).Mul(ONE.Sub(*config.LiquidationLiquidatorFee).Sub(*config.LiquidationInsuranceFee)).Div(liabilityPrice)
+	if profit.LessThan(k.minProfit) {
+		return nil, nil
+	}
+
+	memo, err := EncodeAnyMemo(candidate.MemoAction(k.accountIndex))
+	if err != nil {
+		return nil, err
+	}
+	now := k.clk.Now().Unix()
+	return &KeeperExecution{
+		RequestId:            requestId,
+		LiquidateeAccountId:  candidate.AccountId,
+		AssetBankId:          assetBank.Id,
+		LiabilityBankId:      liabilityBank.Id,
+		LiabilityAssetId:     liabilityBank.MixinSafeAssetId,
+		LiabilityAmount:      liabilityAmount,
+		LiabilityUsdValue:    liabilityAmount.Mul(liabilityPrice),
+		ExpectedAssetAmount:  assetAmount,
+		ExpectedRepaidAmount: repaidAmount,
+		ExpectedProfit:       profit,
+		Memo:                 memo,
+		DryRun:               k.dryRun,
+		ExecutedAt:           now,
+	}, nil
+}
+
+// execute pays the liability of the execution. The collateral lands in the liquidator account and
+// not in the keeper wallet, so the inventory only sees it once the keeper withdraws it.
+func (k *Keeper) execute(ctx context.Context, execution *KeeperExecution, assetBank, liabilityBank *Bank) error {
+	if err := k.inventory.Debit(execution.LiabilityAssetId, execution.LiabilityAmount); err != nil {
+		return err
+	}
+
+	if k.dryRun {
+		liquidatorId, err := k.liquidatorAccountId(ctx)
+		if err == nil {
+			err = k.simulation.apply(ctx, k.clk, execution, liquidatorId, assetBank, liabilityBank)
+		}
+		if err != nil {
+			k.inventory.Credit(execution.LiabilityAssetId, execution.LiabilityAmount)
+			return err
+		}
+		k.log.Info().Msgf("keeper dry run: liquidate account %s paying %s %s", execution.LiquidateeAccountId, execution.LiabilityAmount, execution.LiabilityAssetId)
+		return nil
+	}
+
+	if err := k.transferService.Transfer(ctx, execution.RequestId, k.appId, execution.LiabilityAssetId, execution.LiabilityAmount, execution.Memo); err != nil {
+		k.inventory.Credit(execution.LiabilityAssetId, execution.LiabilityAmount)
+		return err
+	}
+	return nil
+}
+
+// Run liquidates every interval until ctx is done
+func (k *Keeper) Run(ctx context.Context, interval time.Duration) error {
+	ticker := k.clk.Ticker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			executions, err := k.RunOnce(ctx)
+			if err != nil {
+				k.log.Error().Msgf("keeper run failed: %v", err)
+				continue
+			}
+			for _, execution := range executions {
+				k.log.Info().Msgf("keeper liquidated account %s, request %s, expected profit %s", execution.LiquidateeAccountId, execution.RequestId, execution.ExpectedProfit)
+			}
+		}
+	}
+}
+
+func (s *keeperSimulation) FindBalance(ctx context.Context, bankId, accountId uuid.UUID) (*Balance, error) {
+	s.mu.Lock()
+	balance, ok := s.balances[keeperBalanceKey{accountId: accountId, bankId: bankId}]
+	s.mu.Unlock()
+	if ok {
+		return balance.Clone(), nil
+	}
+	return s.BalanceStore.FindBalance(ctx, bankId, accountId)
+}
+
+func (s *keeperSimulation) UpsertBalance(ctx context.Context, balance *Balance) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.balances[keeperBalanceKey{accountId: balance.AccountId, bankId: balance.BankId}] = balance.Clone()
+	return nil
+}
+
+// ListBalances returns the stored balances with the simulated ones in their place
+func (s *keeperSimulation) ListBalances(ctx context.Context, accountId, bankId uuid.UUID) ([]*Balance, error) {
+	stored, err := s.BalanceStore.ListBalances(ctx, accountId, bankId)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	balances := make([]*Balance, 0, len(stored))
+	seen := map[keeperBalanceKey]bool{}
+	for _, balance := range stored {
+		key := keeperBalanceKey{accountId: balance.AccountId, bankId: balance.BankId}
+		seen[key] = true
+		if simulated, ok := s.balances[key]; ok {
+			balance = simulated.Clone()
+		}
+		balances = append(balances, balance)
+	}
+	for key, balance := range s.balances {
+		if seen[key] || (accountId != uuid.Nil && key.accountId != accountId) || (bankId != uuid.Nil && key.bankId != bankId) {
+			continue
+		}
+		balances = append(balances, balance.Clone())
+	}
+	return balances, nil
+}
+
+// apply books the execution as settled: the liquidatee gives up the asset and the repaid liability,
+// the liquidator account receives the asset
+func (s *keeperSimulation) apply(ctx context.Context, clk clock.Clock, execution *KeeperExecution, liquidatorId uuid.UUID, assetBank, liabilityBank *Bank) error {
+	assetShares, err := assetBank.GetAssetShares(execution.ExpectedAssetAmount)
+	if err != nil {
+		return err
+	}
+	liabilityShares, err := liabilityBank.GetLiabilityShares(execution.ExpectedRepaidAmount)
+	if err != nil {
+		return err
+	}
+
+	liquidateeAsset, err := s.balance(ctx, clk, execution.LiquidateeAccountId, assetBank.Id)
+	if err != nil {
+		return err
+	}
+	liquidateeAsset.AssetShares = decimal.Max(liquidateeAsset.AssetShares.Sub(assetShares), decimal.Zero)
+	liquidateeLiability, err := s.balance(ctx, clk, execution.LiquidateeAccountId, liabilityBank.Id)
+	if err != nil {
+		return err
+	}
+	liquidateeLiability.LiabilityShares = decimal.Max(liquidateeLiability.LiabilityShares.Sub(liabilityShares), decimal.Zero)
+	liquidatorAsset, err := s.balance(ctx, clk, liquidatorId, assetBank.Id)
+	if err != nil {
+		return err
+	}
+	liquidatorAsset.AssetShares = liquidatorAsset.AssetShares.Add(assetShares)
+
+	for _, balance := range []*Balance{liquidateeAsset, liquidateeLiability, liquidatorAsset} {
+		if err := s.UpsertBalance(ctx, balance); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// balance returns a copy of the current balance of the account in the bank, or a new one
+func (s *keeperSimulation) balance(ctx context.Context, clk clock.Clock, accountId, bankId uuid.UUID) (*Balance, error) {
+	balances, err := s.ListBalances(ctx, accountId, bankId)
+	if err != nil {
+		return nil, err
+	}
+	if len(balances) == 0 {
+		return NewBalance(clk, accountId, bankId), nil
+	}
+	return balances[0].Clone(), nil
+}
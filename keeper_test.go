@@ -0,0 +1,182 @@
+package core
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/clock"
+	"github.com/gofrs/uuid"
+	"github.com/rs/zerolog"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func (s *scannerStore) GetAccountByPubkey(ctx context.Context, groupId uuid.UUID, pubkey string, index uint8) (*Account, error) {
+	for _, account := range s.accounts {
+		if account.GroupId == groupId && account.PubKey == pubkey && account.Index == index {
+			return account, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+type keeperTransfers struct {
+	requestIds []string
+}
+
+func (t *keeperTransfers) Transfer(ctx context.Context, requestId string, opponentId string, assetId string, amount decimal.Decimal, memo string) error {
+	t.requestIds = append(t.requestIds, requestId)
+	return nil
+}
+
+func TestKeeperDryRun(t *testing.T) {
+	btc, usdt := newBank("btc"), newBank("usdt")
+	liquidatee := uuid.Must(uuid.NewV4())
+	store := &scannerStore{
+		group:    &Group{},
+		accounts: map[uuid.UUID]*Account{liquidatee: {Id: liquidatee, PubKey: "user"}},
+	}
+	store.banks = []*Bank{btc, usdt}
+	store.balances = []*Balance{
+		{AccountId: liquidatee, BankId: btc.Id, Active: true, AssetShares: ONE, LiabilityShares: decimal.Zero},
+		{AccountId: liquidatee, BankId: usdt.Id, Active: true, AssetShares: decimal.Zero, LiabilityShares: decimal.NewFromInt(70)},
+	}
+	prices := ratesPriceFeedMgr{"btc": decimal.NewFromInt(80), "usdt": ONE}
+	svc := BankAccountService{BalanceStore: store, BankStore: store, AccountStore: store, GroupStore: store}
+	log := zerolog.Nop()
+
+	inventory := NewKeeperInventory(map[string]decimal.Decimal{"usdt": decimal.NewFromInt(100)})
+	keeper := NewKeeper(clock.NewMock(), &log, uuid.Nil, "app", "keeper", 0, svc, prices, inventory, nil,
+		WithKeeperDryRun(), WithKeeperMaxExposure(decimal.NewFromInt(10)))
+
+	executions, err := keeper.RunOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, executions, 1)
+
+	execution := executions[0]
+	assert.True(t, execution.DryRun)
+	assert.True(t, execution.LiabilityAmount.Equal(decimal.NewFromInt(10)))
+	assert.True(t, inventory.Balance("usdt").Equal(decimal.NewFromInt(90)))
+	// the collateral goes to the liquidator account, not to the wallet
+	assert.True(t, inventory.Balance("btc").IsZero())
+
+	bytes, err := base64.StdEncoding.DecodeString(execution.Memo)
+	assert.NoError(t, err)
+	var memo MemoActionLiquidate
+	assert.NoError(t, json.Unmarshal(bytes, &memo))
+	assert.True(t, memo.Valid())
+	assert.Equal(t, liquidatee, memo.LiquidateeAccountId)
+	assert.Equal(t, usdt.Id, memo.LiabilityBankId)
+
+	// the liquidation is applied to the simulated balances only
+	assert.True(t, store.balances[1].LiabilityShares.Equal(decimal.NewFromInt(70)))
+	balances, err := keeper.bankAccountService.ListBalances(context.Background(), liquidatee, usdt.Id)
+	assert.NoError(t, err)
+	assert.True(t, balances[0].LiabilityShares.Equal(decimal.NewFromInt(70).Sub(execution.ExpectedRepaidAmount)))
+	balances, err = keeper.bankAccountService.ListBalances(context.Background(), NewAccountId(uuid.Nil, "keeper", 0, 0), uuid.Nil)
+	assert.NoError(t, err)
+	assert.Len(t, balances, 1)
+	assert.True(t, balances[0].AssetShares.Equal(execution.ExpectedAssetAmount))
+
+	// the collateral the liquidator account holds uses up the exposure
+	executions, err = keeper.RunOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, executions)
+
+	prices["btc"] = decimal.NewFromInt(79)
+	keeper = NewKeeper(clock.NewMock(), &log, uuid.Nil, "app", "keeper", 0, svc, prices, inventory, nil, WithKeeperDryRun())
+	keeper.scanner.priceFeedMgr = ratesPriceFeedMgr{"btc": decimal.NewFromInt(80), "usdt": ONE}
+	executions, err = keeper.RunOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, executions)
+}
+
+func TestKeeperInFlight(t *testing.T) {
+	btc, usdt := newBank("btc"), newBank("usdt")
+	liquidatee := uuid.Must(uuid.NewV4())
+	store := &scannerStore{
+		group:    &Group{},
+		accounts: map[uuid.UUID]*Account{liquidatee: {Id: liquidatee, PubKey: "user"}},
+	}
+	store.banks = []*Bank{btc, usdt}
+	liability := &Balance{AccountId: liquidatee, BankId: usdt.Id, Active: true, AssetShares: decimal.Zero, LiabilityShares: decimal.NewFromInt(70)}
+	store.balances = []*Balance{
+		{AccountId: liquidatee, BankId: btc.Id, Active: true, AssetShares: ONE, LiabilityShares: decimal.Zero},
+		liability,
+	}
+	prices := ratesPriceFeedMgr{"btc": decimal.NewFromInt(80), "usdt": ONE}
+	svc := BankAccountService{BalanceStore: store, BankStore: store, AccountStore: store, GroupStore: store}
+	log := zerolog.Nop()
+	clk := clock.NewMock()
+	transfers := &keeperTransfers{}
+
+	inventory := NewKeeperInventory(map[string]decimal.Decimal{"usdt": decimal.NewFromInt(100)})
+	keeper := NewKeeper(clk, &log, uuid.Nil, "app", "keeper", 0, svc, prices, inventory, transfers,
+		WithKeeperMaxExposure(decimal.NewFromInt(10)))
+
+	executions, err := keeper.RunOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, executions, 1)
+
+	// the liquidation has not settled yet, the next run does not pay it again
+	clk.Add(time.Minute)
+	executions, err = keeper.RunOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, executions)
+	assert.Len(t, transfers.requestIds, 1)
+
+	// it did not settle in time, the same state is retried under a new request id
+	clk.Add(DEFAULT_KEEPER_IN_FLIGHT_TIMEOUT * time.Second)
+	executions, err = keeper.RunOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, executions, 1)
+	assert.Len(t, transfers.requestIds, 2)
+	assert.NotEqual(t, transfers.requestIds[0], transfers.requestIds[1])
+
+	// once it settled the state changes and the candidate gets a new request id
+	liability.LiabilityShares = decimal.NewFromInt(68)
+	executions, err = keeper.RunOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, executions, 1)
+	assert.Len(t, transfers.requestIds, 3)
+	assert.NotContains(t, transfers.requestIds[:2], transfers.requestIds[2])
+}
+
+func TestKeeperMaxExposure(t *testing.T) {
+	btc, usdt := newBank("btc"), newBank("usdt")
+	liquidatee := uuid.Must(uuid.NewV4())
+	store := &scannerStore{
+		group:    &Group{},
+		accounts: map[uuid.UUID]*Account{liquidatee: {Id: liquidatee, PubKey: "user"}},
+	}
+	store.banks = []*Bank{btc, usdt}
+	liability := &Balance{AccountId: liquidatee, BankId: usdt.Id, Active: true, AssetShares: decimal.Zero, LiabilityShares: decimal.NewFromInt(70)}
+	store.balances = []*Balance{
+		{AccountId: liquidatee, BankId: btc.Id, Active: true, AssetShares: ONE, LiabilityShares: decimal.Zero},
+		liability,
+	}
+	prices := ratesPriceFeedMgr{"btc": decimal.NewFromInt(80), "usdt": ONE}
+	svc := BankAccountService{BalanceStore: store, BankStore: store, AccountStore: store, GroupStore: store}
+	log := zerolog.Nop()
+	transfers := &keeperTransfers{}
+
+	inventory := NewKeeperInventory(map[string]decimal.Decimal{"usdt": decimal.NewFromInt(100)})
+	keeper := NewKeeper(clock.NewMock(), &log, uuid.Nil, "app", "keeper", 0, svc, prices, inventory, transfers,
+		WithKeeperMaxExposure(decimal.NewFromInt(10)))
+
+	executions, err := keeper.RunOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, executions, 1)
+
+	// the liquidation settled and the liquidator account holds 8 USD of its collateral
+	liability.LiabilityShares = decimal.NewFromInt(68)
+	store.balances = append(store.balances, &Balance{AccountId: NewAccountId(uuid.Nil, "keeper", 0, 0), BankId: btc.Id, Active: true, AssetShares: decimal.NewFromFloat(0.1), LiabilityShares: decimal.Zero})
+	executions, err = keeper.RunOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, executions, 1)
+	assert.True(t, executions[0].LiabilityAmount.Equal(decimal.NewFromInt(2)))
+}
@@ -0,0 +1,239 @@
+package core
+
+import (
+	"context"
+
+	"github.com/facebookgo/clock"
+	"github.com/gofrs/uuid"
+	"github.com/shopspring/decimal"
+)
+
+type (
+	LedgerStore interface {
+		CreateJournal(ctx context.Context, journal *Journal) error
+		ListJournalEntriesByBank(ctx context.Context, bankId uuid.UUID, createdBeforeAt, limit int64) ([]*JournalEntry, error)
+		ListJournalEntriesByAccount(ctx context.Context, accountId uuid.UUID, createdBeforeAt, limit int64) ([]*JournalEntry, error)
+	}
+
+	// Journal is one balanced movement of a bank asset, its debits always equal its credits
+	Journal struct {
+		Id        uuid.UUID      `json:"id"`
+		RequestId string         `json:"requestId"`
+		BankId    uuid.UUID      `json:"bankId"`
+		Kind      LedgerKind     `json:"kind"`
+		Entries   []JournalEntry `json:"entries"`
+		CreatedAt int64          `json:"createdAt"`
+	}
+
+	JournalEntry struct {
+		JournalId uuid.UUID         `json:"journalId"`
+		RequestId string            `json:"requestId"`
+		BankId    uuid.UUID         `json:"bankId"`
+		AssetId   string            `json:"assetId"`
+		Account   LedgerAccountType `json:"account"`
+		AccountId uuid.UUID         `json:"accountId,omitempty"`
+		Debit     decimal.Decimal   `json:"debit"`
+		Credit    decimal.Decimal   `json:"credit"`
+		CreatedAt int64             `json:"createdAt"`
+	}
+
+	LedgerAccountType string
+
+	LedgerKind string
+
+	// Ledger collects the journals of one request. Attach it to the banks the request touches and
+	// commit it once the request is stored.
+	Ledger struct {
+		clk       clock.Clock
+		requestId string
+		accountId uuid.UUID
+		journals  []*Journal
+	}
+
+	// LedgerStatement holds the net debit of every ledger account, per asset
+	LedgerStatement struct {
+		Entries  []*JournalEntry                                  `json:"entries"`
+		Balances map[string]map[LedgerAccountType]decimal.Decimal `json:"balances"`
+	}
+)
+
+const (
+	LedgerAccountUser                     LedgerAccountType = "user"
+	LedgerAccountExternal                 LedgerAccountType = "external"
+	LedgerAccountLiquidityVault           LedgerAccountType = "liquidity_vault"
+	LedgerAccountInsuranceVault           LedgerAccountType = "insurance_vault"
+	LedgerAccountFeeVault                 LedgerAccountType = "fee_vault"
+	LedgerAccountInsuranceFeesOutstanding LedgerAccountType = "insurance_fees_outstanding"
+	LedgerAccountGroupFeesOutstanding     LedgerAccountType = "group_fees_outstanding"
+	LedgerAccountAccruedInterest          LedgerAccountType = "accrued_interest"
+)
+
+const (
+	LedgerKindDepositTransfer      LedgerKind = "deposit_transfer"
+	LedgerKindWithdrawTransfer     LedgerKind = "withdraw_transfer"
+	LedgerKindInsuranceToLiquidity LedgerKind = "insurance_to_liquidity"
+	LedgerKindRoundingDust         LedgerKind = "rounding_dust"
+	LedgerKindInterestFees         LedgerKind = "interest_fees"
+)
+
+// NewLedger creates the ledger of requestId, accountId is the user account of the request and
+// may be uuid.Nil for bank level requests
+func NewLedger(clk clock.Clock, requestId string, accountId uuid.UUID) *Ledger {
+	return &Ledger{
+		clk:       clk,
+		requestId: requestId,
+		accountId: accountId,
+		journals:  []*Journal{},
+	}
+}
+
+// Transfer records amount moving from one ledger account of the bank to another
+func (l *Ledger) Transfer(bank *Bank, kind LedgerKind, from, to LedgerAccountType, amount decimal.Decimal) {
+	if l == nil || amount.IsZero() {
+		return
+	}
+	if amount.IsNegative() {
+		from, to, amount = to, from, amount.Neg()
+	}
+
+	now := l.clk.Now().Unix()
+	journal := &Journal{
+		Id:        uuid.Must(uuid.NewV4()),
+		RequestId: l.requestId,
+		BankId:    bank.Id,
+		Kind:      kind,
+		CreatedAt: now,
+	}
+	journal.Entries = []JournalEntry{
+		l.entry(journal, bank, to, amount, decimal.Zero),
+		l.entry(journal, bank, from, decimal.Zero, amount),
+	}
+	l.journals = append(l.journals, journal)
+}
+
+func (l *Ledger) entry(journal *Journal, bank *Bank, account LedgerAccountType, debit, credit decimal.Decimal) JournalEntry {
+	entry := JournalEntry{
+		JournalId: journal.Id,
+		RequestId: journal.RequestId,
+		BankId:    bank.Id,
+		AssetId:   bank.MixinSafeAssetId,
+		Account:   account,
+		Debit:     debit,
+		Credit:    credit,
+		CreatedAt: journal.CreatedAt,
+	}
+	if account == LedgerAccountUser {
+		entry.AccountId = l.accountId
+	}
+	return entry
+}
+
+func (l *Ledger) Journals() []*Journal {
+	return l.journals
+}
+
+// Commit stores the collected journals and resets the ledger
+func (l *Ledger) Commit(ctx context.Context, ledgerStore LedgerStore) error {
+	for _, journal := range l.journals {
+		if !journal.IsBalanced() {
+			return ErrLedgerUnbalanced
+		}
+		if err := ledgerStore.CreateJournal(ctx, journal); err != nil {
+			return err
+		}
+	}
+	l.journals = []*Journal{}
+	return nil
+}
+
+// IsBalanced reports whether the debits equal the credits of every asset of the journal, an
+// empty journal or a negative entry is not balanced
+func (j *Journal) IsBalanced() bool {
+	if len(j.Entries) == 0 {
+		return false
+	}
+	net := map[string]decimal.Decimal{}
+	for _, entry := range j.Entries {
+		if entry.Debit.IsNegative() || entry.Credit.IsNegative() {
+			return false
+		}
+		net[entry.AssetId] = net[entry.AssetId].Add(entry.Debit).Sub(entry.Credit)
+	}
+	for _, amount := range net {
+		if !amount.IsZero() {
+			return false
+		}
+	}
+	return true
+}
+
+// AttachLedger makes the bank record its vault movements in ledger, nil detaches it
+func (b *Bank) AttachLedger(ledger *Ledger) {
+	b.ledger = ledger
+}
+
+// ledgerAccount resolves a vault pointer of the bank, any other pointer is the user side of the
+// transfer, or external when the ledger has no user account
+func (b *Bank) ledgerAccount(vault *decimal.Decimal) LedgerAccountType {
+	switch vault {
+	case &b.LiquidityVault:
+		return LedgerAccountLiquidityVault
+	case &b.InsuranceVault:
+		return LedgerAccountInsuranceVault
+	case &b.FeeVault:
+		return LedgerAccountFeeVault
+	case &b.CollectedInsuranceFeesOutstanding:
+		return LedgerAccountInsuranceFeesOutstanding
+	case &b.CollectedGroupFeesOutstanding:
+		return LedgerAccountGroupFeesOutstanding
+	}
+	if b.ledger != nil && b.ledger.accountId != uuid.Nil {
+		return LedgerAccountUser
+	}
+	return LedgerAccountExternal
+}
+
+func (b *Bank) recordTransfer(kind LedgerKind, amount decimal.Decimal, from, to *decimal.Decimal) {
+	if b.ledger == nil {
+		return
+	}
+	b.ledger.Transfer(b, kind, b.ledgerAccount(from), b.ledgerAccount(to), amount)
+}
+
+// BankStatement lists the journal entries of the bank with the net balance of every ledger account
+func BankStatement(ctx context.Context, ledgerStore LedgerStore, bankId uuid.UUID, createdBeforeAt, limit int64) (*LedgerStatement, error) {
+	entries, err := ledgerStore.ListJournalEntriesByBank(ctx, bankId, createdBeforeAt, limit)
+	if err != nil {
+		return nil, err
+	}
+	return NewLedgerStatement(entries), nil
+}
+
+// AccountStatement lists the journal entries of the user account across banks
+func AccountStatement(ctx context.Context, ledgerStore LedgerStore, accountId uuid.UUID, createdBeforeAt, limit int64) (*LedgerStatement, error) {
+	entries, err := ledgerStore.ListJournalEntriesByAccount(ctx, accountId, createdBeforeAt, limit)
+	if err != nil {
+		return nil, err
+	}
+	return NewLedgerStatement(entries), nil
+}
+
+func NewLedgerStatement(entries []*JournalEntry) *LedgerStatement {
+	statement := &LedgerStatement{
+		Entries:  entries,
+		Balances: map[string]map[LedgerAccountType]decimal.Decimal{},
+	}
+	for _, entry := range entries {
+		balances, ok := statement.Balances[entry.AssetId]
+		if !ok {
+			balances = map[LedgerAccountType]decimal.Decimal{}
+			statement.Balances[entry.AssetId] = balances
+		}
+		balance, ok := balances[entry.Account]
+		if !ok {
+			balance = decimal.Zero
+		}
+		balances[entry.Account] = balance.Add(entry.Debit).Sub(entry.Credit)
+	}
+	return statement
+}
@@ -0,0 +1,59 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/facebookgo/clock"
+	"github.com/gofrs/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLedgerBankTransfers(t *testing.T) {
+	accountId := uuid.Must(uuid.NewV4())
+	ledger := NewLedger(clock.NewMock(), "request", accountId)
+	bank := &Bank{
+		Id:               uuid.Must(uuid.NewV4()),
+		MixinSafeAssetId: "asset",
+		LiquidityVault:   decimal.Zero,
+		InsuranceVault:   decimal.NewFromInt(5),
+	}
+	bank.AttachLedger(ledger)
+
+	wallet := decimal.NewFromInt(100)
+	bank.DepositTransfer(decimal.NewFromInt(10), &wallet, &bank.LiquidityVault)
+	assert.NoError(t, bank.TransferFromInsuranceToLiquidity(decimal.NewFromInt(2)))
+
+	journals := ledger.Journals()
+	assert.Len(t, journals, 2)
+	for _, journal := range journals {
+		assert.True(t, journal.IsBalanced())
+		assert.Equal(t, "request", journal.RequestId)
+	}
+
+	entries := []*JournalEntry{}
+	for _, journal := range journals {
+		for i := range journal.Entries {
+			entries = append(entries, &journal.Entries[i])
+		}
+	}
+	statement := NewLedgerStatement(entries)
+	balances := statement.Balances["asset"]
+	assert.True(t, balances[LedgerAccountLiquidityVault].Equal(decimal.NewFromInt(12)))
+	assert.True(t, balances[LedgerAccountUser].Equal(decimal.NewFromInt(-10)))
+	assert.True(t, balances[LedgerAccountInsuranceVault].Equal(decimal.NewFromInt(-2)))
+	assert.Equal(t, accountId, journals[0].Entries[1].AccountId)
+}
+
+func TestJournalIsBalanced(t *testing.T) {
+	entry := func(assetId string, debit, credit int64) JournalEntry {
+		return JournalEntry{AssetId: assetId, Debit: decimal.NewFromInt(debit), Credit: decimal.NewFromInt(credit)}
+	}
+
+	assert.True(t, (&Journal{Entries: []JournalEntry{entry("a", 1, 0), entry("a", 0, 1)}}).IsBalanced())
+	assert.False(t, (&Journal{}).IsBalanced())
+	assert.False(t, (&Journal{Entries: []JournalEntry{entry("a", 1, 0), entry("a", 0, 2)}}).IsBalanced())
+	// the totals match but every asset has to balance on its own
+	assert.False(t, (&Journal{Entries: []JournalEntry{entry("a", 1, 0), entry("b", 0, 1)}}).IsBalanced())
+	assert.False(t, (&Journal{Entries: []JournalEntry{entry("a", -1, 0), entry("a", 0, -1)}}).IsBalanced())
+}
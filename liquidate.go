@@ -26,3 +26,38 @@ type LiquidateResult struct {
 	LiquidateeAssetBalance     *BankAccountWrapper `json:"liquidateeAssetBalance"`
 	LiquidateeLiabilityBalance *BankAccountWrapper `json:"liquidateeLiabilityBalance"`
 }
+
+// Actions returns the supplies, withdraws, borrows and repays that moved the four balances from
+// PreBalances to PostBalances, in native units of the banks
+func (r *LiquidateResult) Actions() []ActionDetail {
+	actions := []ActionDetail{}
+	actions = append(actions, balanceActions(r.AssetBank, r.PreBalances.LiquidateeAssetBalance, r.PostBalances.LiquidateeAssetBalance)...)
+	actions = append(actions, balanceActions(r.LiabilityBank, r.PreBalances.LiquidateeLiabilityBalance, r.PostBalances.LiquidateeLiabilityBalance)...)
+	actions = append(actions, balanceActions(r.AssetBank, r.PreBalances.LiquidatorAssetBalance, r.PostBalances.LiquidatorAssetBalance)...)
+	actions = append(actions, balanceActions(r.LiabilityBank, r.PreBalances.LiquidatorLiabilityBalance, r.PostBalances.LiquidatorLiabilityBalance)...)
+	return actions
+}
+
+func balanceActions(bank *Bank, pre, post *Balance) []ActionDetail {
+	if bank == nil || post == nil {
+		return nil
+	}
+	preAssets, preLiabilities := decimal.Zero, decimal.Zero
+	if pre != nil {
+		preAssets, preLiabilities = pre.ComputeQuantity(bank)
+	}
+	postAssets, postLiabilities := post.ComputeQuantity(bank)
+
+	actions := []ActionDetail{}
+	add := func(increase, decrease MemoActionType, delta decimal.Decimal) {
+		switch {
+		case delta.IsPositive():
+			actions = append(actions, ActionDetail{AccountId: post.AccountId, ActionType: increase, BankId: bank.Id, Amount: delta})
+		case delta.IsNegative():
+			actions = append(actions, ActionDetail{AccountId: post.AccountId, ActionType: decrease, BankId: bank.Id, Amount: delta.Neg()})
+		}
+	}
+	add(MATSupply, MATWithdraw, postAssets.Sub(preAssets))
+	add(MATBorrow, MATRepay, postLiabilities.Sub(preLiabilities))
+	return actions
+}
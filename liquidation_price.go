@@ -0,0 +1,160 @@
+package core
+
+import (
+	"context"
+
+	"github.com/facebookgo/clock"
+	"github.com/gofrs/uuid"
+	"github.com/shopspring/decimal"
+)
+
+type (
+	// LiquidationPrice is the price of the bank asset at which the account reaches zero Maintenance
+	// health, assuming every price in FixedPrices stays where it is
+	LiquidationPrice struct {
+		BankId           uuid.UUID       `json:"bankId"`
+		MixinSafeAssetId string          `json:"mixinSafeAssetId"`
+		Side             BalanceSide     `json:"side"`
+		Price            decimal.Decimal `json:"price"`
+		// LiquidationPrice is zero when no price of this asset alone makes the account liquidatable,
+		// or when it already is liquidatable whatever the price
+		LiquidationPrice decimal.Decimal `json:"liquidationPrice"`
+		// FixedPrices are the prices of the other balances, by bank id, held constant by the solution
+		FixedPrices map[uuid.UUID]decimal.Decimal `json:"fixedPrices"`
+	}
+
+	LiquidationPrices struct {
+		AccountId      uuid.UUID           `json:"accountId"`
+		AssetValue     decimal.Decimal     `json:"assetValue"`
+		LiabilityValue decimal.Decimal     `json:"liabilityValue"`
+		Prices         []*LiquidationPrice `json:"prices"`
+		// OtherPricesFixed is true when the account holds more than one asset, every liquidation
+		// price then only holds while the other prices don't move
+		OtherPricesFixed bool `json:"otherPricesFixed"`
+		// Liquidatable is true when the Maintenance health is already at or below zero, every
+		// liquidation price is zero then
+		Liquidatable bool `json:"liquidatable"`
+	}
+)
+
+// ComputeLiquidationPrices solves the Maintenance liquidation price of every active balance of the
+// account, loading the balances, banks and prices once. The banks are valued with the interest
+// accrued up to now.
+func ComputeLiquidationPrices(ctx context.Context, clk clock.Clock, log Log, bankAccountService BankAccountService, priceFeedMgr PriceAdapterMgr, accountId uuid.UUID) (*LiquidationPrices, error) {
+	account, err := bankAccountService.GetAccountById(ctx, accountId)
+	if err != nil {
+		return nil, err
+	}
+	banks, err := bankAccountService.ListBankByGroupId(ctx, account.GroupId)
+	if err != nil {
+		return nil, err
+	}
+	banks, err = AccruedClones(log, clk.Now().Unix(), banks)
+	if err != nil {
+		return nil, err
+	}
+	banksById := make(map[uuid.UUID]*Bank, len(banks))
+	for _, bank := range banks {
+		banksById[bank.Id] = bank
+	}
+	balances, err := bankAccountService.ListBalances(ctx, accountId, uuid.Nil)
+	if err != nil {
+		return nil, err
+	}
+
+	type pricedBalance struct {
+		bank  *Bank
+		side  BalanceSide
+		price decimal.Decimal
+		// value at a price of one, the weighted values are linear in the price
+		unitValue decimal.Decimal
+	}
+
+	prices := NewPriceSnapshot(priceFeedMgr)
+	result := &LiquidationPrices{
+		AccountId:      accountId,
+		AssetValue:     decimal.Zero,
+		LiabilityValue: decimal.Zero,
+		Prices:         []*LiquidationPrice{},
+	}
+	pricedBalances := []*pricedBalance{}
+	for _, balance := range balances {
+		if !balance.Active {
+			continue
+		}
+		side, err := balance.GetSide()
+		if err != nil {
+			return nil, err
+		}
+		if side == BalanceSideEmpty {
+			continue
+		}
+
+		bank, ok := banksById[balance.BankId]
+		if !ok {
+			return nil, BankNotFound
+		}
+		priceAdapter, err := prices.GetPriceAdapter(bank)
+		if err != nil {
+			return nil, err
+		}
+		price, err := priceAdapter.GetPriceOfType(Maintenance.GetOraclePriceType(), Original)
+		if err != nil {
+			return nil, err
+		}
+
+		assets, liabilities := balance.GetUsdValueWithPriceBias(bank, ONE, Maintenance)
+		unitValue := assets
+		if side == BalanceSideLiabilities {
+			unitValue = liabilities
+		}
+		result.AssetValue = result.AssetValue.Add(assets.Mul(price))
+		result.LiabilityValue = result.LiabilityValue.Add(liabilities.Mul(price))
+		pricedBalances = append(pricedBalances, &pricedBalance{
+			bank:      bank,
+			side:      side,
+			price:     price,
+			unitValue: unitValue,
+		})
+	}
+	result.OtherPricesFixed = len(pricedBalances) > 1
+	result.Liquidatable = result.LiabilityValue.IsPositive() && !result.AssetValue.GreaterThan(result.LiabilityValue)
+
+	for _, b := range pricedBalances {
+		liquidationPrice := &LiquidationPrice{
+			BankId:           b.bank.Id,
+			MixinSafeAssetId: b.bank.MixinSafeAssetId,
+			Side:             b.side,
+			Price:            b.price,
+			LiquidationPrice: decimal.Zero,
+			FixedPrices:      map[uuid.UUID]decimal.Decimal{},
+		}
+		for _, other := range pricedBalances {
+			if other != b {
+				liquidationPrice.FixedPrices[other.bank.Id] = other.price
+			}
+		}
+
+		ownValue := b.unitValue.Mul(b.price)
+		otherAssets, otherLiabilities := result.AssetValue, result.LiabilityValue
+		if b.side == BalanceSideAssets {
+			otherAssets = otherAssets.Sub(ownValue)
+		} else {
+			otherLiabilities = otherLiabilities.Sub(ownValue)
+		}
+
+		// solve otherAssets + unitValue*p = otherLiabilities for an asset, and the other way around
+		// for a liability
+		if !result.Liquidatable && b.unitValue.IsPositive() {
+			solved := otherLiabilities.Sub(otherAssets).Div(b.unitValue)
+			if b.side == BalanceSideLiabilities {
+				solved = solved.Neg()
+			}
+			if solved.IsPositive() {
+				liquidationPrice.LiquidationPrice = solved
+			}
+		}
+		result.Prices = append(result.Prices, liquidationPrice)
+	}
+	return result, nil
+}
@@ -0,0 +1,81 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/clock"
+	"github.com/gofrs/uuid"
+	"github.com/rs/zerolog"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComputeLiquidationPrices(t *testing.T) {
+	clk := clock.NewMock()
+	log := zerolog.Nop()
+	btc, eth, usdt := newBank("btc"), newBank("eth"), newBank("usdt")
+	accountId := uuid.Must(uuid.NewV4())
+	store := &scannerStore{accounts: map[uuid.UUID]*Account{accountId: {Id: accountId}}}
+	store.banks = []*Bank{btc, eth, usdt}
+	store.balances = []*Balance{
+		{AccountId: accountId, BankId: btc.Id, Active: true, AssetShares: ONE, LiabilityShares: decimal.Zero},
+		{AccountId: accountId, BankId: eth.Id, Active: true, AssetShares: decimal.NewFromInt(10), LiabilityShares: decimal.Zero},
+		{AccountId: accountId, BankId: usdt.Id, Active: true, AssetShares: decimal.Zero, LiabilityShares: decimal.NewFromInt(1000)},
+	}
+	prices := ratesPriceFeedMgr{"btc": decimal.NewFromInt(1000), "eth": decimal.NewFromInt(100), "usdt": ONE}
+	svc := BankAccountService{BalanceStore: store, BankStore: store, AccountStore: store}
+
+	result, err := ComputeLiquidationPrices(context.Background(), clk, &log, svc, prices, accountId)
+	assert.NoError(t, err)
+	liabilityValue := result.LiabilityValue
+	assert.True(t, result.OtherPricesFixed)
+	assert.False(t, result.Liquidatable)
+	assert.Len(t, result.Prices, 3)
+
+	byBank := map[uuid.UUID]*LiquidationPrice{}
+	for _, price := range result.Prices {
+		byBank[price.BankId] = price
+	}
+	assert.Len(t, byBank[btc.Id].FixedPrices, 2)
+	assert.True(t, byBank[btc.Id].FixedPrices[eth.Id].Equal(decimal.NewFromInt(100)))
+
+	// every liquidation price brings the Maintenance health to zero with the other prices fixed
+	for bankId, price := range byBank {
+		assert.True(t, price.LiquidationPrice.IsPositive(), "bank %s", bankId)
+		moved := ratesPriceFeedMgr{"btc": prices["btc"], "eth": prices["eth"], "usdt": prices["usdt"]}
+		for _, bank := range store.banks {
+			if bank.Id == bankId {
+				moved[bank.MixinSafeAssetId] = price.LiquidationPrice
+			}
+		}
+		after, err := ComputeLiquidationPrices(context.Background(), clk, &log, svc, moved, accountId)
+		assert.NoError(t, err)
+		assert.True(t, after.AssetValue.Sub(after.LiabilityValue).Abs().LessThan(EMPTY_BALANCE_THRESHOLD), "bank %s", bankId)
+	}
+
+	// below zero health no price of one asset is the liquidation price
+	prices["btc"] = decimal.NewFromInt(200)
+	result, err = ComputeLiquidationPrices(context.Background(), clk, &log, svc, prices, accountId)
+	assert.NoError(t, err)
+	assert.True(t, result.Liquidatable)
+	for _, price := range result.Prices {
+		assert.True(t, price.LiquidationPrice.IsZero(), "bank %s", price.BankId)
+	}
+
+	// the debt is valued with the interest owed up to now, the stored bank is not changed
+	prices["btc"] = decimal.NewFromInt(1000)
+	usdt.TotalAssetShares, usdt.TotalLiabilityShares = decimal.NewFromInt(2000), decimal.NewFromInt(1000)
+	usdt.InterestRateConfig = InterestRateConfig{
+		OptimalUtilizationRate: decimal.NewFromFloat(0.8),
+		PlateauInterestRate:    decimal.NewFromFloat(0.1),
+		MaxInterestRate:        ONE,
+	}
+	clk.Add(365 * 24 * time.Hour)
+	result, err = ComputeLiquidationPrices(context.Background(), clk, &log, svc, prices, accountId)
+	assert.NoError(t, err)
+	assert.True(t, result.LiabilityValue.GreaterThan(liabilityValue))
+	assert.True(t, usdt.LiabilityShareValue.Equal(ONE))
+	assert.Zero(t, usdt.LastUpdate)
+}
@@ -0,0 +1,285 @@
+package core
+
+import (
+	"context"
+	"sort"
+
+	"github.com/facebookgo/clock"
+	"github.com/gofrs/uuid"
+	"github.com/shopspring/decimal"
+)
+
+type (
+	// LiquidationSuggestion is the liquidation of one asset and liability pair of an account. The
+	// liquidator pays LiabilityAmount of the liability bank asset and receives AssetAmount.
+	LiquidationSuggestion struct {
+		AssetBankId     uuid.UUID       `json:"assetBankId"`
+		LiabilityBankId uuid.UUID       `json:"liabilityBankId"`
+		AssetAmount     decimal.Decimal `json:"assetAmount"`
+		LiabilityAmount decimal.Decimal `json:"liabilityAmount"`
+		// ExpectedProfit is the liquidator fee in USD
+		ExpectedProfit decimal.Decimal `json:"expectedProfit"`
+		// PostHealth is the Maintenance health of the account after the liquidation
+		PostHealth decimal.Decimal `json:"postHealth"`
+	}
+
+	LiquidationCandidate struct {
+		AccountId uuid.UUID `json:"accountId"`
+		GroupId   uuid.UUID `json:"groupId"`
+		PubKey    string    `json:"pubKey"`
+		// AssetValue and LiabilityValue are the Maintenance weighted values in USD
+		AssetValue     decimal.Decimal        `json:"assetValue"`
+		LiabilityValue decimal.Decimal        `json:"liabilityValue"`
+		Health         decimal.Decimal        `json:"health"`
+		Shortfall      decimal.Decimal        `json:"shortfall"`
+		Suggestion     *LiquidationSuggestion `json:"suggestion,omitempty"`
+	}
+
+	// LiquidationScanner finds the accounts of a group below the Maintenance requirement
+	LiquidationScanner struct {
+		clk                clock.Clock
+		log                Log
+		bankAccountService BankAccountService
+		priceFeedMgr       PriceAdapterMgr
+		circuitBreaker     *CircuitBreaker
+	}
+
+	LiquidationScannerOptionFunc func(s *LiquidationScanner)
+)
+
+// WithScannerCircuitBreaker feeds the prices and the bank states of every scan to the circuit breaker
+func WithScannerCircuitBreaker(circuitBreaker *CircuitBreaker) LiquidationScannerOptionFunc {
+	return func(s *LiquidationScanner) {
+		s.circuitBreaker = circuitBreaker
+	}
+}
+
+func NewLiquidationScanner(clk clock.Clock, log Log, bankAccountService BankAccountService, priceFeedMgr PriceAdapterMgr, opts ...LiquidationScannerOptionFunc) *LiquidationScanner {
+	s := &LiquidationScanner{
+		clk:                clk,
+		log:                log,
+		bankAccountService: bankAccountService,
+		priceFeedMgr:       priceFeedMgr,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Scan values every account with balances in the group against one price snapshot and returns
+// the unhealthy ones, the largest shortfall first and then the most profitable
+func (s *LiquidationScanner) Scan(ctx context.Context, groupId uuid.UUID) ([]*LiquidationCandidate, error) {
+	group, err := s.bankAccountService.GetGroup(ctx, groupId)
+	if err != nil {
+		return nil, err
+	}
+	config := group.GetConfig()
+
+	banks, err := s.bankAccountService.ListBankByGroupId(ctx, groupId)
+	if err != nil {
+		return nil, err
+	}
+	// the accounts are valued with the interest owed up to now, the stored banks are left alone
+	banks, err = AccruedClones(s.log, s.clk.Now().Unix(), ExcludeDeletedBanks(banks))
+	if err != nil {
+		return nil, err
+	}
+
+	prices := NewPriceSnapshot(s.priceFeedMgr)
+	accountIds := []uuid.UUID{}
+	bankAccounts := map[uuid.UUID][]*BankAccountWithPriceFeed{}
+	for _, bank := range banks {
+		priceFeed, err := prices.GetPriceAdapter(bank)
+		if err != nil {
+			return nil, err
+		}
+		if s.circuitBreaker != nil {
+			if _, err := s.circuitBreaker.ObserveBank(ctx, group, bank, priceFeed); err != nil {
+				return nil, err
+			}
+		}
+		balances, err := s.bankAccountService.ListBalances(ctx, uuid.Nil, bank.Id)
+		if err != nil {
+			return nil, err
+		}
+		for _, balance := range balances {
+			if !balance.Active {
+				continue
+			}
+			if _, ok := bankAccounts[balance.AccountId]; !ok {
+				accountIds = append(accountIds, balance.AccountId)
+			}
+			bankAccounts[balance.AccountId] = append(bankAccounts[balance.AccountId], &BankAccountWithPriceFeed{
+				Bank:      bank,
+				Balance:   balance,
+				PriceFeed: priceFeed,
+			})
+		}
+	}
+
+	candidates := []*LiquidationCandidate{}
+	for _, accountId := range accountIds {
+		candidate, err := s.evaluate(groupId, accountId, bankAccounts[accountId], config)
+		if err != nil {
+			s.log.Warn().Msgf("liquidation scan of account %s failed: %v", accountId, err)
+			continue
+		}
+		if candidate == nil {
+			continue
+		}
+
+		account, err := s.bankAccountService.GetAccountById(ctx, accountId)
+		if err != nil {
+			return nil, err
+		}
+		if account.GetFlag(InFlashloanFlag) || account.GetFlag(ClosedFlag) {
+			continue
+		}
+		candidate.PubKey = account.PubKey
+		candidates = append(candidates, candidate)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if !candidates[i].Shortfall.Equal(candidates[j].Shortfall) {
+			return candidates[i].Shortfall.GreaterThan(candidates[j].Shortfall)
+		}
+		return candidates[i].expectedProfit().GreaterThan(candidates[j].expectedProfit())
+	})
+	return candidates, nil
+}
+
+func (s *LiquidationScanner) evaluate(groupId, accountId uuid.UUID, bankAccounts []*BankAccountWithPriceFeed, config GroupConfig) (*LiquidationCandidate, error) {
+	riskEngine := &RiskEngine{BankAccountsWithPrice: bankAccounts}
+	totalAssets, totalLiabilities, err := riskEngine.GetAccountHealthComponents(Maintenance)
+	if err != nil {
+		return nil, err
+	}
+	health := totalAssets.Sub(totalLiabilities)
+	if !health.IsNegative() {
+		return nil, nil
+	}
+
+	candidate := &LiquidationCandidate{
+		AccountId:      accountId,
+		GroupId:        groupId,
+		AssetValue:     totalAssets,
+		LiabilityValue: totalLiabilities,
+		Health:         health,
+		Shortfall:      health.Neg(),
+	}
+
+	for _, asset := range bankAccounts {
+		if asset.IsEmpty(BalanceSideAssets) {
+			continue
+		}
+		for _, liability := range bankAccounts {
+			if liability.IsEmpty(BalanceSideLiabilities) || !liability.IsEmpty(BalanceSideAssets) {
+				continue
+			}
+			suggestion, err := SuggestLiquidation(asset, liability, health, config)
+			if err != nil {
+				return nil, err
+			}
+			if suggestion == nil {
+				continue
+			}
+			if candidate.Suggestion == nil || suggestion.ExpectedProfit.GreaterThan(candidate.Suggestion.ExpectedProfit) {
+				candidate.Suggestion = suggestion
+			}
+		}
+	}
+	return candidate, nil
+}
+
+func (c *LiquidationCandidate) expectedProfit() decimal.Decimal {
+	if c.Suggestion == nil {
+		return decimal.Zero
+	}
+	return c.Suggestion.ExpectedProfit
+}
+
+// MemoAction returns the liquidate memo of the suggestion for the liquidator account at accountIndex
+func (c *LiquidationCandidate) MemoAction(accountIndex uint8) MemoActionLiquidate {
+	memo := MemoActionLiquidate{
+		MemoAction: MemoAction{
+			AccountIndex: accountIndex,
+			ActionType:   MATLiquidate,
+		},
+		LiquidateeAccountId: c.AccountId,
+	}
+	if c.Suggestion != nil {
+		memo.BankId = c.Suggestion.AssetBankId
+		memo.LiabilityBankId = c.Suggestion.LiabilityBankId
+	}
+	return memo
+}
+
+// SuggestLiquidation sizes the liquidation of the asset balance against the liability balance. The
+// liquidator receives the asset at a discount of the liquidator fee and the liquidatee is credited
+// the asset value net of both fees, the amount is the largest one that keeps the Maintenance health
+// at or below zero. It returns nil when the pair can't improve the health.
+func SuggestLiquidation(asset, liability *BankAccountWithPriceFeed, health decimal.Decimal, config GroupConfig) (*LiquidationSuggestion, error) {
+	if asset.Bank.RiskTier != Collateral || asset.PriceFeed == nil || liability.PriceFeed == nil {
+		return nil, nil
+	}
+
+	assetPrice, err := asset.PriceFeed.GetPriceOfType(RealTime, Original)
+	if err != nil {
+		return nil, err
+	}
+	assetLowPrice, err := asset.PriceFeed.GetPriceOfType(Maintenance.GetOraclePriceType(), Low)
+	if err != nil {
+		return nil, err
+	}
+	liabilityPrice, err := liability.PriceFeed.GetPriceOfType(RealTime, Original)
+	if err != nil {
+		return nil, err
+	}
+	liabilityHighPrice, err := liability.PriceFeed.GetPriceOfType(Maintenance.GetOraclePriceType(), High)
+	if err != nil {
+		return nil, err
+	}
+	if !assetPrice.IsPositive() || !liabilityPrice.IsPositive() {
+		return nil, nil
+	}
+
+	liquidatorFee := *config.LiquidationLiquidatorFee
+	totalFee := liquidatorFee.Add(*config.LiquidationInsuranceFee)
+	// liability repaid for the liquidatee per unit of asset seized
+	repaidPerAsset := assetPrice.Mul(ONE.Sub(totalFee)).Div(liabilityPrice)
+
+	assetWeightedValue := assetLowPrice.Mul(asset.Bank.GetWeight(Maintenance, BalanceSideAssets))
+	liabilityWeightedValue := decimal.Zero
+	if liability.Bank.RiskTier == Collateral {
+		liabilityWeightedValue = repaidPerAsset.Mul(liabilityHighPrice).Mul(liability.Bank.GetWeight(Maintenance, BalanceSideLiabilities))
+	}
+	healthPerAsset := liabilityWeightedValue.Sub(assetWeightedValue)
+	if !healthPerAsset.IsPositive() {
+		return nil, nil
+	}
+
+	assetAmount, err := asset.Bank.GetAssetAmount(asset.Balance.AssetShares)
+	if err != nil {
+		return nil, err
+	}
+	liabilityAmount, err := liability.Bank.GetLiabilityAmount(liability.Balance.LiabilityShares)
+	if err != nil {
+		return nil, err
+	}
+
+	amount := decimal.Min(health.Neg().Div(healthPerAsset), assetAmount, liabilityAmount.Div(repaidPerAsset))
+	if !amount.IsPositive() {
+		return nil, nil
+	}
+
+	assetValue := amount.Mul(assetPrice)
+	return &LiquidationSuggestion{
+		AssetBankId:     asset.Bank.Id,
+		LiabilityBankId: liability.Bank.Id,
+		AssetAmount:     amount,
+		LiabilityAmount: assetValue.Mul(ONE.Sub(liquidatorFee)).Div(liabilityPrice),
+		ExpectedProfit:  assetValue.Mul(liquidatorFee),
+		PostHealth:      health.Add(amount.Mul(healthPerAsset)),
+	}, nil
+}
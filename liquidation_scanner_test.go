@@ -0,0 +1,114 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/clock"
+	"github.com/gofrs/uuid"
+	"github.com/rs/zerolog"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+type scannerStore struct {
+	ratesStore
+	GroupStore
+	group    *Group
+	accounts map[uuid.UUID]*Account
+}
+
+// newBank is a Collateral bank with share values of one and a Maintenance asset weight of 0.8
+func newBank(assetId string) *Bank {
+	return &Bank{
+		Id:                  uuid.Must(uuid.NewV4()),
+		MixinSafeAssetId:    assetId,
+		AssetShareValue:     ONE,
+		LiabilityShareValue: ONE,
+		BankConfig: BankConfig{
+			AssetWeightMaint:     decimal.NewFromFloat(0.8),
+			LiabilityWeightMaint: ONE,
+			RiskTier:             Collateral,
+		},
+	}
+}
+
+func (s *scannerStore) GetGroupById(ctx context.Context, id uuid.UUID) (*Group, error) {
+	return s.group, nil
+}
+
+func (s *scannerStore) ListBankByGroupId(ctx context.Context, groupId uuid.UUID) ([]*Bank, error) {
+	return s.banks, nil
+}
+
+func (s *scannerStore) ListBalances(ctx context.Context, accountId, bankId uuid.UUID) ([]*Balance, error) {
+	balances := []*Balance{}
+	for _, balance := range s.balances {
+		if (accountId == uuid.Nil || balance.AccountId == accountId) && (bankId == uuid.Nil || balance.BankId == bankId) {
+			balances = append(balances, balance)
+		}
+	}
+	return balances, nil
+}
+
+func (s *scannerStore) GetAccountById(ctx context.Context, accountId uuid.UUID) (*Account, error) {
+	return s.accounts[accountId], nil
+}
+
+func TestLiquidationScanner(t *testing.T) {
+	btc, usdt := newBank("btc"), newBank("usdt")
+	healthy, unhealthy, closed := uuid.Must(uuid.NewV4()), uuid.Must(uuid.NewV4()), uuid.Must(uuid.NewV4())
+	store := &scannerStore{
+		group: &Group{},
+		accounts: map[uuid.UUID]*Account{
+			healthy:   {Id: healthy},
+			unhealthy: {Id: unhealthy},
+			closed:    {Id: closed, AccountFlags: ClosedFlag},
+		},
+	}
+	store.banks = []*Bank{btc, usdt}
+	for _, accountId := range []uuid.UUID{healthy, unhealthy, closed} {
+		borrow := decimal.NewFromInt(70)
+		if accountId == healthy {
+			borrow = decimal.NewFromInt(50)
+		}
+		store.balances = append(store.balances,
+			&Balance{AccountId: accountId, BankId: btc.Id, Active: true, AssetShares: ONE, LiabilityShares: decimal.Zero},
+			&Balance{AccountId: accountId, BankId: usdt.Id, Active: true, AssetShares: decimal.Zero, LiabilityShares: borrow},
+		)
+	}
+	prices := ratesPriceFeedMgr{"btc": decimal.NewFromInt(80), "usdt": ONE}
+	svc := BankAccountService{BalanceStore: store, BankStore: store, AccountStore: store, GroupStore: store}
+	log := zerolog.Nop()
+
+	candidates, err := NewLiquidationScanner(clock.NewMock(), &log, svc, prices).Scan(context.Background(), uuid.Nil)
+	assert.NoError(t, err)
+	assert.Len(t, candidates, 1)
+
+	candidate := candidates[0]
+	assert.Equal(t, unhealthy, candidate.AccountId)
+	assert.True(t, candidate.Shortfall.Equal(decimal.NewFromInt(6)))
+	assert.NotNil(t, candidate.Suggestion)
+	assert.Equal(t, btc.Id, candidate.Suggestion.AssetBankId)
+	assert.Equal(t, usdt.Id, candidate.Suggestion.LiabilityBankId)
+	assert.True(t, candidate.Suggestion.PostHealth.Abs().LessThan(EMPTY_BALANCE_THRESHOLD))
+	assert.True(t, candidate.Suggestion.ExpectedProfit.Equal(candidate.Suggestion.AssetAmount.Mul(decimal.NewFromInt(80)).Mul(LIQUIDATION_LIQUIDATOR_FEE)))
+
+	memo := candidate.MemoAction(1)
+	assert.True(t, memo.Valid())
+
+	// a year of interest makes the healthy account liquidatable, the stored bank is not changed
+	clk := clock.NewMock()
+	clk.Add(365 * 24 * time.Hour)
+	usdt.TotalAssetShares, usdt.TotalLiabilityShares = decimal.NewFromInt(200), decimal.NewFromInt(190)
+	usdt.InterestRateConfig = InterestRateConfig{
+		OptimalUtilizationRate: decimal.NewFromFloat(0.8),
+		PlateauInterestRate:    decimal.NewFromFloat(0.1),
+		MaxInterestRate:        ONE,
+	}
+	candidates, err = NewLiquidationScanner(clk, &log, svc, prices).Scan(context.Background(), uuid.Nil)
+	assert.NoError(t, err)
+	assert.Len(t, candidates, 2)
+	assert.True(t, usdt.LiabilityShareValue.Equal(ONE))
+}
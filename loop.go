@@ -1,6 +1,8 @@
 package core
 
 import (
+	"math"
+
 	"github.com/gofrs/uuid"
 	"github.com/shopspring/decimal"
 )
@@ -16,6 +18,9 @@ type LoopPaymentOptions struct {
 	LoopStep2 *LoopPaymentStep  `json:"loopStep2,omitempty"`
 	LoopStep3 *LoopPaymentStep3 `json:"loopStep3,omitempty"`
 	LoopStep4 *LoopPaymentStep  `json:"loopStep4,omitempty"`
+
+	// Refund is the surplus of a deleverage repay, saved before it is paid back to the user
+	Refund *LoopPaymentRefund `json:"refund,omitempty"`
 }
 
 type LoopPaymentType string
@@ -25,6 +30,37 @@ const (
 	LoopPaymentTypeShort LoopPaymentType = "short"
 )
 
+func (t LoopPaymentType) Valid() bool {
+	switch t {
+	case LoopPaymentTypeLong, LoopPaymentTypeShort:
+		return true
+	default:
+		return false
+	}
+}
+
+// ValidLeverage reports whether targetLeverage is meaningful for the loop type.
+// A long loop holds targetLeverage times the deposit, so it has to be above one.
+// A short loop borrows targetLeverage times the deposit, so any positive value works.
+func (t LoopPaymentType) ValidLeverage(targetLeverage decimal.Decimal) bool {
+	switch t {
+	case LoopPaymentTypeLong:
+		return targetLeverage.GreaterThan(ONE)
+	case LoopPaymentTypeShort:
+		return targetLeverage.IsPositive()
+	default:
+		return false
+	}
+}
+
+// borrowValueMultiplier returns the borrowed value as a multiple of the deposit value
+func (t LoopPaymentType) borrowValueMultiplier(targetLeverage decimal.Decimal) decimal.Decimal {
+	if t == LoopPaymentTypeShort {
+		return targetLeverage
+	}
+	return targetLeverage.Sub(ONE)
+}
+
 type LoopPaymentStep struct {
 	Action  MemoActionType  `json:"action,omitempty"`
 	BankId  uuid.UUID       `json:"bankId,omitempty"`
@@ -62,6 +98,14 @@ func WithClosePositionResult(closePositionResult *ClosePositionResult) PmtOptFun
 	}
 }
 
+// LoopPaymentRefund is an amount the loop pays back to the user, it stays pending until the
+// transfer went through so a retry pays the same amount
+type LoopPaymentRefund struct {
+	BankId uuid.UUID       `json:"bankId,omitempty"`
+	Amount decimal.Decimal `json:"amount,omitempty"`
+	State  PaymentStatus   `json:"state,omitempty"`
+}
+
 func NewLoopPaymentStep(action MemoActionType, bankId uuid.UUID, amount decimal.Decimal) *LoopPaymentStep {
 	step := &LoopPaymentStep{
 		Action: action,
@@ -82,3 +126,79 @@ func NewLoopPaymentStep3(inputBankId, outputBankId uuid.UUID, orderId string, sw
 	}
 	return step
 }
+
+// ComputeLoopMaxLeverage returns the highest leverage allowed by the initial weights of both banks
+func ComputeLoopMaxLeverage(loopType LoopPaymentType, depositBank, borrowBank *Bank) decimal.Decimal {
+	assetWeight := depositBank.BankConfig.AssetWeightInit
+	liabilityWeight := borrowBank.BankConfig.LiabilityWeightInit
+
+	denominator := liabilityWeight.Sub(assetWeight)
+	if !denominator.IsPositive() {
+		return decimal.NewFromUint64(math.MaxUint64)
+	}
+
+	switch loopType {
+	case LoopPaymentTypeShort:
+		// (1 + L) * assetWeight >= L * liabilityWeight
+		return assetWeight.Div(denominator)
+	default:
+		// L * assetWeight >= (L - 1) * liabilityWeight
+		return liabilityWeight.Div(denominator)
+	}
+}
+
+// ComputeLoopBorrowAmount returns the borrow bank amount needed to lever depositAmount up to targetLeverage
+func ComputeLoopBorrowAmount(loopType LoopPaymentType, depositAmount, targetLeverage, depositPrice, borrowPrice decimal.Decimal) (decimal.Decimal, error) {
+	if !depositPrice.IsPositive() || !borrowPrice.IsPositive() {
+		return decimal.Zero, InvalidPrice
+	}
+	if !loopType.ValidLeverage(targetLeverage) {
+		return decimal.Zero, InvalidAction
+	}
+
+	borrowValue := depositAmount.Mul(depositPrice).Mul(loopType.borrowValueMultiplier(targetLeverage))
+	return borrowValue.Div(borrowPrice).Truncate(8), nil
+}
+
+// ComputeLoopLiquidationPrice returns the liquidation price of the leg that carries the
+// price exposure of the loop: the deposit asset of a long and the borrowed asset of a short.
+func ComputeLoopLiquidationPrice(bankAccountService BankAccountService, banks map[string]*Bank, changedbankAccounts []*BankAccountWrapper, priceFeedMgr PriceAdapterMgr, accountId uuid.UUID, opts *LoopPaymentOptions) (decimal.Decimal, error) {
+	if opts == nil {
+		return decimal.Zero, ErrLoopOptionsMissing
+	}
+
+	bankId := opts.DepositBankId
+	if opts.Type == LoopPaymentTypeShort {
+		bankId = opts.BorrowBankId
+	}
+	return ComputeLiquidationPriceForBank(bankAccountService, banks, changedbankAccounts, priceFeedMgr, accountId, bankId, Maintenance)
+}
+
+// ComputeLoopLeverage returns the current leverage of a position from the USD value of its legs
+func ComputeLoopLeverage(loopType LoopPaymentType, depositValue, borrowValue decimal.Decimal) (decimal.Decimal, error) {
+	equity := depositValue.Sub(borrowValue)
+	if !equity.IsPositive() {
+		return decimal.Zero, ErrInsufficientBalance
+	}
+
+	if loopType == LoopPaymentTypeShort {
+		return borrowValue.Div(equity), nil
+	}
+	return depositValue.Div(equity), nil
+}
+
+// ComputeLoopAdjustBorrowValue returns the change of the borrowed USD value that moves the position
+// to targetLeverage, positive when levering up and negative when deleveraging
+func ComputeLoopAdjustBorrowValue(loopType LoopPaymentType, depositValue, borrowValue, targetLeverage decimal.Decimal) (decimal.Decimal, error) {
+	if !loopType.ValidLeverage(targetLeverage) {
+		return decimal.Zero, InvalidAction
+	}
+
+	equity := depositValue.Sub(borrowValue)
+	if !equity.IsPositive() {
+		return decimal.Zero, ErrInsufficientBalance
+	}
+
+	targetBorrowValue := equity.Mul(loopType.borrowValueMultiplier(targetLeverage))
+	return targetBorrowValue.Sub(borrowValue), nil
+}
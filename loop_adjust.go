@@ -0,0 +1,161 @@
+package core
+
+import (
+	"context"
+
+	"github.com/gofrs/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// PlanLoopAdjust plans the steps that move the looped position of the payment account in groupId
+// to targetLeverage. Levering up borrows, swaps and deposits, deleveraging withdraws, swaps and repays.
+// The loop type is the one recorded when the position was opened, memoType is the type of the memo,
+// empty when it has none, and is rejected when it differs.
+func (e *LoopEngine) PlanLoopAdjust(ctx context.Context, payment *Payment, groupId uuid.UUID, memoType LoopPaymentType, targetLeverage decimal.Decimal) error {
+	if memoType != "" && !memoType.Valid() {
+		return InvalidAction
+	}
+
+	account, err := e.bankAccountService.GetAccountById(ctx, payment.AccountId)
+	if err != nil {
+		return err
+	}
+
+	depositAccount, borrowAccount, err := e.findPosition(ctx, account, groupId)
+	if err != nil {
+		return err
+	}
+	if borrowAccount == nil {
+		return ErrPositionNotFound
+	}
+	depositBank, borrowBank := depositAccount.Bank, borrowAccount.Bank
+
+	loopType, err := FindLoopPositionType(ctx, e.clk, e.operateStore, account, depositBank.Id, borrowBank.Id)
+	if err != nil {
+		return err
+	}
+	switch {
+	case loopType == "" && memoType != "":
+		// the position was not opened by a loop, the memo decides
+		loopType = memoType
+	case loopType == "":
+		loopType = LoopPaymentTypeLong
+	case memoType != "" && memoType != loopType:
+		return ErrLoopTypeMismatch
+	}
+
+	depositPrice, err := e.getPrice(depositBank)
+	if err != nil {
+		return err
+	}
+	borrowPrice, err := e.getPrice(borrowBank)
+	if err != nil {
+		return err
+	}
+	if !depositPrice.IsPositive() || !borrowPrice.IsPositive() {
+		return InvalidPrice
+	}
+
+	collateral, _ := depositAccount.Balance.ComputeQuantity(depositBank)
+	_, debt := borrowAccount.Balance.ComputeQuantity(borrowBank)
+	depositValue := collateral.Mul(depositPrice)
+	borrowValue := debt.Mul(borrowPrice)
+
+	deltaBorrowValue, err := ComputeLoopAdjustBorrowValue(loopType, depositValue, borrowValue, targetLeverage)
+	if err != nil {
+		return err
+	}
+
+	opts := &LoopPaymentOptions{
+		Type:           loopType,
+		TargetLeverage: targetLeverage,
+		DepositBankId:  depositBank.Id,
+		BorrowBankId:   borrowBank.Id,
+	}
+
+	switch {
+	case deltaBorrowValue.IsPositive():
+		if targetLeverage.GreaterThan(ComputeLoopMaxLeverage(loopType, depositBank, borrowBank)) {
+			return ErrLoopLeverageTooHigh
+		}
+
+		borrowAmount := deltaBorrowValue.Div(borrowPrice).Truncate(8)
+		opts.LoopStep2 = NewLoopPaymentStep(MATBorrow, borrowBank.Id, borrowAmount)
+		opts.LoopStep3 = NewLoopPaymentStep3(borrowBank.Id, depositBank.Id, "", SwapResponseView{})
+		opts.LoopStep4 = NewLoopPaymentStep(MATSupply, depositBank.Id, deltaBorrowValue.Div(depositPrice).Truncate(8))
+	case deltaBorrowValue.IsNegative():
+		repayValue := deltaBorrowValue.Neg()
+		withdrawAmount := decimal.Min(repayValue.Div(depositPrice).Truncate(8), collateral.Truncate(8))
+		opts.LoopStep2 = NewLoopPaymentStep(MATWithdraw, depositBank.Id, withdrawAmount)
+		opts.LoopStep3 = NewLoopPaymentStep3(depositBank.Id, borrowBank.Id, "", SwapResponseView{})
+		opts.LoopStep4 = NewLoopPaymentStep(MATRepay, borrowBank.Id, repayValue.Div(borrowPrice).Truncate(8))
+	default:
+		return InvalidAction
+	}
+
+	WithLoopOptions(opts)(payment)
+	return e.paymentStore.UpsertPayment(ctx, payment)
+}
+
+// ExecuteLoopAdjust advances a planned loop adjustment, it resumes like Execute. The surplus of a
+// deleverage repay is saved on the payment before it is refunded.
+// Only an increase of leverage has to leave the account above its initial requirement, it is
+// checked before anything is borrowed.
+func (e *LoopEngine) ExecuteLoopAdjust(ctx context.Context, payment *Payment) error {
+	opts := payment.Extra.LoopOptions
+	if opts == nil || opts.LoopStep2 == nil || opts.LoopStep3 == nil || opts.LoopStep4 == nil {
+		return ErrLoopOptionsMissing
+	}
+	if payment.Status != PaymentStatusPending {
+		return nil
+	}
+	if opts.LoopStep3.State == PaymentStatusFailed {
+		return e.Unwind(ctx, payment, loopUnwindCause(opts))
+	}
+
+	account, err := e.lockAccount(ctx, payment.AccountId)
+	if err != nil {
+		return err
+	}
+
+	if opts.LoopStep2.Action == MATBorrow && opts.LoopStep2.State == PaymentStatusPending {
+		if err := e.checkLoopInitHealth(ctx, account, opts); err != nil {
+			return e.Unwind(ctx, payment, err)
+		}
+	}
+
+	if err := e.executeUntilSwapSettled(ctx, payment, account); err != nil {
+		return err
+	}
+
+	if step := opts.LoopStep4; step.State == PaymentStatusPending {
+		if step.Action != MATRepay {
+			if err := e.executeStep(ctx, payment, account, step); err != nil {
+				return err
+			}
+		} else {
+			// the swap may return more than the outstanding debt, the surplus goes back to the user
+			refundAmount, err := e.repayPosition(ctx, payment, account, step.BankId, step.Amount)
+			if err != nil {
+				return err
+			}
+			step.State = PaymentStatusConfirmed
+			opts.Refund = &LoopPaymentRefund{BankId: step.BankId, Amount: refundAmount, State: PaymentStatusPending}
+			if err := e.paymentStore.UpsertPayment(ctx, payment); err != nil {
+				return err
+			}
+		}
+	}
+
+	if refund := opts.Refund; refund != nil && refund.State == PaymentStatusPending {
+		if err := e.refund(ctx, payment, refund.BankId, refund.Amount); err != nil {
+			return err
+		}
+		refund.State = PaymentStatusConfirmed
+		if err := e.paymentStore.UpsertPayment(ctx, payment); err != nil {
+			return err
+		}
+	}
+
+	return e.finalize(ctx, payment, account)
+}
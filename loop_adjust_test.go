@@ -0,0 +1,97 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/facebookgo/clock"
+	"github.com/gofrs/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoopEnginePlanLoopAdjustUsesPositionType(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewMock()
+	store, btc, usdt, account := newLoopTest()
+	engine := store.engine(clk)
+
+	// a short of 1 btc against 300 usdt, opened by a MATLoop
+	lender := uuid.Must(uuid.NewV4())
+	btc.TotalAssetShares = decimal.NewFromInt(100)
+	store.balances = append(store.balances, &Balance{AccountId: lender, BankId: btc.Id, Active: true, AssetShares: decimal.NewFromInt(100), LiabilityShares: decimal.Zero})
+	openLoopPosition(store, account, usdt, btc, decimal.NewFromInt(300), ONE)
+	loop := newLoopPayment(clk, account, MATLoop)
+	WithLoopOptions(&LoopPaymentOptions{
+		Type:      LoopPaymentTypeShort,
+		LoopStep1: NewLoopPaymentStep(MATSupply, usdt.Id, decimal.NewFromInt(200)),
+		LoopStep2: NewLoopPaymentStep(MATBorrow, btc.Id, ONE),
+	})(loop)
+	store.operates = append(store.operates, NewOperate(clk, account.PubKey, account.Id, MATLoop, loop.OperationDetail()))
+
+	payment := newLoopPayment(clk, account, MATLoopAdjust)
+	assert.ErrorIs(t, engine.PlanLoopAdjust(ctx, payment, uuid.Nil, LoopPaymentTypeLong, decimal.NewFromFloat(1.5)), ErrLoopTypeMismatch)
+
+	// a memo without a type adjusts the short, a long at 1.5 would not borrow anything
+	assert.NoError(t, engine.PlanLoopAdjust(ctx, payment, uuid.Nil, "", decimal.NewFromFloat(1.5)))
+	opts := payment.Extra.LoopOptions
+	assert.Equal(t, LoopPaymentTypeShort, opts.Type)
+	assert.Equal(t, MATBorrow, opts.LoopStep2.Action)
+	assert.Equal(t, btc.Id, opts.LoopStep2.BankId)
+	assert.True(t, opts.LoopStep2.Amount.Equal(decimal.NewFromInt(2)))
+}
+
+func TestLoopEngineLoopAdjustRejectsBelowInitialHealth(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewMock()
+	store, btc, usdt, account := newLoopTest()
+	openLoopPosition(store, account, btc, usdt, decimal.NewFromInt(3), decimal.NewFromInt(200))
+	engine := store.engine(clk)
+
+	payment := newLoopPayment(clk, account, MATLoopAdjust)
+	assert.NoError(t, engine.PlanLoopAdjust(ctx, payment, uuid.Nil, "", decimal.NewFromInt(4)))
+	opts := payment.Extra.LoopOptions
+	assert.True(t, opts.LoopStep2.Amount.Equal(decimal.NewFromInt(100)))
+
+	store.prices["btc"] = decimal.NewFromInt(90)
+	assert.ErrorIs(t, engine.ExecuteLoopAdjust(ctx, payment), RiskEngineInitRejected)
+
+	assert.Equal(t, PaymentStatusFailed, payment.Status)
+	assert.Empty(t, store.transfers)
+	_, debt := store.quantity(account.Id, usdt.Id)
+	assert.True(t, debt.Equal(decimal.NewFromInt(200)))
+}
+
+func TestLoopEngineLoopAdjustRetriesRefund(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewMock()
+	store, btc, usdt, account := newLoopTest()
+	openLoopPosition(store, account, btc, usdt, decimal.NewFromInt(3), decimal.NewFromInt(200))
+	engine := store.engine(clk)
+
+	payment := newLoopPayment(clk, account, MATLoopAdjust)
+	assert.NoError(t, engine.PlanLoopAdjust(ctx, payment, uuid.Nil, "", decimal.NewFromFloat(1.5)))
+	opts := payment.Extra.LoopOptions
+	assert.Equal(t, MATWithdraw, opts.LoopStep2.Action)
+	assert.ErrorIs(t, engine.ExecuteLoopAdjust(ctx, payment), ErrSwapPending)
+
+	// btc rallies before the swap settles, the swap returns 210 usdt for a debt of 200
+	store.prices["btc"] = decimal.NewFromInt(140)
+	store.settleSwap(payment, SwapOrderStateSuccess)
+	store.transferErr = errors.New("transfer failed")
+	assert.ErrorIs(t, engine.ExecuteLoopAdjust(ctx, payment), store.transferErr)
+	assert.Equal(t, PaymentStatusPending, payment.Status)
+	assert.Equal(t, PaymentStatusConfirmed, opts.LoopStep4.State)
+	assert.True(t, opts.Refund.Amount.Equal(decimal.NewFromInt(10)))
+
+	store.transferErr = nil
+	assert.NoError(t, engine.ExecuteLoopAdjust(ctx, payment))
+	assert.Equal(t, PaymentStatusConfirmed, payment.Status)
+	assert.Equal(t, PaymentStatusConfirmed, opts.Refund.State)
+	assert.Len(t, store.transfers, 2)
+	assert.Equal(t, "refund", store.transfers[1].memo)
+	assert.True(t, store.transfers[1].amount.Equal(decimal.NewFromInt(10)))
+	_, debt := store.quantity(account.Id, usdt.Id)
+	assert.True(t, debt.IsZero())
+}
@@ -0,0 +1,280 @@
+package core
+
+import (
+	"context"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+)
+
+// PlanClosePosition finds the looped position of the payment account in groupId and plans the
+// first unwind round: withdraw enough collateral, swap it to the borrowed asset and repay.
+func (e *LoopEngine) PlanClosePosition(ctx context.Context, payment *Payment, groupId uuid.UUID) error {
+	account, err := e.bankAccountService.GetAccountById(ctx, payment.AccountId)
+	if err != nil {
+		return err
+	}
+
+	depositAccount, borrowAccount, err := e.findPosition(ctx, account, groupId)
+	if err != nil {
+		return err
+	}
+
+	opts := &LoopPaymentOptions{
+		Type:          LoopPaymentTypeLong,
+		DepositBankId: depositAccount.Bank.Id,
+	}
+	result := &ClosePositionResult{
+		GroupId:                  groupId,
+		DepositBankId:            depositAccount.Bank.Id,
+		RefundBorrowAssetAmount:  decimal.Zero,
+		RefundDepositAssetAmount: decimal.Zero,
+		WithdrawnAmount:          decimal.Zero,
+		RepaidAmount:             decimal.Zero,
+	}
+
+	if borrowAccount != nil {
+		opts.BorrowBankId = borrowAccount.Bank.Id
+		result.BorrowBankId = borrowAccount.Bank.Id
+		if err := e.planCloseRound(opts, depositAccount, borrowAccount); err != nil {
+			return err
+		}
+	}
+
+	WithLoopOptions(opts)(payment)
+	WithClosePositionResult(result)(payment)
+	return e.paymentStore.UpsertPayment(ctx, payment)
+}
+
+// ExecuteClosePosition advances a planned close position. Every round repays as much debt as the
+// swap returned, a new round is planned until the debt is cleared. The remaining collateral and
+// any surplus of the borrowed asset are then paid back to the user.
+func (e *LoopEngine) ExecuteClosePosition(ctx context.Context, payment *Payment) error {
+	opts := payment.Extra.LoopOptions
+	result := payment.Extra.ClosePositionResult
+	if opts == nil || result == nil {
+		return ErrLoopOptionsMissing
+	}
+	if payment.Status != PaymentStatusPending {
+		return nil
+	}
+
+	account, err := e.lockAccount(ctx, payment.AccountId)
+	if err != nil {
+		return err
+	}
+
+	for opts.LoopStep2 != nil {
+		if err := e.executeUntilSwapSettled(ctx, payment, account); err != nil {
+			return err
+		}
+
+		if step := opts.LoopStep4; step.State == PaymentStatusPending {
+			refundAmount, err := e.repayPosition(ctx, payment, account, step.BankId, step.Amount)
+			if err != nil {
+				step.Message = err.Error()
+				if perr := e.paymentStore.UpsertPayment(ctx, payment); perr != nil {
+					return perr
+				}
+				return err
+			}
+
+			step.State = PaymentStatusConfirmed
+			step.Message = ""
+			result.RefundBorrowAssetAmount = result.RefundBorrowAssetAmount.Add(refundAmount)
+			result.WithdrawnAmount = result.WithdrawnAmount.Add(opts.LoopStep2.Amount)
+			result.RepaidAmount = result.RepaidAmount.Add(step.Amount.Sub(refundAmount))
+			if opts.LoopStep3.OrderId != "" {
+				result.SwapOrderIds = append(result.SwapOrderIds, opts.LoopStep3.OrderId)
+			}
+			if err := e.paymentStore.UpsertPayment(ctx, payment); err != nil {
+				return err
+			}
+		}
+
+		depositAccount, borrowAccount, err := e.findPosition(ctx, account, result.GroupId)
+		if err != nil {
+			if errors.Is(err, ErrPositionNotFound) {
+				return e.failClosePosition(ctx, payment, account, err)
+			}
+			return err
+		}
+		if borrowAccount == nil {
+			break
+		}
+		if err := e.planCloseRound(opts, depositAccount, borrowAccount); err != nil {
+			return e.failClosePosition(ctx, payment, account, err)
+		}
+		if err := e.paymentStore.UpsertPayment(ctx, payment); err != nil {
+			return err
+		}
+	}
+
+	if result.RefundDepositAssetAmount.IsZero() {
+		amount, err := e.withdrawAllPosition(ctx, payment, account, result.DepositBankId)
+		if err != nil {
+			return err
+		}
+		result.RefundDepositAssetAmount = amount
+		if err := e.paymentStore.UpsertPayment(ctx, payment); err != nil {
+			return err
+		}
+	}
+
+	if err := e.refund(ctx, payment, result.DepositBankId, result.RefundDepositAssetAmount); err != nil {
+		return err
+	}
+	if err := e.refund(ctx, payment, result.BorrowBankId, result.RefundBorrowAssetAmount); err != nil {
+		return err
+	}
+
+	return e.finalize(ctx, payment, account)
+}
+
+// planCloseRound replaces steps 2 to 4 with a withdraw of the collateral needed to cover the
+// outstanding debt plus slippage, the swap and the repay.
+func (e *LoopEngine) planCloseRound(opts *LoopPaymentOptions, depositAccount, borrowAccount *BankAccountWrapper) error {
+	depositPrice, err := e.getPrice(depositAccount.Bank)
+	if err != nil {
+		return err
+	}
+	borrowPrice, err := e.getPrice(borrowAccount.Bank)
+	if err != nil {
+		return err
+	}
+	if !depositPrice.IsPositive() {
+		return InvalidPrice
+	}
+
+	debt, err := borrowAccount.Bank.GetLiabilityAmount(borrowAccount.Balance.LiabilityShares)
+	if err != nil {
+		return err
+	}
+	collateral, err := depositAccount.Bank.GetAssetAmount(depositAccount.Balance.AssetShares)
+	if err != nil {
+		return err
+	}
+
+	withdrawAmount := debt.Mul(borrowPrice).Div(depositPrice).Mul(ONE.Add(e.slippage)).RoundCeil(8)
+	withdrawAmount = decimal.Min(withdrawAmount, collateral.Truncate(8))
+	if !withdrawAmount.IsPositive() {
+		return ErrInsufficientBalance
+	}
+
+	opts.LoopStep2 = NewLoopPaymentStep(MATWithdraw, depositAccount.Bank.Id, withdrawAmount)
+	opts.LoopStep3 = NewLoopPaymentStep3(depositAccount.Bank.Id, borrowAccount.Bank.Id, "", SwapResponseView{})
+	opts.LoopStep4 = NewLoopPaymentStep(MATRepay, borrowAccount.Bank.Id, debt)
+	return nil
+}
+
+// findPosition returns the single asset balance and the optional single liability balance of
+// the account in groupId
+func (e *LoopEngine) findPosition(ctx context.Context, account *Account, groupId uuid.UUID) (*BankAccountWrapper, *BankAccountWrapper, error) {
+	return FindLoopPosition(ctx, e.clk, e.log, e.bankAccountService, account, groupId)
+}
+
+// repayPosition repays the debt with amount and returns the surplus. The balance is closed
+// with RepayAll when amount covers the debt, otherwise it is repaid partially.
+func (e *LoopEngine) repayPosition(ctx context.Context, payment *Payment, account *Account, bankId uuid.UUID, amount decimal.Decimal) (decimal.Decimal, error) {
+	bank, err := e.bankAccountService.GetBankById(ctx, bankId)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	ledger := e.newLedger(payment, account)
+	if err := e.accrueInterest(bank, ledger); err != nil {
+		return decimal.Zero, err
+	}
+
+	ba, err := FindBankAccountWrapper(ctx, e.bankAccountService, bank, account, WithClock(e.clk), WithLedger(ledger))
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	probe := NewBankAccountWrapper(ba.Balance.Clone(), ba.Bank.Clone(), WithClock(e.clk))
+	repayAllAmount, err := probe.RepayAll(e.log)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	refundAmount := decimal.Zero
+	if amount.GreaterThanOrEqual(repayAllAmount) {
+		if _, err := ba.RepayAll(e.log); err != nil {
+			return decimal.Zero, err
+		}
+		refundAmount = amount.Sub(repayAllAmount)
+	} else if err := ba.Repay(e.log, amount); err != nil {
+		return decimal.Zero, err
+	}
+	recordLoopTransfer(ledger, bank, MATRepay, amount.Sub(refundAmount))
+
+	if err := e.bankAccountStore.StorageBankAccount(ctx, ba); err != nil {
+		return decimal.Zero, err
+	}
+	if err := e.commitLedger(ctx, ledger); err != nil {
+		return decimal.Zero, err
+	}
+	return refundAmount, nil
+}
+
+func (e *LoopEngine) withdrawAllPosition(ctx context.Context, payment *Payment, account *Account, bankId uuid.UUID) (decimal.Decimal, error) {
+	bank, err := e.bankAccountService.GetBankById(ctx, bankId)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	ledger := e.newLedger(payment, account)
+	if err := e.accrueInterest(bank, ledger); err != nil {
+		return decimal.Zero, err
+	}
+
+	ba, err := FindBankAccountWrapper(ctx, e.bankAccountService, bank, account, WithClock(e.clk), WithLedger(ledger))
+	if err != nil {
+		return decimal.Zero, err
+	}
+	if !ba.Balance.Active || ba.Balance.IsEmpty(BalanceSideAssets) {
+		return decimal.Zero, nil
+	}
+
+	amount, err := ba.WithdrawAll(e.log)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	recordLoopTransfer(ledger, bank, MATWithdraw, amount)
+	if err := e.bankAccountStore.StorageBankAccount(ctx, ba); err != nil {
+		return decimal.Zero, err
+	}
+	if err := e.commitLedger(ctx, ledger); err != nil {
+		return decimal.Zero, err
+	}
+	return amount, nil
+}
+
+// newLedger returns the ledger of the payment, or nil when the engine has no LedgerStore
+func (e *LoopEngine) newLedger(payment *Payment, account *Account) *Ledger {
+	if e.ledgerStore == nil {
+		return nil
+	}
+	return NewLedger(e.clk, payment.RequestId, account.Id)
+}
+
+func (e *LoopEngine) commitLedger(ctx context.Context, ledger *Ledger) error {
+	if ledger == nil {
+		return nil
+	}
+	return ledger.Commit(ctx, e.ledgerStore)
+}
+
+func (e *LoopEngine) failClosePosition(ctx context.Context, payment *Payment, account *Account, cause error) error {
+	e.log.Error().Msgf("Close position %s stopped: %s", payment.RequestId, cause)
+
+	account.UnsetFlag(InFlashloanFlag)
+	if err := e.bankAccountService.UpsertAccount(ctx, account); err != nil {
+		return err
+	}
+
+	payment.UpdateStatus(e.clk, PaymentStatusFailed, cause.Error())
+	if err := e.paymentStore.UpsertPayment(ctx, payment); err != nil {
+		return err
+	}
+	return cause
+}
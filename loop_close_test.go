@@ -0,0 +1,133 @@
+package core
+
+import (
+	"context"
+	"testing"
+
+	"github.com/facebookgo/clock"
+	"github.com/gofrs/uuid"
+	"github.com/rs/zerolog"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+// openLoopPosition books collateral on depositBank and debt on borrowBank for the account
+func openLoopPosition(store *loopStore, account *Account, depositBank, borrowBank *Bank, collateral, debt decimal.Decimal) {
+	depositBank.TotalAssetShares = depositBank.TotalAssetShares.Add(collateral)
+	borrowBank.TotalLiabilityShares = borrowBank.TotalLiabilityShares.Add(debt)
+	store.balances = append(store.balances,
+		&Balance{AccountId: account.Id, BankId: depositBank.Id, Active: true, AssetShares: collateral, LiabilityShares: decimal.Zero},
+		&Balance{AccountId: account.Id, BankId: borrowBank.Id, Active: true, AssetShares: decimal.Zero, LiabilityShares: debt},
+	)
+}
+
+func TestLoopEngineClosePosition(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewMock()
+	store, btc, usdt, account := newLoopTest()
+	openLoopPosition(store, account, btc, usdt, decimal.NewFromInt(3), decimal.NewFromInt(200))
+	engine := store.engine(clk)
+
+	payment := newLoopPayment(clk, account, MATDomeLoopClosePosition)
+	assert.NoError(t, engine.PlanClosePosition(ctx, payment, uuid.Nil))
+	opts := payment.Extra.LoopOptions
+	assert.True(t, opts.LoopStep2.Amount.Equal(decimal.NewFromFloat(2.02)))
+
+	assert.ErrorIs(t, engine.ExecuteClosePosition(ctx, payment), ErrSwapPending)
+	store.settleSwap(payment, SwapOrderStateSuccess)
+	assert.NoError(t, engine.ExecuteClosePosition(ctx, payment))
+
+	assert.Equal(t, PaymentStatusConfirmed, payment.Status)
+	collateral, _ := store.quantity(account.Id, btc.Id)
+	_, debt := store.quantity(account.Id, usdt.Id)
+	assert.True(t, collateral.IsZero())
+	assert.True(t, debt.IsZero())
+
+	result := payment.Extra.ClosePositionResult
+	assert.True(t, result.RefundDepositAssetAmount.Equal(decimal.NewFromFloat(0.98)))
+	assert.True(t, result.RefundBorrowAssetAmount.Equal(decimal.NewFromInt(2)))
+	// the swap payment and one refund of each asset
+	assert.Len(t, store.transfers, 3)
+
+	// the operate records everything the close took out of the position
+	detail := payment.OperationDetail()
+	assert.Len(t, detail.Actions, 2)
+	assert.Equal(t, MATWithdraw, detail.Actions[0].ActionType)
+	assert.True(t, detail.Actions[0].Amount.Equal(decimal.NewFromInt(3)))
+	assert.Equal(t, MATRepay, detail.Actions[1].ActionType)
+	assert.True(t, detail.Actions[1].Amount.Equal(decimal.NewFromInt(200)))
+	assert.Equal(t, []string{opts.LoopStep3.OrderId}, detail.SwapOrderIds)
+}
+
+type journalStore struct {
+	LedgerStore
+	journals []*Journal
+}
+
+func (s *journalStore) CreateJournal(ctx context.Context, journal *Journal) error {
+	s.journals = append(s.journals, journal)
+	return nil
+}
+
+func TestLoopEngineClosePositionLedger(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewMock()
+	log := zerolog.Nop()
+	store, btc, usdt, account := newLoopTest()
+	// the repaid debt is rounded up, the dust goes to the insurance fees
+	openLoopPosition(store, account, btc, usdt, decimal.NewFromInt(3), decimal.RequireFromString("200.000001"))
+	ledgerStore := &journalStore{}
+	engine := NewLoopEngine(clk, &log, "payer", store.service(), store, store, store, store, store.prices, store, store, WithLoopLedgerStore(ledgerStore))
+
+	payment := newLoopPayment(clk, account, MATDomeLoopClosePosition)
+	assert.NoError(t, engine.PlanClosePosition(ctx, payment, uuid.Nil))
+	assert.ErrorIs(t, engine.ExecuteClosePosition(ctx, payment), ErrSwapPending)
+	store.settleSwap(payment, SwapOrderStateSuccess)
+	assert.NoError(t, engine.ExecuteClosePosition(ctx, payment))
+
+	// the collateral withdrawn for the swap, the dust and the repay, then the rest of the collateral
+	kinds := []LedgerKind{}
+	for _, journal := range ledgerStore.journals {
+		assert.Equal(t, payment.RequestId, journal.RequestId)
+		assert.True(t, journal.IsBalanced())
+		kinds = append(kinds, journal.Kind)
+	}
+	assert.Equal(t, []LedgerKind{LedgerKindWithdrawTransfer, LedgerKindRoundingDust, LedgerKindDepositTransfer, LedgerKindWithdrawTransfer}, kinds)
+	assert.True(t, ledgerStore.journals[2].Entries[0].Debit.Equal(decimal.RequireFromString("200.00001")))
+	assert.True(t, ledgerStore.journals[3].Entries[0].Debit.Equal(decimal.RequireFromString("0.97999998")))
+
+	journal := ledgerStore.journals[1]
+	assert.Equal(t, usdt.Id, journal.BankId)
+	assert.Equal(t, LedgerKindRoundingDust, journal.Kind)
+	assert.True(t, journal.IsBalanced())
+	assert.True(t, journal.Entries[0].Debit.Equal(decimal.RequireFromString("0.000009")))
+	assert.Equal(t, LedgerAccountInsuranceFeesOutstanding, journal.Entries[0].Account)
+	// the ledger is only attached to the bank while the wrapper works on it
+	assert.Nil(t, usdt.ledger)
+}
+
+func TestLoopEngineClosePositionSwapFailed(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewMock()
+	store, btc, usdt, account := newLoopTest()
+	openLoopPosition(store, account, btc, usdt, decimal.NewFromInt(3), decimal.NewFromInt(200))
+	engine := store.engine(clk)
+
+	payment := newLoopPayment(clk, account, MATDomeLoopClosePosition)
+	assert.NoError(t, engine.PlanClosePosition(ctx, payment, uuid.Nil))
+	assert.ErrorIs(t, engine.ExecuteClosePosition(ctx, payment), ErrSwapPending)
+
+	// the withdrawn collateral goes back to the position when the swap fails
+	store.settleSwap(payment, SwapOrderStateFailed)
+	assert.ErrorIs(t, engine.ExecuteClosePosition(ctx, payment), ErrSwapFailed)
+
+	assert.Equal(t, PaymentStatusFailed, payment.Status)
+	assert.Equal(t, PaymentStatusFailed, payment.Extra.LoopOptions.LoopStep2.State)
+	assert.False(t, store.accounts[account.Id].GetFlag(InFlashloanFlag))
+	collateral, _ := store.quantity(account.Id, btc.Id)
+	_, debt := store.quantity(account.Id, usdt.Id)
+	assert.True(t, collateral.Equal(decimal.NewFromInt(3)))
+	assert.True(t, debt.Equal(decimal.NewFromInt(200)))
+	// only the swap payment, nothing is refunded
+	assert.Len(t, store.transfers, 1)
+}
@@ -0,0 +1,597 @@
+package core
+
+import (
+	"context"
+
+	"github.com/DomeLiquid/core/utils"
+	"github.com/facebookgo/clock"
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// LoopEngine drives the steps of a LoopPaymentOptions:
+// step1 user deposit, step2 borrow, step3 swap, step4 deposit of the swap output.
+// A long loop deposits the volatile asset and borrows the stable one, a short loop
+// deposits the stable asset and borrows the volatile one, the steps are the same.
+// Progress is persisted on the payment after every step so Execute can resume after a restart.
+type LoopEngine struct {
+	clk clock.Clock
+	log Log
+
+	payer    string
+	slippage decimal.Decimal
+
+	bankAccountService BankAccountService
+	bankAccountStore   BankAccountWrapperStore
+	paymentStore       PaymentStore
+	orderStore         MixinOracleStore
+	operateStore       OperateStore
+	priceFeedMgr       PriceAdapterMgr
+	swapService        SwapService
+	transferService    TransferService
+	ledgerStore        LedgerStore
+}
+
+type LoopEngineOptionFunc func(e *LoopEngine)
+
+func WithLoopSlippage(slippage decimal.Decimal) LoopEngineOptionFunc {
+	return func(e *LoopEngine) {
+		e.slippage = slippage
+	}
+}
+
+// WithLoopLedgerStore records the steps of every loop payment and the interest fees accrued on
+// the way in the ledger
+func WithLoopLedgerStore(ledgerStore LedgerStore) LoopEngineOptionFunc {
+	return func(e *LoopEngine) {
+		e.ledgerStore = ledgerStore
+	}
+}
+
+func NewLoopEngine(
+	clk clock.Clock,
+	log Log,
+	payer string,
+	bankAccountService BankAccountService,
+	bankAccountStore BankAccountWrapperStore,
+	paymentStore PaymentStore,
+	orderStore MixinOracleStore,
+	operateStore OperateStore,
+	priceFeedMgr PriceAdapterMgr,
+	swapService SwapService,
+	transferService TransferService,
+	opts ...LoopEngineOptionFunc,
+) *LoopEngine {
+	e := &LoopEngine{
+		clk:                clk,
+		log:                log,
+		payer:              payer,
+		slippage:           DEFAULT_SWAP_SLIPPAGE,
+		bankAccountService: bankAccountService,
+		bankAccountStore:   bankAccountStore,
+		paymentStore:       paymentStore,
+		orderStore:         orderStore,
+		operateStore:       operateStore,
+		priceFeedMgr:       priceFeedMgr,
+		swapService:        swapService,
+		transferService:    transferService,
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// PlanLoop computes the step amounts for a new loop and stores them on the payment
+func (e *LoopEngine) PlanLoop(ctx context.Context, payment *Payment, opts *LoopPaymentOptions) error {
+	if opts == nil {
+		return ErrLoopOptionsMissing
+	}
+	if opts.Type == "" {
+		opts.Type = LoopPaymentTypeLong
+	}
+	if !opts.Type.Valid() {
+		return InvalidAction
+	}
+
+	depositBank, err := e.bankAccountService.GetBankById(ctx, opts.DepositBankId)
+	if err != nil {
+		return err
+	}
+	borrowBank, err := e.bankAccountService.GetBankById(ctx, opts.BorrowBankId)
+	if err != nil {
+		return err
+	}
+
+	if opts.TargetLeverage.GreaterThan(ComputeLoopMaxLeverage(opts.Type, depositBank, borrowBank)) {
+		return ErrLoopLeverageTooHigh
+	}
+
+	depositPrice, err := e.getPrice(depositBank)
+	if err != nil {
+		return err
+	}
+	borrowPrice, err := e.getPrice(borrowBank)
+	if err != nil {
+		return err
+	}
+
+	borrowAmount, err := ComputeLoopBorrowAmount(opts.Type, opts.DepositAmount, opts.TargetLeverage, depositPrice, borrowPrice)
+	if err != nil {
+		return err
+	}
+	expectedOutAmount := borrowAmount.Mul(borrowPrice).Div(depositPrice).Truncate(8)
+
+	opts.LoopStep1 = NewLoopPaymentStep(MATSupply, depositBank.Id, opts.DepositAmount)
+	opts.LoopStep2 = NewLoopPaymentStep(MATBorrow, borrowBank.Id, borrowAmount)
+	opts.LoopStep3 = NewLoopPaymentStep3(borrowBank.Id, depositBank.Id, "", SwapResponseView{})
+	opts.LoopStep4 = NewLoopPaymentStep(MATSupply, depositBank.Id, expectedOutAmount)
+
+	WithLoopOptions(opts)(payment)
+	return e.paymentStore.UpsertPayment(ctx, payment)
+}
+
+// Execute advances the loop as far as possible. It returns ErrSwapPending while the swap
+// order is in flight and should be called again once the order settles.
+func (e *LoopEngine) Execute(ctx context.Context, payment *Payment) error {
+	opts := payment.Extra.LoopOptions
+	if opts == nil || opts.LoopStep2 == nil || opts.LoopStep3 == nil || opts.LoopStep4 == nil {
+		return ErrLoopOptionsMissing
+	}
+	if payment.Status != PaymentStatusPending {
+		return nil
+	}
+	if opts.LoopStep3.State == PaymentStatusFailed {
+		return e.Unwind(ctx, payment, loopUnwindCause(opts))
+	}
+
+	account, err := e.lockAccount(ctx, payment.AccountId)
+	if err != nil {
+		return err
+	}
+
+	if opts.LoopStep2.State == PaymentStatusPending {
+		if err := e.checkLoopInitHealth(ctx, account, opts); err != nil {
+			return e.Unwind(ctx, payment, err)
+		}
+	}
+
+	if err := e.executeUntilSwapSettled(ctx, payment, account); err != nil {
+		return err
+	}
+
+	// the swap has settled, a failed final step is kept pending so it can be retried
+	if opts.LoopStep4.State == PaymentStatusPending {
+		if err := e.executeStep(ctx, payment, account, opts.LoopStep4); err != nil {
+			return err
+		}
+	}
+
+	return e.finalize(ctx, payment, account)
+}
+
+// checkLoopInitHealth applies the pending ledger steps of the loop to copies of the banks and
+// balances and rejects the loop when the account would end below its initial requirement. The
+// swap output is taken at the lowest amount the slippage check accepts.
+func (e *LoopEngine) checkLoopInitHealth(ctx context.Context, account *Account, opts *LoopPaymentOptions) error {
+	steps := []*LoopPaymentStep{opts.LoopStep1, opts.LoopStep2}
+	if step := opts.LoopStep4; step != nil {
+		minOutStep := *step
+		minOutStep.Amount = step.Amount.Mul(ONE.Sub(e.slippage)).Truncate(8)
+		steps = append(steps, &minOutStep)
+	}
+
+	simulated := map[uuid.UUID]*BankAccountWrapper{}
+	bankAccounts := []*BankAccountWrapper{}
+	for _, step := range steps {
+		if step == nil || step.State != PaymentStatusPending {
+			continue
+		}
+
+		ba, ok := simulated[step.BankId]
+		if !ok {
+			bank, err := e.bankAccountService.GetBankById(ctx, step.BankId)
+			if err != nil {
+				return err
+			}
+			balance, err := e.bankAccountService.FindBalance(ctx, bank.Id, account.Id)
+			if err != nil || !balance.Active {
+				balance = NewBalance(e.clk, account.Id, bank.Id)
+			} else {
+				balance = balance.Clone()
+			}
+			ba = NewBankAccountWrapper(balance, bank.Clone(), WithClock(e.clk), WithAccount(account))
+			simulated[step.BankId] = ba
+			bankAccounts = append(bankAccounts, ba)
+		}
+		if err := applyLoopAction(e.log, ba, step.Action, step.Amount); err != nil {
+			return err
+		}
+	}
+
+	riskEngine, err := NewRiskEngineNoFlashloanCheck(ctx, e.bankAccountService, account, bankAccounts, e.priceFeedMgr)
+	if err != nil {
+		return err
+	}
+	return riskEngine.CheckAccountHealth(Initial)
+}
+
+// executeUntilSwapSettled runs step1 and step2 and then the swap, unwinding the loop
+// when the swap cannot go ahead. On success step4 carries the received amount.
+func (e *LoopEngine) executeUntilSwapSettled(ctx context.Context, payment *Payment, account *Account) error {
+	opts := payment.Extra.LoopOptions
+
+	for _, step := range []*LoopPaymentStep{opts.LoopStep1, opts.LoopStep2} {
+		if step == nil || step.State != PaymentStatusPending {
+			continue
+		}
+		if err := e.executeStep(ctx, payment, account, step); err != nil {
+			return e.Unwind(ctx, payment, err)
+		}
+	}
+
+	if opts.LoopStep3.State == PaymentStatusPending {
+		receiveAmount, err := e.executeSwap(ctx, payment, opts.LoopStep3, opts.LoopStep2.Amount)
+		if err != nil {
+			if errors.Is(err, ErrSwapPending) {
+				return err
+			}
+			// nothing has been paid to the swap yet, or the swap refunded the input
+			if opts.LoopStep3.State == PaymentStatusFailed || opts.LoopStep3.SwapResponseView.Tx == "" {
+				return e.Unwind(ctx, payment, err)
+			}
+			return err
+		}
+
+		opts.LoopStep4.Amount = receiveAmount
+		if err := e.paymentStore.UpsertPayment(ctx, payment); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Unwind reverts the ledger steps of a loop whose swap has not settled, refunds the
+// user deposit and marks the payment failed with cause. Step3 and step4 are failed and saved
+// before anything is reverted, an unwind that stops halfway is resumed by Execute.
+func (e *LoopEngine) Unwind(ctx context.Context, payment *Payment, cause error) error {
+	opts := payment.Extra.LoopOptions
+	if opts == nil {
+		return ErrLoopOptionsMissing
+	}
+
+	if step := opts.LoopStep3; step != nil {
+		if step.State == PaymentStatusConfirmed {
+			return ErrLoopAlreadySettled
+		}
+		if step.OrderId != "" && step.State == PaymentStatusPending {
+			return ErrSwapPending
+		}
+		step.State = PaymentStatusFailed
+	}
+	if step := opts.LoopStep4; step != nil && step.State == PaymentStatusPending {
+		step.State = PaymentStatusFailed
+		step.Message = cause.Error()
+	}
+	if err := e.paymentStore.UpsertPayment(ctx, payment); err != nil {
+		return err
+	}
+
+	account, err := e.bankAccountService.GetAccountById(ctx, payment.AccountId)
+	if err != nil {
+		return err
+	}
+
+	e.log.Warn().Msgf("Unwinding %s %s: %s", payment.Action, payment.RequestId, cause)
+
+	if err := e.revertStep(ctx, payment, account, opts.LoopStep2); err != nil {
+		return err
+	}
+	if err := e.revertStep(ctx, payment, account, opts.LoopStep1); err != nil {
+		return err
+	}
+	if step := opts.LoopStep1; step != nil && step.Action == MATSupply {
+		if err := e.refund(ctx, payment, step.BankId, step.Amount); err != nil {
+			return err
+		}
+	}
+
+	account.UnsetFlag(InFlashloanFlag)
+	if err := e.bankAccountService.UpsertAccount(ctx, account); err != nil {
+		return err
+	}
+
+	payment.UpdateStatus(e.clk, PaymentStatusFailed, cause.Error())
+	if err := e.paymentStore.UpsertPayment(ctx, payment); err != nil {
+		return err
+	}
+	return cause
+}
+
+// loopUnwindCause returns the cause saved on step4 by an unwind that did not finish
+func loopUnwindCause(opts *LoopPaymentOptions) error {
+	if step := opts.LoopStep4; step != nil && step.Message != "" {
+		return errors.New(step.Message)
+	}
+	return ErrHandleLoopFailed
+}
+
+// lockAccount puts the account in flashloan mode until the loop settles, so the
+// intermediate state is neither health checked nor open to other operations
+func (e *LoopEngine) lockAccount(ctx context.Context, accountId uuid.UUID) (*Account, error) {
+	account, err := e.bankAccountService.GetAccountById(ctx, accountId)
+	if err != nil {
+		return nil, err
+	}
+	if account.GetFlag(ClosedFlag) {
+		return nil, AccountClosed
+	}
+
+	if !account.GetFlag(InFlashloanFlag) {
+		account.SetFlag(InFlashloanFlag)
+		if err := e.bankAccountService.UpsertAccount(ctx, account); err != nil {
+			return nil, err
+		}
+	}
+	return account, nil
+}
+
+// finalize releases the account and confirms the payment, the health is checked before the
+// first step by checkLoopInitHealth
+func (e *LoopEngine) finalize(ctx context.Context, payment *Payment, account *Account) error {
+	account.UnsetFlag(InFlashloanFlag)
+	if err := e.bankAccountService.UpsertAccount(ctx, account); err != nil {
+		return err
+	}
+
+	payment.UpdateStatus(e.clk, PaymentStatusConfirmed, "")
+	return e.paymentStore.UpsertPayment(ctx, payment)
+}
+
+func (e *LoopEngine) executeStep(ctx context.Context, payment *Payment, account *Account, step *LoopPaymentStep) error {
+	if _, err := e.applyStep(ctx, payment, account, step.Action, step.BankId, step.Amount); err != nil {
+		step.Message = err.Error()
+		if perr := e.paymentStore.UpsertPayment(ctx, payment); perr != nil {
+			return perr
+		}
+		return err
+	}
+
+	step.State = PaymentStatusConfirmed
+	step.Message = ""
+	return e.paymentStore.UpsertPayment(ctx, payment)
+}
+
+func (e *LoopEngine) revertStep(ctx context.Context, payment *Payment, account *Account, step *LoopPaymentStep) error {
+	if step == nil {
+		return nil
+	}
+	if step.State != PaymentStatusConfirmed {
+		step.State = PaymentStatusFailed
+		return nil
+	}
+
+	// the step is only marked reverted once the reverse is on the books, a failed reverse keeps it
+	// confirmed so a resumed unwind retries it
+	reverse := reverseLoopStep(step)
+	if _, err := e.applyStep(ctx, payment, account, reverse.Action, reverse.BankId, reverse.Amount); err != nil {
+		step.Message = err.Error()
+		if perr := e.paymentStore.UpsertPayment(ctx, payment); perr != nil {
+			return perr
+		}
+		return err
+	}
+
+	step.State = PaymentStatusFailed
+	step.Message = ""
+	return e.paymentStore.UpsertPayment(ctx, payment)
+}
+
+// applyStep books the step on the balance of the account. With a ledger the interest fees of the
+// accrual and the step itself are journaled under the payment.
+func (e *LoopEngine) applyStep(ctx context.Context, payment *Payment, account *Account, action MemoActionType, bankId uuid.UUID, amount decimal.Decimal) (*BankAccountWrapper, error) {
+	bank, err := e.bankAccountService.GetBankById(ctx, bankId)
+	if err != nil {
+		return nil, err
+	}
+	ledger := e.newLedger(payment, account)
+	if err := e.accrueInterest(bank, ledger); err != nil {
+		return nil, err
+	}
+
+	ba, err := FindOrCreateBankAccountWrapper(ctx, e.clk, e.bankAccountService, bank, account, WithLedger(ledger))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := applyLoopAction(e.log, ba, action, amount); err != nil {
+		return nil, err
+	}
+	recordLoopTransfer(ledger, bank, action, amount)
+
+	if err := e.bankAccountStore.StorageBankAccount(ctx, ba); err != nil {
+		return nil, err
+	}
+	if err := e.commitLedger(ctx, ledger); err != nil {
+		return nil, err
+	}
+	return ba, nil
+}
+
+// accrueInterest accrues the interest of bank with ledger attached, so the interest fees are
+// journaled with the payment that triggered the accrual
+func (e *LoopEngine) accrueInterest(bank *Bank, ledger *Ledger) error {
+	previous := bank.ledger
+	bank.AttachLedger(ledger)
+	defer bank.AttachLedger(previous)
+	return bank.AccrueInterest(e.log, e.clk.Now().Unix())
+}
+
+// recordLoopTransfer journals the funds of a step moving between the user and the liquidity vault
+func recordLoopTransfer(ledger *Ledger, bank *Bank, action MemoActionType, amount decimal.Decimal) {
+	switch action {
+	case MATSupply, MATRepay:
+		ledger.Transfer(bank, LedgerKindDepositTransfer, LedgerAccountUser, LedgerAccountLiquidityVault, amount)
+	case MATBorrow, MATWithdraw:
+		ledger.Transfer(bank, LedgerKindWithdrawTransfer, LedgerAccountLiquidityVault, LedgerAccountUser, amount)
+	}
+}
+
+func applyLoopAction(log Log, ba *BankAccountWrapper, action MemoActionType, amount decimal.Decimal) error {
+	switch action {
+	case MATSupply:
+		return ba.Deposit(log, amount)
+	case MATBorrow:
+		return ba.Borrow(log, amount)
+	case MATRepay:
+		return ba.Repay(log, amount)
+	case MATWithdraw:
+		return ba.Withdraw(log, amount)
+	default:
+		return InvalidAction
+	}
+}
+
+// executeSwap quotes, creates and pays the swap order of step, then waits for it to settle.
+// It returns the received amount once the order succeeded.
+func (e *LoopEngine) executeSwap(ctx context.Context, payment *Payment, step *LoopPaymentStep3, amount decimal.Decimal) (decimal.Decimal, error) {
+	inputBank, err := e.bankAccountService.GetBankById(ctx, step.InputBankId)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	outputBank, err := e.bankAccountService.GetBankById(ctx, step.OutputBankId)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	if step.SwapResponseView.Tx == "" {
+		quote, err := e.swapService.Quote(ctx, QuoteRequest{
+			InputMint:  inputBank.MixinSafeAssetId,
+			OutputMint: outputBank.MixinSafeAssetId,
+			Amount:     amount.String(),
+		})
+		if err != nil {
+			return decimal.Zero, err
+		}
+		if err := e.checkSlippage(inputBank, outputBank, amount, quote); err != nil {
+			return decimal.Zero, err
+		}
+
+		resp, err := e.swapService.Swap(ctx, SwapRequest{
+			Payer:       e.payer,
+			InputMint:   inputBank.MixinSafeAssetId,
+			InputAmount: amount.String(),
+			OutputMint:  outputBank.MixinSafeAssetId,
+			Payload:     quote.Payload,
+		})
+		if err != nil {
+			return decimal.Zero, err
+		}
+
+		step.SwapResponseView = *resp
+		if err := e.paymentStore.UpsertPayment(ctx, payment); err != nil {
+			return decimal.Zero, err
+		}
+	}
+
+	if step.OrderId == "" {
+		tx, err := step.SwapResponseView.DecodeTx()
+		if err != nil {
+			return decimal.Zero, err
+		}
+		// the trace comes from the swap response, so retrying the transfer is idempotent
+		if err := e.transferService.Transfer(ctx, tx.Trace, tx.Payee, tx.Asset, amount, tx.Memo); err != nil {
+			return decimal.Zero, err
+		}
+
+		step.OrderId = tx.OrderId
+		if err := e.paymentStore.UpsertPayment(ctx, payment); err != nil {
+			return decimal.Zero, err
+		}
+		return decimal.Zero, ErrSwapPending
+	}
+
+	order, err := e.orderStore.GetMixinOrderByOrderId(ctx, step.OrderId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return decimal.Zero, ErrSwapPending
+		}
+		return decimal.Zero, err
+	}
+
+	switch order.State {
+	case SwapOrderStateSuccess:
+		step.State = PaymentStatusConfirmed
+		return order.ReceiveAmount, nil
+	case SwapOrderStateFailed:
+		step.State = PaymentStatusFailed
+		return decimal.Zero, ErrSwapFailed
+	default:
+		return decimal.Zero, ErrSwapPending
+	}
+}
+
+func (e *LoopEngine) checkSlippage(inputBank, outputBank *Bank, amount decimal.Decimal, quote *QuoteResponseView) error {
+	outAmount, err := decimal.NewFromString(quote.OutAmount)
+	if err != nil {
+		return err
+	}
+
+	inputPrice, err := e.getPrice(inputBank)
+	if err != nil {
+		return err
+	}
+	outputPrice, err := e.getPrice(outputBank)
+	if err != nil {
+		return err
+	}
+	if !outputPrice.IsPositive() {
+		return InvalidPrice
+	}
+
+	expectedOutAmount := amount.Mul(inputPrice).Div(outputPrice)
+	if outAmount.LessThan(expectedOutAmount.Mul(ONE.Sub(e.slippage))) {
+		return ErrSwapSlippageExceeded
+	}
+	return nil
+}
+
+func (e *LoopEngine) getPrice(bank *Bank) (decimal.Decimal, error) {
+	priceAdapter, err := e.priceFeedMgr.GetPriceAdapter(bank)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return priceAdapter.GetPriceOfType(RealTime, Original)
+}
+
+func (e *LoopEngine) refund(ctx context.Context, payment *Payment, bankId uuid.UUID, amount decimal.Decimal) error {
+	if !amount.IsPositive() {
+		return nil
+	}
+
+	bank, err := e.bankAccountService.GetBankById(ctx, bankId)
+	if err != nil {
+		return err
+	}
+
+	requestId := utils.GenUuidFromStrings(payment.RequestId, bankId.String(), "refund")
+	return e.transferService.Transfer(ctx, requestId, payment.Uid, bank.MixinSafeAssetId, amount, "refund")
+}
+
+func reverseLoopStep(step *LoopPaymentStep) *LoopPaymentStep {
+	action := step.Action
+	switch step.Action {
+	case MATSupply:
+		action = MATWithdraw
+	case MATWithdraw:
+		action = MATSupply
+	case MATBorrow:
+		action = MATRepay
+	case MATRepay:
+		action = MATBorrow
+	}
+	return NewLoopPaymentStep(action, step.BankId, step.Amount)
+}
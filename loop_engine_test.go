@@ -0,0 +1,396 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/clock"
+	"github.com/gofrs/uuid"
+	"github.com/rs/zerolog"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+type transferRecord struct {
+	requestId string
+	assetId   string
+	amount    decimal.Decimal
+	memo      string
+}
+
+type loopStore struct {
+	scannerStore
+	BankAccountWrapperStore
+	PaymentStore
+	MixinOracleStore
+	OperateStore
+
+	prices    ratesPriceFeedMgr
+	operates  []Operate
+	orders    map[string]*SwapOrder
+	transfers []transferRecord
+	// transferErr fails every transfer while set
+	transferErr error
+}
+
+func newLoopStore(banks ...*Bank) *loopStore {
+	store := &loopStore{
+		scannerStore: scannerStore{group: &Group{}, accounts: map[uuid.UUID]*Account{}},
+		prices:       ratesPriceFeedMgr{},
+		orders:       map[string]*SwapOrder{},
+	}
+	store.banks = banks
+	return store
+}
+
+func (s *loopStore) service() BankAccountService {
+	return BankAccountService{BalanceStore: s, BankStore: s, AccountStore: s, GroupStore: s}
+}
+
+func (s *loopStore) engine(clk clock.Clock) *LoopEngine {
+	log := zerolog.Nop()
+	return NewLoopEngine(clk, &log, "payer", s.service(), s, s, s, s, s.prices, s, s)
+}
+
+func (s *loopStore) ListAccountOperates(ctx context.Context, accountId uuid.UUID, op MemoActionType, createdBeforeAt int64, beforeId uuid.UUID, limit int64) ([]Operate, error) {
+	sorted := append([]Operate{}, s.operates...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].CreatedAt != sorted[j].CreatedAt {
+			return sorted[i].CreatedAt > sorted[j].CreatedAt
+		}
+		return sorted[i].Id.String() > sorted[j].Id.String()
+	})
+
+	operates := []Operate{}
+	for _, operate := range sorted {
+		before := operate.CreatedAt < createdBeforeAt || (operate.CreatedAt == createdBeforeAt && operate.Id.String() < beforeId.String())
+		if operate.AccountId == accountId && operate.Op == op && before && int64(len(operates)) < limit {
+			operates = append(operates, operate)
+		}
+	}
+	return operates, nil
+}
+
+func (s *loopStore) FindBalance(ctx context.Context, bankId, accountId uuid.UUID) (*Balance, error) {
+	for _, balance := range s.balances {
+		if balance.BankId == bankId && balance.AccountId == accountId {
+			return balance, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (s *loopStore) UpsertBalance(ctx context.Context, balance *Balance) error {
+	if existing, _ := s.FindBalance(ctx, balance.BankId, balance.AccountId); existing == nil {
+		s.balances = append(s.balances, balance)
+	}
+	return nil
+}
+
+func (s *loopStore) UpsertAccount(ctx context.Context, account *Account) error {
+	s.accounts[account.Id] = account
+	return nil
+}
+
+func (s *loopStore) GetAccountById(ctx context.Context, accountId uuid.UUID) (*Account, error) {
+	if account, ok := s.accounts[accountId]; ok {
+		return account, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (s *loopStore) ListAccountByPubkey(ctx context.Context, groupId uuid.UUID, pubkey string) ([]*Account, error) {
+	accounts := []*Account{}
+	for _, account := range s.accounts {
+		if account.GroupId == groupId && account.PubKey == pubkey && !account.GetFlag(ClosedFlag) {
+			accounts = append(accounts, account)
+		}
+	}
+	return accounts, nil
+}
+
+func (s *loopStore) CreateAccount(ctx context.Context, account *Account) error {
+	s.accounts[account.Id] = account
+	return nil
+}
+
+func (s *loopStore) GetAccountByPubkey(ctx context.Context, groupId uuid.UUID, pubkey string, index uint8) (*Account, error) {
+	for _, account := range s.accounts {
+		if account.GroupId == groupId && account.PubKey == pubkey && account.Index == index && !account.GetFlag(ClosedFlag) {
+			return account, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (s *loopStore) CreateOperate(ctx context.Context, operate *Operate) error {
+	s.operates = append(s.operates, *operate)
+	return nil
+}
+
+func (s *loopStore) StorageBankAccount(ctx context.Context, bankAccount *BankAccountWrapper) error {
+	return nil
+}
+
+func (s *loopStore) UpsertPayment(ctx context.Context, payment *Payment) error {
+	return nil
+}
+
+func (s *loopStore) GetMixinOrderByOrderId(ctx context.Context, orderId string) (*SwapOrder, error) {
+	if order, ok := s.orders[orderId]; ok {
+		return order, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (s *loopStore) Quote(ctx context.Context, req QuoteRequest) (*QuoteResponseView, error) {
+	amount, err := decimal.NewFromString(req.Amount)
+	if err != nil {
+		return nil, err
+	}
+	out := amount.Mul(s.prices[req.InputMint]).Div(s.prices[req.OutputMint])
+	return &QuoteResponseView{InputMint: req.InputMint, InAmount: req.Amount, OutputMint: req.OutputMint, OutAmount: out.String()}, nil
+}
+
+func (s *loopStore) Swap(ctx context.Context, req SwapRequest) (*SwapResponseView, error) {
+	orderId := fmt.Sprintf("order-%d", len(s.orders)+len(s.transfers))
+	trace := uuid.Must(uuid.NewV4()).String()
+	return &SwapResponseView{
+		Tx: fmt.Sprintf("mixin://mixin.one/pay/swapper?asset=%s&amount=%s&memo=%s&trace=%s", req.InputMint, req.InputAmount, orderId, trace),
+	}, nil
+}
+
+func (s *loopStore) Transfer(ctx context.Context, requestId string, opponentId string, assetId string, amount decimal.Decimal, memo string) error {
+	if s.transferErr != nil {
+		return s.transferErr
+	}
+	s.transfers = append(s.transfers, transferRecord{requestId: requestId, assetId: assetId, amount: amount, memo: memo})
+	return nil
+}
+
+// settleSwap fills the pending swap order of the payment at the current prices
+func (s *loopStore) settleSwap(payment *Payment, state SwapOrderState) {
+	step := payment.Extra.LoopOptions.LoopStep3
+	var input, output *Bank
+	for _, bank := range s.banks {
+		if bank.Id == step.InputBankId {
+			input = bank
+		}
+		if bank.Id == step.OutputBankId {
+			output = bank
+		}
+	}
+	tx, _ := step.SwapResponseView.DecodeTx()
+	amount, _ := decimal.NewFromString(tx.Amount)
+	s.orders[step.OrderId] = &SwapOrder{
+		OrderId:       step.OrderId,
+		State:         state,
+		ReceiveAmount: amount.Mul(s.prices[input.MixinSafeAssetId]).Div(s.prices[output.MixinSafeAssetId]).Truncate(8),
+	}
+}
+
+func (s *loopStore) quantity(accountId, bankId uuid.UUID) (decimal.Decimal, decimal.Decimal) {
+	balance, err := s.FindBalance(context.Background(), bankId, accountId)
+	if err != nil {
+		return decimal.Zero, decimal.Zero
+	}
+	bank, _ := s.GetBankById(context.Background(), bankId)
+	return balance.ComputeQuantity(bank)
+}
+
+func newLoopBank(assetId string, assetWeightInit, assetWeightMaint float64) *Bank {
+	return &Bank{
+		Id:                  uuid.Must(uuid.NewV4()),
+		MixinSafeAssetId:    assetId,
+		AssetShareValue:     ONE,
+		LiabilityShareValue: ONE,
+		BankConfig: BankConfig{
+			AssetWeightInit:      decimal.NewFromFloat(assetWeightInit),
+			AssetWeightMaint:     decimal.NewFromFloat(assetWeightMaint),
+			LiabilityWeightInit:  ONE,
+			LiabilityWeightMaint: ONE,
+			DepositLimit:         decimal.NewFromInt(1000000),
+			LiabilityLimit:       decimal.NewFromInt(1000000),
+			OperationalState:     BankOperationalStateOperational,
+			RiskTier:             Collateral,
+		},
+	}
+}
+
+// newLoopTest returns a store with a btc deposit bank and a usdt borrow bank, the usdt bank is
+// funded by another account
+func newLoopTest() (*loopStore, *Bank, *Bank, *Account) {
+	btc, usdt := newLoopBank("btc", 0.8, 0.9), newLoopBank("usdt", 0.9, 0.95)
+	store := newLoopStore(btc, usdt)
+	store.prices["btc"], store.prices["usdt"] = decimal.NewFromInt(100), ONE
+
+	lender := uuid.Must(uuid.NewV4())
+	usdt.TotalAssetShares = decimal.NewFromInt(10000)
+	store.balances = append(store.balances, &Balance{AccountId: lender, BankId: usdt.Id, Active: true, AssetShares: decimal.NewFromInt(10000), LiabilityShares: decimal.Zero})
+
+	account := &Account{Id: uuid.Must(uuid.NewV4()), PubKey: "user"}
+	store.accounts[account.Id] = account
+	return store, btc, usdt, account
+}
+
+func newLoopPayment(clk clock.Clock, account *Account, action MemoActionType) *Payment {
+	return NewPayment(clk, uuid.Must(uuid.NewV4()).String(), "user", uuid.Nil, account.Id, action, decimal.Zero, "")
+}
+
+func TestLoopEngineExecute(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewMock()
+	store, btc, usdt, account := newLoopTest()
+	engine := store.engine(clk)
+
+	payment := newLoopPayment(clk, account, MATLoop)
+	opts := &LoopPaymentOptions{DepositBankId: btc.Id, BorrowBankId: usdt.Id, DepositAmount: ONE, TargetLeverage: decimal.NewFromInt(3)}
+	assert.NoError(t, engine.PlanLoop(ctx, payment, opts))
+	assert.True(t, opts.LoopStep2.Amount.Equal(decimal.NewFromInt(200)))
+
+	assert.ErrorIs(t, engine.Execute(ctx, payment), ErrSwapPending)
+	store.settleSwap(payment, SwapOrderStateSuccess)
+	assert.NoError(t, engine.Execute(ctx, payment))
+
+	assert.Equal(t, PaymentStatusConfirmed, payment.Status)
+	assert.False(t, store.accounts[account.Id].GetFlag(InFlashloanFlag))
+	collateral, _ := store.quantity(account.Id, btc.Id)
+	_, debt := store.quantity(account.Id, usdt.Id)
+	assert.True(t, collateral.Equal(decimal.NewFromInt(3)))
+	assert.True(t, debt.Equal(decimal.NewFromInt(200)))
+}
+
+func TestLoopEngineLedger(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewMock()
+	log := zerolog.Nop()
+	store, btc, usdt, account := newLoopTest()
+	// another borrower owes usdt, so the accrual of the borrow step collects a fee
+	usdt.TotalLiabilityShares = decimal.NewFromInt(1000)
+	usdt.InterestRateConfig = InterestRateConfig{
+		OptimalUtilizationRate: decimal.NewFromFloat(0.8),
+		PlateauInterestRate:    decimal.NewFromFloat(0.1),
+		MaxInterestRate:        ONE,
+		ProtocolFixedFeeApr:    decimal.NewFromFloat(0.01),
+	}
+	ledgerStore := &journalStore{}
+	engine := NewLoopEngine(clk, &log, "payer", store.service(), store, store, store, store, store.prices, store, store, WithLoopLedgerStore(ledgerStore))
+
+	payment := newLoopPayment(clk, account, MATLoop)
+	opts := &LoopPaymentOptions{DepositBankId: btc.Id, BorrowBankId: usdt.Id, DepositAmount: ONE, TargetLeverage: decimal.NewFromInt(3)}
+	assert.NoError(t, engine.PlanLoop(ctx, payment, opts))
+	clk.Add(24 * time.Hour)
+	assert.ErrorIs(t, engine.Execute(ctx, payment), ErrSwapPending)
+	store.settleSwap(payment, SwapOrderStateSuccess)
+	assert.NoError(t, engine.Execute(ctx, payment))
+
+	// the deposit, the interest fee and the borrow, then the supply of the swap output
+	kinds := []LedgerKind{}
+	for _, journal := range ledgerStore.journals {
+		assert.Equal(t, payment.RequestId, journal.RequestId)
+		assert.True(t, journal.IsBalanced())
+		kinds = append(kinds, journal.Kind)
+	}
+	assert.Equal(t, []LedgerKind{LedgerKindDepositTransfer, LedgerKindInterestFees, LedgerKindWithdrawTransfer, LedgerKindDepositTransfer}, kinds)
+	supply, fee, borrow := ledgerStore.journals[0], ledgerStore.journals[1], ledgerStore.journals[2]
+	assert.Equal(t, btc.Id, supply.BankId)
+	assert.True(t, supply.Entries[0].Debit.Equal(ONE))
+	assert.Equal(t, LedgerAccountLiquidityVault, supply.Entries[0].Account)
+	assert.Equal(t, account.Id, supply.Entries[1].AccountId)
+	assert.Equal(t, usdt.Id, fee.BankId)
+	assert.Equal(t, LedgerAccountGroupFeesOutstanding, fee.Entries[0].Account)
+	assert.True(t, fee.Entries[0].Debit.IsPositive())
+	assert.True(t, borrow.Entries[0].Debit.Equal(decimal.NewFromInt(200)))
+	assert.Equal(t, LedgerAccountUser, borrow.Entries[0].Account)
+	assert.True(t, ledgerStore.journals[3].Entries[0].Debit.Equal(decimal.NewFromInt(2)))
+	assert.Nil(t, usdt.ledger)
+}
+
+func TestLoopEngineRejectsBelowInitialHealth(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewMock()
+	store, btc, usdt, account := newLoopTest()
+	engine := store.engine(clk)
+
+	payment := newLoopPayment(clk, account, MATLoop)
+	opts := &LoopPaymentOptions{DepositBankId: btc.Id, BorrowBankId: usdt.Id, DepositAmount: ONE, TargetLeverage: decimal.NewFromInt(3)}
+	assert.NoError(t, engine.PlanLoop(ctx, payment, opts))
+
+	// the price moves after planning, the loop would open below the initial requirement
+	store.prices["btc"] = decimal.NewFromInt(80)
+	assert.ErrorIs(t, engine.Execute(ctx, payment), RiskEngineInitRejected)
+
+	assert.Equal(t, PaymentStatusFailed, payment.Status)
+	assert.Equal(t, PaymentStatusFailed, opts.LoopStep1.State)
+	assert.Equal(t, PaymentStatusFailed, opts.LoopStep2.State)
+	_, debt := store.quantity(account.Id, usdt.Id)
+	assert.True(t, debt.IsZero())
+	assert.Len(t, store.transfers, 1)
+	assert.Equal(t, "refund", store.transfers[0].memo)
+	assert.True(t, store.transfers[0].amount.Equal(ONE))
+}
+
+func TestLoopEngineUnwindRetriesFailedRevert(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewMock()
+	store, btc, usdt, account := newLoopTest()
+	engine := store.engine(clk)
+
+	payment := newLoopPayment(clk, account, MATLoop)
+	opts := &LoopPaymentOptions{DepositBankId: btc.Id, BorrowBankId: usdt.Id, DepositAmount: ONE, TargetLeverage: decimal.NewFromInt(2)}
+	assert.NoError(t, engine.PlanLoop(ctx, payment, opts))
+	assert.ErrorIs(t, engine.Execute(ctx, payment), ErrSwapPending)
+
+	// the swap fails while the borrow bank is paused, the borrow can't be repaid yet
+	store.settleSwap(payment, SwapOrderStateFailed)
+	usdt.OperationalState = BankOperationalStatePaused
+	assert.ErrorIs(t, engine.Execute(ctx, payment), BankPaused)
+	assert.Equal(t, PaymentStatusConfirmed, opts.LoopStep2.State)
+	assert.Equal(t, PaymentStatusPending, payment.Status)
+
+	usdt.OperationalState = BankOperationalStateOperational
+	assert.ErrorIs(t, engine.Unwind(ctx, payment, ErrSwapFailed), ErrSwapFailed)
+	assert.Equal(t, PaymentStatusFailed, opts.LoopStep2.State)
+	assert.Equal(t, PaymentStatusFailed, payment.Status)
+	_, debt := store.quantity(account.Id, usdt.Id)
+	assert.True(t, debt.IsZero())
+}
+
+func TestLoopEngineUnwindResumesAfterFailedRefund(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewMock()
+	store, btc, usdt, account := newLoopTest()
+	engine := store.engine(clk)
+
+	payment := newLoopPayment(clk, account, MATLoop)
+	opts := &LoopPaymentOptions{DepositBankId: btc.Id, BorrowBankId: usdt.Id, DepositAmount: ONE, TargetLeverage: decimal.NewFromInt(2)}
+	assert.NoError(t, engine.PlanLoop(ctx, payment, opts))
+	assert.ErrorIs(t, engine.Execute(ctx, payment), ErrSwapPending)
+
+	// the swap fails and so does the refund of the deposit
+	store.settleSwap(payment, SwapOrderStateFailed)
+	store.transferErr = errors.New("transfer failed")
+	assert.ErrorIs(t, engine.Execute(ctx, payment), store.transferErr)
+	assert.Equal(t, PaymentStatusPending, payment.Status)
+	assert.Equal(t, PaymentStatusFailed, opts.LoopStep3.State)
+	assert.Equal(t, PaymentStatusFailed, opts.LoopStep4.State)
+
+	// the retry finishes the unwind, step4 never supplies the planned swap output
+	store.transferErr = nil
+	assert.Error(t, engine.Execute(ctx, payment))
+	assert.Equal(t, PaymentStatusFailed, payment.Status)
+	assert.Equal(t, ErrSwapFailed.Error(), payment.Message)
+	collateral, _ := store.quantity(account.Id, btc.Id)
+	_, debt := store.quantity(account.Id, usdt.Id)
+	assert.True(t, collateral.IsZero())
+	assert.True(t, debt.IsZero())
+	assert.Len(t, store.transfers, 2)
+	assert.Equal(t, "refund", store.transfers[1].memo)
+	assert.True(t, store.transfers[1].amount.Equal(ONE))
+	assert.False(t, store.accounts[account.Id].GetFlag(InFlashloanFlag))
+}
@@ -0,0 +1,129 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComputeLoopMaxLeverage(t *testing.T) {
+	depositBank := &Bank{BankConfig: BankConfig{AssetWeightInit: decimal.NewFromFloat(0.8)}}
+	borrowBank := &Bank{BankConfig: BankConfig{LiabilityWeightInit: decimal.NewFromFloat(1.2)}}
+
+	result := ComputeLoopMaxLeverage(LoopPaymentTypeLong, depositBank, borrowBank)
+	assert.True(t, result.Equal(decimal.NewFromInt(3)), "expected 3, got %s", result)
+
+	result = ComputeLoopMaxLeverage(LoopPaymentTypeShort, depositBank, borrowBank)
+	assert.True(t, result.Equal(decimal.NewFromInt(2)), "expected 2, got %s", result)
+}
+
+func TestComputeLoopBorrowAmount(t *testing.T) {
+	tests := []struct {
+		name           string
+		loopType       LoopPaymentType
+		depositAmount  decimal.Decimal
+		targetLeverage decimal.Decimal
+		depositPrice   decimal.Decimal
+		borrowPrice    decimal.Decimal
+		expected       decimal.Decimal
+		wantErr        bool
+	}{
+		{
+			name:           "long",
+			loopType:       LoopPaymentTypeLong,
+			depositAmount:  decimal.NewFromInt(1),
+			targetLeverage: decimal.NewFromInt(3),
+			depositPrice:   decimal.NewFromInt(100),
+			borrowPrice:    decimal.NewFromInt(1),
+			expected:       decimal.NewFromInt(200),
+		},
+		{
+			name:           "short",
+			loopType:       LoopPaymentTypeShort,
+			depositAmount:  decimal.NewFromInt(100),
+			targetLeverage: decimal.NewFromInt(2),
+			depositPrice:   decimal.NewFromInt(1),
+			borrowPrice:    decimal.NewFromInt(50),
+			expected:       decimal.NewFromInt(4),
+		},
+		{
+			name:           "long leverage not above one",
+			loopType:       LoopPaymentTypeLong,
+			depositAmount:  decimal.NewFromInt(1),
+			targetLeverage: decimal.NewFromInt(1),
+			depositPrice:   decimal.NewFromInt(100),
+			borrowPrice:    decimal.NewFromInt(1),
+			wantErr:        true,
+		},
+		{
+			name:           "zero price",
+			loopType:       LoopPaymentTypeLong,
+			depositAmount:  decimal.NewFromInt(1),
+			targetLeverage: decimal.NewFromInt(2),
+			depositPrice:   decimal.NewFromInt(100),
+			borrowPrice:    decimal.Zero,
+			wantErr:        true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ComputeLoopBorrowAmount(tt.loopType, tt.depositAmount, tt.targetLeverage, tt.depositPrice, tt.borrowPrice)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.True(t, result.Equal(tt.expected), "expected %s, got %s", tt.expected, result)
+		})
+	}
+}
+
+func TestComputeLoopAdjustBorrowValue(t *testing.T) {
+	tests := []struct {
+		name           string
+		loopType       LoopPaymentType
+		depositValue   decimal.Decimal
+		borrowValue    decimal.Decimal
+		targetLeverage decimal.Decimal
+		expected       decimal.Decimal
+	}{
+		{
+			name:           "long increase",
+			loopType:       LoopPaymentTypeLong,
+			depositValue:   decimal.NewFromInt(200),
+			borrowValue:    decimal.NewFromInt(100),
+			targetLeverage: decimal.NewFromInt(3),
+			expected:       decimal.NewFromInt(100),
+		},
+		{
+			name:           "long decrease",
+			loopType:       LoopPaymentTypeLong,
+			depositValue:   decimal.NewFromInt(300),
+			borrowValue:    decimal.NewFromInt(200),
+			targetLeverage: decimal.NewFromInt(2),
+			expected:       decimal.NewFromInt(-100),
+		},
+		{
+			name:           "short increase",
+			loopType:       LoopPaymentTypeShort,
+			depositValue:   decimal.NewFromInt(200),
+			borrowValue:    decimal.NewFromInt(100),
+			targetLeverage: decimal.NewFromInt(2),
+			expected:       decimal.NewFromInt(100),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ComputeLoopAdjustBorrowValue(tt.loopType, tt.depositValue, tt.borrowValue, tt.targetLeverage)
+			assert.NoError(t, err)
+			assert.True(t, result.Equal(tt.expected), "expected %s, got %s", tt.expected, result)
+
+			leverage, err := ComputeLoopLeverage(tt.loopType, tt.depositValue.Add(result), tt.borrowValue.Add(result))
+			assert.NoError(t, err)
+			assert.True(t, leverage.Equal(tt.targetLeverage), "expected %s, got %s", tt.targetLeverage, leverage)
+		})
+	}
+}
@@ -96,6 +96,13 @@ const (
 	MATLoop
 	MATDomeLoopClosePosition // for dome loop
 	MATLiquidate             // TODO
+	MATLoopAdjust
+	MATTransferAuthority
+	MATAcceptAuthority
+	MATTransferCollateral
+	MATAccountClose
+	MATSetAccountFlag
+	MATUnsetAccountFlag
 	// MATWithdrawEmissions // SettleEmissions + Withdraw
 	// MATAccrueBankInterest
 	// MATWithdrawFees
@@ -104,9 +111,6 @@ const (
 	// MATCloseBalance
 	// MATSettleEmissions
 	// MATBankruptcy
-	// MATSetAccountFlag
-	// MATUnsetAccountFlag
-	// MATAccountClose // TODO
 )
 
 func (m MemoActionType) String() string {
@@ -125,6 +129,20 @@ func (m MemoActionType) String() string {
 		return "Loop"
 	case MATDomeLoopClosePosition:
 		return "Dome Loop Close Position"
+	case MATLoopAdjust:
+		return "Loop Adjust"
+	case MATTransferAuthority:
+		return "Transfer Authority"
+	case MATAcceptAuthority:
+		return "Accept Authority"
+	case MATTransferCollateral:
+		return "Transfer Collateral"
+	case MATAccountClose:
+		return "Account Close"
+	case MATSetAccountFlag:
+		return "Set Account Flag"
+	case MATUnsetAccountFlag:
+		return "Unset Account Flag"
 	// case MATWithdrawEmissions:
 	// 	return "Withdraw Emissions"
 	// case MATAccrueBankInterest:
@@ -154,6 +172,20 @@ func ValidActionTypeString(action string) (MemoActionType, bool) {
 		return MATLoop, true
 	case MATDomeLoopClosePosition.String():
 		return MATDomeLoopClosePosition, true
+	case MATLoopAdjust.String():
+		return MATLoopAdjust, true
+	case MATTransferAuthority.String():
+		return MATTransferAuthority, true
+	case MATAcceptAuthority.String():
+		return MATAcceptAuthority, true
+	case MATTransferCollateral.String():
+		return MATTransferCollateral, true
+	case MATAccountClose.String():
+		return MATAccountClose, true
+	case MATSetAccountFlag.String():
+		return MATSetAccountFlag, true
+	case MATUnsetAccountFlag.String():
+		return MATUnsetAccountFlag, true
 	// case MATWithdrawEmissions.String():
 	// 	return MATWithdrawEmissions, true
 	// case MATAccrueBankInterest.String():
@@ -175,7 +207,14 @@ func (m MemoActionType) Valid() bool {
 		MATBorrow,
 		MATLiquidate,
 		MATLoop,
-		MATDomeLoopClosePosition:
+		MATDomeLoopClosePosition,
+		MATLoopAdjust,
+		MATTransferAuthority,
+		MATAcceptAuthority,
+		MATTransferCollateral,
+		MATAccountClose,
+		MATSetAccountFlag,
+		MATUnsetAccountFlag:
 		// MATWithdrawEmissions,
 		// MATAccrueBankInterest,
 		// MATWithdrawFees,
@@ -236,6 +275,16 @@ type MemoActionClosePosition struct {
 	GroupId uuid.UUID `json:"g"`
 }
 
+func (m MemoActionClosePosition) Valid() bool {
+	if !m.MemoAction.Valid() {
+		return false
+	}
+	if m.ActionType != MATDomeLoopClosePosition {
+		return false
+	}
+	return m.GroupId != uuid.Nil
+}
+
 type MemoActionLiquidate struct {
 	MemoAction
 	BankId              uuid.UUID `json:"b"`
@@ -270,6 +319,15 @@ type MemoActionLoop struct {
 	BankId         uuid.UUID       `json:"b"`
 	BorrowBankId   uuid.UUID       `json:"bb"`
 	TargetLeverage decimal.Decimal `json:"tl"`
+	Type           LoopPaymentType `json:"lt,omitempty"`
+}
+
+// LoopType defaults to a long loop for memos without a type
+func (m MemoActionLoop) LoopType() LoopPaymentType {
+	if m.Type == "" {
+		return LoopPaymentTypeLong
+	}
+	return m.Type
 }
 
 func (m MemoActionLoop) Valid() bool {
@@ -288,19 +346,135 @@ func (m MemoActionLoop) Valid() bool {
 		return false
 	}
 
-	if m.ActionType != MATLoop {
+	if !m.LoopType().Valid() || !m.LoopType().ValidLeverage(m.TargetLeverage) {
 		return false
 	}
 
-	if m.AccountIndex != 0 {
+	return true
+}
+
+type MemoActionLoopAdjust struct {
+	MemoAction
+	GroupId        uuid.UUID       `json:"g"`
+	TargetLeverage decimal.Decimal `json:"tl"`
+	Type           LoopPaymentType `json:"lt,omitempty"`
+}
+
+func (m MemoActionLoopAdjust) LoopType() LoopPaymentType {
+	if m.Type == "" {
+		return LoopPaymentTypeLong
+	}
+	return m.Type
+}
+
+func (m MemoActionLoopAdjust) Valid() bool {
+	if !m.MemoAction.Valid() {
+		return false
+	}
+	if m.ActionType != MATLoopAdjust {
 		return false
 	}
+	if m.GroupId == uuid.Nil {
+		return false
+	}
+	if !m.LoopType().Valid() || !m.LoopType().ValidLeverage(m.TargetLeverage) {
+		return false
+	}
+	return true
+}
 
-	if !m.TargetLeverage.IsPositive() || m.TargetLeverage.LessThanOrEqual(ONE) {
+type MemoActionTransferAuthority struct {
+	MemoAction
+	GroupId   uuid.UUID `json:"g"`
+	NewPubKey string    `json:"np"`
+}
+
+func (m MemoActionTransferAuthority) Valid() bool {
+	if !m.MemoAction.Valid() {
 		return false
 	}
+	if m.ActionType != MATTransferAuthority {
+		return false
+	}
+	return m.GroupId != uuid.Nil && m.NewPubKey != ""
+}
 
-	return true
+type MemoActionAcceptAuthority struct {
+	MemoAction
+	AccountId uuid.UUID `json:"a"`
+}
+
+func (m MemoActionAcceptAuthority) Valid() bool {
+	if !m.MemoAction.Valid() {
+		return false
+	}
+	if m.ActionType != MATAcceptAuthority {
+		return false
+	}
+	return m.AccountId != uuid.Nil
+}
+
+// MemoActionTransferCollateral moves collateral from the sub-account at AccountIndex to the
+// sub-account at ToAccountIndex of the same owner
+type MemoActionTransferCollateral struct {
+	MemoAction
+	GroupId        uuid.UUID       `json:"g"`
+	ToAccountIndex uint8           `json:"ti"`
+	BankId         uuid.UUID       `json:"b"`
+	Amount         decimal.Decimal `json:"a"`
+}
+
+func (m MemoActionTransferCollateral) Valid() bool {
+	if !m.MemoAction.Valid() {
+		return false
+	}
+	if m.ActionType != MATTransferCollateral {
+		return false
+	}
+	if m.GroupId == uuid.Nil || m.BankId == uuid.Nil {
+		return false
+	}
+	if m.AccountIndex == m.ToAccountIndex {
+		return false
+	}
+	return m.Amount.IsPositive()
+}
+
+// MemoActionAccountClose closes the account at AccountIndex, every balance has to be empty
+type MemoActionAccountClose struct {
+	MemoAction
+	GroupId uuid.UUID `json:"g"`
+}
+
+func (m MemoActionAccountClose) Valid() bool {
+	if !m.MemoAction.Valid() {
+		return false
+	}
+	if m.ActionType != MATAccountClose {
+		return false
+	}
+	return m.GroupId != uuid.Nil
+}
+
+// MemoActionAccountFlag is sent by the group admin to set or unset Flag on an account
+type MemoActionAccountFlag struct {
+	MemoAction
+	GroupId   uuid.UUID    `json:"g"`
+	AccountId uuid.UUID    `json:"a"`
+	Flag      AccountFlags `json:"f"`
+}
+
+func (m MemoActionAccountFlag) Valid() bool {
+	if !m.MemoAction.Valid() {
+		return false
+	}
+	if m.ActionType != MATSetAccountFlag && m.ActionType != MATUnsetAccountFlag {
+		return false
+	}
+	if m.GroupId == uuid.Nil || m.AccountId == uuid.Nil {
+		return false
+	}
+	return ValidateAdminAccountFlag(m.Flag) == nil
 }
 
 func EncodeAnyMemo(a any) (string, error) {
@@ -17,6 +17,11 @@ type (
 		GetLastestMixinOrders(ctx context.Context, offset time.Time) ([]*SwapOrder, error)
 	}
 
+	SwapService interface {
+		Quote(ctx context.Context, req QuoteRequest) (*QuoteResponseView, error)
+		Swap(ctx context.Context, req SwapRequest) (*SwapResponseView, error)
+	}
+
 	MarketAssetInfo struct {
 		CoinID                       string          `json:"coin_id"`
 		Name                         string          `json:"name"`
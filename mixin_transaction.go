@@ -1,6 +1,10 @@
 package core
 
-import "context"
+import (
+	"context"
+
+	"github.com/shopspring/decimal"
+)
 
 type (
 	MixinTransactionStore interface {
@@ -9,6 +13,11 @@ type (
 		GetMixinTransaction(ctx context.Context, requestId string) (*MixinTransaction, error)
 	}
 
+	// TransferService sends assets out of the app, requestId must be idempotent
+	TransferService interface {
+		Transfer(ctx context.Context, requestId string, opponentId string, assetId string, amount decimal.Decimal, memo string) error
+	}
+
 	MixinTransaction struct {
 		RequestId string                 `json:"requestId"`
 		PaymentId string                 `json:"paymentId"`
@@ -14,9 +14,13 @@ type (
 	OperateStore interface {
 		CreateOperate(ctx context.Context, operate *Operate) error
 		ListOperates(ctx context.Context, pubKey string, op MemoActionType, createdBeforeAt, limit int64) ([]Operate, error)
+		// ListAccountOperates returns the operates of the account, newest first, ordered by
+		// (CreatedAt, Id) and strictly before the cursor (createdBeforeAt, beforeId)
+		ListAccountOperates(ctx context.Context, accountId uuid.UUID, op MemoActionType, createdBeforeAt int64, beforeId uuid.UUID, limit int64) ([]Operate, error)
 	}
 
 	Operate struct {
+		Id        uuid.UUID      `json:"id"`
 		PubKey    string         `json:"pubKey"`
 		AccountId uuid.UUID      `json:"accountId"`
 		Op        MemoActionType `json:"op"`
@@ -25,9 +29,25 @@ type (
 	}
 
 	OperateDetail struct {
-		Type      MemoActionType `json:"type"`
-		AccountId uuid.UUID      `json:"actor"`
-		Actions   []ActionDetail `json:"actions"`
+		Type         MemoActionType `json:"type"`
+		AccountId    uuid.UUID      `json:"actor"`
+		Actions      []ActionDetail `json:"actions"`
+		SwapOrderIds []string       `json:"swapOrderIds,omitempty"`
+		// LoopType is the type of a MATLoop or MATLoopAdjust
+		LoopType LoopPaymentType `json:"loopType,omitempty"`
+
+		// Emissions are the payouts settled by a MATAccountClose
+		Emissions []EmissionsPayout `json:"emissions,omitempty"`
+
+		Authority   *AuthorityDetail `json:"authority,omitempty"`
+		AccountFlag AccountFlags     `json:"accountFlag,omitempty"`
+	}
+
+	AuthorityDetail struct {
+		TransferId uuid.UUID                      `json:"transferId"`
+		FromPubKey string                         `json:"fromPubKey"`
+		ToPubKey   string                         `json:"toPubKey"`
+		Status     AccountAuthorityTransferStatus `json:"status,omitempty"`
 	}
 
 	ActionDetail struct {
@@ -40,6 +60,7 @@ type (
 
 func NewOperate(clk clock.Clock, pubKey string, accountId uuid.UUID, typ MemoActionType, extra OperateDetail) Operate {
 	return Operate{
+		Id:        uuid.Must(uuid.NewV4()),
 		PubKey:    pubKey,
 		AccountId: accountId,
 		Op:        typ,
@@ -60,8 +81,25 @@ func (j *OperateDetail) Scan(value any) error {
 	return nil
 }
 
+// AccountIds returns every account the actions move, the operate is stored once under each of
+// them so their position history sees it
+func (d OperateDetail) AccountIds() []uuid.UUID {
+	accountIds := []uuid.UUID{}
+	seen := map[uuid.UUID]bool{}
+	for _, action := range d.Actions {
+		if action.AccountId == uuid.Nil || seen[action.AccountId] {
+			continue
+		}
+		seen[action.AccountId] = true
+		accountIds = append(accountIds, action.AccountId)
+	}
+	return accountIds
+}
+
 func (p Payment) OperationDetail() OperateDetail {
 	actions := []ActionDetail{}
+	swapOrderIds := []string{}
+	var loopType LoopPaymentType
 	switch p.Action {
 	case MATLoop:
 		if p.Extra.LoopOptions == nil || p.Extra.LoopOptions.LoopStep1 == nil || p.Extra.LoopOptions.LoopStep2 == nil {
@@ -79,8 +117,55 @@ func (p Payment) OperationDetail() OperateDetail {
 			BankId:     p.Extra.LoopOptions.LoopStep2.BankId,
 			Amount:     p.Extra.LoopOptions.LoopStep2.Amount,
 		})
+		if step := p.Extra.LoopOptions.LoopStep4; step != nil && step.State == PaymentStatusConfirmed {
+			actions = append(actions, ActionDetail{
+				AccountId:  p.AccountId,
+				ActionType: step.Action,
+				BankId:     step.BankId,
+				Amount:     step.Amount,
+			})
+		}
+		if step := p.Extra.LoopOptions.LoopStep3; step != nil && step.OrderId != "" {
+			swapOrderIds = append(swapOrderIds, step.OrderId)
+		}
+		loopType = p.Extra.LoopOptions.Type
+	case MATLoopAdjust:
+		if p.Extra.LoopOptions == nil || p.Extra.LoopOptions.LoopStep2 == nil || p.Extra.LoopOptions.LoopStep4 == nil {
+			return OperateDetail{}
+		}
+
+		actions = append(actions, ActionDetail{
+			AccountId:  p.AccountId,
+			ActionType: p.Extra.LoopOptions.LoopStep2.Action,
+			BankId:     p.Extra.LoopOptions.LoopStep2.BankId,
+			Amount:     p.Extra.LoopOptions.LoopStep2.Amount,
+		}, ActionDetail{
+			AccountId:  p.AccountId,
+			ActionType: p.Extra.LoopOptions.LoopStep4.Action,
+			BankId:     p.Extra.LoopOptions.LoopStep4.BankId,
+			Amount:     p.Extra.LoopOptions.LoopStep4.Amount,
+		})
+		if step := p.Extra.LoopOptions.LoopStep3; step != nil && step.OrderId != "" {
+			swapOrderIds = append(swapOrderIds, step.OrderId)
+		}
+		loopType = p.Extra.LoopOptions.Type
 	case MATLiquidate:
+		if result := p.Extra.LiquidateResult; result != nil && result.PreBalances != nil && result.PostBalances != nil {
+			actions = append(actions, result.Actions()...)
+		}
 	case MATDomeLoopClosePosition:
+		result := p.Extra.ClosePositionResult
+		if result == nil {
+			return OperateDetail{}
+		}
+		withdrawn := result.WithdrawnAmount.Add(result.RefundDepositAssetAmount)
+		if withdrawn.IsPositive() {
+			actions = append(actions, ActionDetail{AccountId: p.AccountId, ActionType: MATWithdraw, BankId: result.DepositBankId, Amount: withdrawn})
+		}
+		if result.RepaidAmount.IsPositive() {
+			actions = append(actions, ActionDetail{AccountId: p.AccountId, ActionType: MATRepay, BankId: result.BorrowBankId, Amount: result.RepaidAmount})
+		}
+		swapOrderIds = append(swapOrderIds, result.SwapOrderIds...)
 	default:
 		actions = append(actions, ActionDetail{
 			AccountId:  p.AccountId,
@@ -91,8 +176,10 @@ func (p Payment) OperationDetail() OperateDetail {
 	}
 
 	return OperateDetail{
-		Type:      p.Action,
-		AccountId: p.AccountId,
-		Actions:   actions,
+		Type:         p.Action,
+		AccountId:    p.AccountId,
+		Actions:      actions,
+		SwapOrderIds: swapOrderIds,
+		LoopType:     loopType,
 	}
 }
@@ -158,4 +158,8 @@ type ClosePositionResult struct {
 	BorrowBankId             uuid.UUID       `json:"borrowBankId"`
 	RefundBorrowAssetAmount  decimal.Decimal `json:"refundBorrowAssetAmount"`
 	RefundDepositAssetAmount decimal.Decimal `json:"refundDepositAssetAmount"`
+	// WithdrawnAmount and RepaidAmount add up the rounds, RepaidAmount leaves out the refunded surplus
+	WithdrawnAmount decimal.Decimal `json:"withdrawnAmount"`
+	RepaidAmount    decimal.Decimal `json:"repaidAmount"`
+	SwapOrderIds    []string        `json:"swapOrderIds,omitempty"`
 }
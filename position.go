@@ -0,0 +1,409 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/facebookgo/clock"
+	"github.com/gofrs/uuid"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+const POSITION_OPERATES_PAGE_SIZE = 100
+
+// Position is a read only view of a looped position, values are in USD unless noted otherwise
+type Position struct {
+	AccountId     uuid.UUID       `json:"accountId"`
+	GroupId       uuid.UUID       `json:"groupId"`
+	Type          LoopPaymentType `json:"type"`
+	DepositBankId uuid.UUID       `json:"depositBankId"`
+	BorrowBankId  uuid.UUID       `json:"borrowBankId"`
+
+	DepositAmount decimal.Decimal `json:"depositAmount"`
+	BorrowAmount  decimal.Decimal `json:"borrowAmount"`
+	DepositPrice  decimal.Decimal `json:"depositPrice"`
+	BorrowPrice   decimal.Decimal `json:"borrowPrice"`
+
+	Notional decimal.Decimal `json:"notional"`
+	Equity   decimal.Decimal `json:"equity"`
+	Leverage decimal.Decimal `json:"leverage"`
+
+	// EntryPrice and the PnL figures are quoted in the asset of the non exposed leg,
+	// which is the borrowed asset of a long and the deposited asset of a short
+	EntryPrice    decimal.Decimal `json:"entryPrice"`
+	RealizedPnl   decimal.Decimal `json:"realizedPnl"`
+	UnrealizedPnl decimal.Decimal `json:"unrealizedPnl"`
+
+	// interest in native units of the deposit and borrow bank
+	InterestEarned decimal.Decimal `json:"interestEarned"`
+	InterestPaid   decimal.Decimal `json:"interestPaid"`
+
+	DepositEmissionsOutstanding decimal.Decimal `json:"depositEmissionsOutstanding"`
+	BorrowEmissionsOutstanding  decimal.Decimal `json:"borrowEmissionsOutstanding"`
+
+	LiquidationPrice decimal.Decimal `json:"liquidationPrice"`
+
+	// PendingSwapOrderIds are the swap orders of the position that are not settled or not
+	// found, their fills are left out of EntryPrice and RealizedPnl
+	PendingSwapOrderIds []string `json:"pendingSwapOrderIds,omitempty"`
+}
+
+// PositionFills is the average cost accounting of the swap fills of a position
+type PositionFills struct {
+	Quantity    decimal.Decimal `json:"quantity"`
+	EntryPrice  decimal.Decimal `json:"entryPrice"`
+	RealizedPnl decimal.Decimal `json:"realizedPnl"`
+}
+
+// LoadPosition builds the Position of the account in groupId from its balances, its Operate
+// history and the swap orders recorded on it. The type is the one recorded when the position was
+// opened, loopType only decides for a position not opened by a loop and is rejected when it differs.
+func LoadPosition(ctx context.Context, clk clock.Clock, log Log, bankAccountService BankAccountService, operateStore OperateStore, orderStore MixinOracleStore, priceFeedMgr PriceAdapterMgr, account *Account, groupId uuid.UUID, loopType LoopPaymentType) (*Position, error) {
+	depositAccount, borrowAccount, err := FindLoopPosition(ctx, clk, log, bankAccountService, account, groupId)
+	if err != nil {
+		return nil, err
+	}
+	if borrowAccount == nil {
+		return nil, ErrPositionNotFound
+	}
+
+	positionType, err := FindLoopPositionType(ctx, clk, operateStore, account, depositAccount.Bank.Id, borrowAccount.Bank.Id)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case positionType == "" && loopType == "":
+		loopType = LoopPaymentTypeLong
+	case positionType == "":
+	case loopType != "" && loopType != positionType:
+		return nil, ErrLoopTypeMismatch
+	default:
+		loopType = positionType
+	}
+
+	depositPrice, err := getOriginalPrice(priceFeedMgr, depositAccount.Bank)
+	if err != nil {
+		return nil, err
+	}
+	borrowPrice, err := getOriginalPrice(priceFeedMgr, borrowAccount.Bank)
+	if err != nil {
+		return nil, err
+	}
+
+	operates, err := listAccountOperates(ctx, clk, operateStore, account)
+	if err != nil {
+		return nil, err
+	}
+
+	orders := []*SwapOrder{}
+	pendingOrderIds := []string{}
+	for _, operate := range operates {
+		for _, orderId := range operate.Extra.SwapOrderIds {
+			order, err := orderStore.GetMixinOrderByOrderId(ctx, orderId)
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				pendingOrderIds = append(pendingOrderIds, orderId)
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if order.State != SwapOrderStateSuccess && order.State != SwapOrderStateFailed {
+				pendingOrderIds = append(pendingOrderIds, orderId)
+			}
+			orders = append(orders, order)
+		}
+	}
+
+	position := NewPosition(loopType, depositAccount, borrowAccount, depositPrice, borrowPrice, operates, orders)
+	position.PendingSwapOrderIds = pendingOrderIds
+	position.AccountId = account.Id
+	position.GroupId = groupId
+
+	banks, err := bankAccountService.ListBankByGroupId(ctx, groupId)
+	if err != nil {
+		return nil, err
+	}
+	banksMap := make(map[string]*Bank, len(banks))
+	for _, bank := range banks {
+		banksMap[bank.Id.String()] = bank
+	}
+
+	position.LiquidationPrice, err = ComputeLoopLiquidationPrice(bankAccountService, banksMap, []*BankAccountWrapper{depositAccount, borrowAccount}, priceFeedMgr, account.Id, &LoopPaymentOptions{
+		Type:          loopType,
+		DepositBankId: depositAccount.Bank.Id,
+		BorrowBankId:  borrowAccount.Bank.Id,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return position, nil
+}
+
+// NewPosition computes a Position from already loaded balances, prices, operates and swap orders
+func NewPosition(loopType LoopPaymentType, depositAccount, borrowAccount *BankAccountWrapper, depositPrice, borrowPrice decimal.Decimal, operates []Operate, orders []*SwapOrder) *Position {
+	depositAmount, _ := depositAccount.Balance.ComputeQuantity(depositAccount.Bank)
+	_, borrowAmount := borrowAccount.Balance.ComputeQuantity(borrowAccount.Bank)
+
+	position := &Position{
+		Type:                        loopType,
+		DepositBankId:               depositAccount.Bank.Id,
+		BorrowBankId:                borrowAccount.Bank.Id,
+		DepositAmount:               depositAmount,
+		BorrowAmount:                borrowAmount,
+		DepositPrice:                depositPrice,
+		BorrowPrice:                 borrowPrice,
+		DepositEmissionsOutstanding: depositAccount.Balance.EmissionsOutstanding,
+		BorrowEmissionsOutstanding:  borrowAccount.Balance.EmissionsOutstanding,
+	}
+
+	depositValue := depositAmount.Mul(depositPrice)
+	borrowValue := borrowAmount.Mul(borrowPrice)
+	position.Equity = depositValue.Sub(borrowValue)
+	position.Notional = depositValue
+	if loopType == LoopPaymentTypeShort {
+		position.Notional = borrowValue
+	}
+	if leverage, err := ComputeLoopLeverage(loopType, depositValue, borrowValue); err == nil {
+		position.Leverage = leverage
+	}
+
+	netDeposited, netBorrowed := decimal.Zero, decimal.Zero
+	for _, operate := range operates {
+		for _, action := range operate.Extra.Actions {
+			switch {
+			case action.BankId == position.DepositBankId && action.ActionType == MATSupply:
+				netDeposited = netDeposited.Add(action.Amount)
+			case action.BankId == position.DepositBankId && action.ActionType == MATWithdraw:
+				netDeposited = netDeposited.Sub(action.Amount)
+			case action.BankId == position.BorrowBankId && action.ActionType == MATBorrow:
+				netBorrowed = netBorrowed.Add(action.Amount)
+			case action.BankId == position.BorrowBankId && action.ActionType == MATRepay:
+				netBorrowed = netBorrowed.Sub(action.Amount)
+			}
+		}
+	}
+	position.InterestEarned = decimal.Max(decimal.Zero, depositAmount.Sub(netDeposited))
+	position.InterestPaid = decimal.Max(decimal.Zero, borrowAmount.Sub(netBorrowed))
+
+	exposedAssetId, quoteAssetId := depositAccount.Bank.MixinSafeAssetId, borrowAccount.Bank.MixinSafeAssetId
+	markPrice, exposedQuantity := decimal.Zero, depositAmount
+	if loopType == LoopPaymentTypeShort {
+		exposedAssetId, quoteAssetId = quoteAssetId, exposedAssetId
+		exposedQuantity = borrowAmount
+		if depositPrice.IsPositive() {
+			markPrice = borrowPrice.Div(depositPrice)
+		}
+	} else if borrowPrice.IsPositive() {
+		markPrice = depositPrice.Div(borrowPrice)
+	}
+
+	fills := ComputePositionFills(loopType, exposedAssetId, quoteAssetId, orders)
+	position.EntryPrice = fills.EntryPrice
+	position.RealizedPnl = fills.RealizedPnl
+	if fills.EntryPrice.IsPositive() {
+		pnl := markPrice.Sub(fills.EntryPrice).Mul(exposedQuantity)
+		if loopType == LoopPaymentTypeShort {
+			pnl = pnl.Neg()
+		}
+		position.UnrealizedPnl = pnl
+	}
+
+	return position
+}
+
+// ComputePositionFills runs average cost accounting over the successful swap orders between the
+// exposed asset and the quote asset. Fills that grow the exposure move the entry price, fills that
+// shrink it realize PnL against the entry price.
+func ComputePositionFills(loopType LoopPaymentType, exposedAssetId, quoteAssetId string, orders []*SwapOrder) PositionFills {
+	sorted := make([]*SwapOrder, 0, len(orders))
+	for _, order := range orders {
+		if order.State == SwapOrderStateSuccess {
+			sorted = append(sorted, order)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].CreatedAt.Before(sorted[j].CreatedAt)
+	})
+
+	fills := PositionFills{
+		Quantity:    decimal.Zero,
+		EntryPrice:  decimal.Zero,
+		RealizedPnl: decimal.Zero,
+	}
+	for _, order := range sorted {
+		var exposedAmount, quoteAmount decimal.Decimal
+		var buysExposed bool
+		switch {
+		case order.AssetId == quoteAssetId && order.ReceiveAssetId == exposedAssetId:
+			exposedAmount, quoteAmount, buysExposed = order.ReceiveAmount, order.Amount, true
+		case order.AssetId == exposedAssetId && order.ReceiveAssetId == quoteAssetId:
+			exposedAmount, quoteAmount, buysExposed = order.Amount, order.ReceiveAmount, false
+		default:
+			continue
+		}
+		if !exposedAmount.IsPositive() {
+			continue
+		}
+		price := quoteAmount.Div(exposedAmount)
+
+		// a long opens by buying the exposed asset, a short opens by selling it
+		opens := buysExposed == (loopType != LoopPaymentTypeShort)
+		if opens {
+			cost := fills.EntryPrice.Mul(fills.Quantity).Add(quoteAmount)
+			fills.Quantity = fills.Quantity.Add(exposedAmount)
+			fills.EntryPrice = cost.Div(fills.Quantity)
+			continue
+		}
+
+		closed := decimal.Min(exposedAmount, fills.Quantity)
+		pnl := price.Sub(fills.EntryPrice).Mul(closed)
+		if loopType == LoopPaymentTypeShort {
+			pnl = pnl.Neg()
+		}
+		fills.RealizedPnl = fills.RealizedPnl.Add(pnl)
+		fills.Quantity = fills.Quantity.Sub(closed)
+		if fills.Quantity.IsZero() {
+			fills.EntryPrice = decimal.Zero
+		}
+	}
+
+	return fills
+}
+
+// FindLoopPosition returns the single asset balance and the optional single liability balance of
+// the account in groupId, with the interest of both banks accrued up to now. The wrappers hold
+// clones of the stored banks, storing them is up to the caller.
+func FindLoopPosition(ctx context.Context, clk clock.Clock, log Log, bankAccountService BankAccountService, account *Account, groupId uuid.UUID) (*BankAccountWrapper, *BankAccountWrapper, error) {
+	balances, err := bankAccountService.ListBalances(ctx, account.Id, uuid.Nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var depositAccount, borrowAccount *BankAccountWrapper
+	for _, balance := range balances {
+		if !balance.Active {
+			continue
+		}
+		bank, err := bankAccountService.GetBankById(ctx, balance.BankId)
+		if err != nil {
+			return nil, nil, err
+		}
+		if bank.GroupId != groupId {
+			continue
+		}
+		bank = bank.Clone()
+		if err := bank.AccrueInterest(log, clk.Now().Unix()); err != nil {
+			return nil, nil, err
+		}
+
+		side, err := balance.GetSide()
+		if err != nil {
+			return nil, nil, err
+		}
+		switch side {
+		case BalanceSideAssets:
+			if depositAccount != nil {
+				return nil, nil, ErrAmbiguousPosition
+			}
+			depositAccount = NewBankAccountWrapper(balance, bank, WithClock(clk), WithAccount(account))
+		case BalanceSideLiabilities:
+			if borrowAccount != nil {
+				return nil, nil, ErrAmbiguousPosition
+			}
+			borrowAccount = NewBankAccountWrapper(balance, bank, WithClock(clk), WithAccount(account))
+		}
+	}
+
+	if depositAccount == nil {
+		return nil, nil, ErrPositionNotFound
+	}
+	return depositAccount, borrowAccount, nil
+}
+
+// FindLoopPositionType returns the type recorded on the last MATLoop of the account that deposited
+// into depositBankId and borrowed from borrowBankId, or an empty type when there is none. Loops
+// recorded before the type was stored are long.
+func FindLoopPositionType(ctx context.Context, clk clock.Clock, operateStore OperateStore, account *Account, depositBankId, borrowBankId uuid.UUID) (LoopPaymentType, error) {
+	createdBeforeAt, beforeId := clk.Now().Unix()+1, uuid.Nil
+	for {
+		page, err := operateStore.ListAccountOperates(ctx, account.Id, MATLoop, createdBeforeAt, beforeId, POSITION_OPERATES_PAGE_SIZE)
+		if err != nil {
+			return "", err
+		}
+		for _, operate := range page {
+			if !operate.opensLoop(depositBankId, borrowBankId) {
+				continue
+			}
+			if operate.Extra.LoopType == "" {
+				return LoopPaymentTypeLong, nil
+			}
+			return operate.Extra.LoopType, nil
+		}
+		if len(page) < POSITION_OPERATES_PAGE_SIZE {
+			return "", nil
+		}
+		last := page[len(page)-1]
+		createdBeforeAt, beforeId = last.CreatedAt, last.Id
+	}
+}
+
+func (o Operate) opensLoop(depositBankId, borrowBankId uuid.UUID) bool {
+	deposits, borrows := false, false
+	for _, action := range o.Extra.Actions {
+		switch {
+		case action.ActionType == MATSupply && action.BankId == depositBankId:
+			deposits = true
+		case action.ActionType == MATBorrow && action.BankId == borrowBankId:
+			borrows = true
+		}
+	}
+	return deposits && borrows
+}
+
+// listAccountOperates loads the operates by account id, so the history recorded under the pubkeys
+// the account had before an authority transfer is kept. Only the actions of the account are kept,
+// a liquidation or a collateral transfer also moves another account.
+func listAccountOperates(ctx context.Context, clk clock.Clock, operateStore OperateStore, account *Account) ([]Operate, error) {
+	operates := []Operate{}
+	for _, op := range []MemoActionType{MATSupply, MATBorrow, MATRepay, MATWithdraw, MATLoop, MATLoopAdjust, MATDomeLoopClosePosition, MATLiquidate, MATTransferCollateral} {
+		createdBeforeAt, beforeId := clk.Now().Unix()+1, uuid.Nil
+		for {
+			page, err := operateStore.ListAccountOperates(ctx, account.Id, op, createdBeforeAt, beforeId, POSITION_OPERATES_PAGE_SIZE)
+			if err != nil {
+				return nil, err
+			}
+			for _, operate := range page {
+				operates = append(operates, operate.forAccount(account.Id))
+			}
+			if len(page) < POSITION_OPERATES_PAGE_SIZE {
+				break
+			}
+			last := page[len(page)-1]
+			createdBeforeAt, beforeId = last.CreatedAt, last.Id
+		}
+	}
+	return operates, nil
+}
+
+// forAccount keeps the actions of accountId, actions recorded without an account are kept
+func (o Operate) forAccount(accountId uuid.UUID) Operate {
+	actions := make([]ActionDetail, 0, len(o.Extra.Actions))
+	for _, action := range o.Extra.Actions {
+		if action.AccountId == uuid.Nil || action.AccountId == accountId {
+			actions = append(actions, action)
+		}
+	}
+	o.Extra.Actions = actions
+	return o
+}
+
+func getOriginalPrice(priceFeedMgr PriceAdapterMgr, bank *Bank) (decimal.Decimal, error) {
+	priceAdapter, err := priceFeedMgr.GetPriceAdapter(bank)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return priceAdapter.GetPriceOfType(TimeWeighted, Original)
+}
@@ -0,0 +1,221 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/clock"
+	"github.com/gofrs/uuid"
+	"github.com/rs/zerolog"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComputePositionFills(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	orders := []*SwapOrder{
+		{
+			AssetId:        "usdt",
+			ReceiveAssetId: "btc",
+			Amount:         decimal.NewFromInt(100),
+			ReceiveAmount:  decimal.NewFromInt(1),
+			State:          SwapOrderStateSuccess,
+			CreatedAt:      start,
+		},
+		{
+			AssetId:        "usdt",
+			ReceiveAssetId: "btc",
+			Amount:         decimal.NewFromInt(300),
+			ReceiveAmount:  decimal.NewFromInt(1),
+			State:          SwapOrderStateSuccess,
+			CreatedAt:      start.Add(time.Hour),
+		},
+		{
+			AssetId:        "btc",
+			ReceiveAssetId: "usdt",
+			Amount:         decimal.NewFromInt(1),
+			ReceiveAmount:  decimal.NewFromInt(250),
+			State:          SwapOrderStateSuccess,
+			CreatedAt:      start.Add(2 * time.Hour),
+		},
+		{
+			AssetId:        "usdt",
+			ReceiveAssetId: "btc",
+			Amount:         decimal.NewFromInt(1000),
+			ReceiveAmount:  decimal.NewFromInt(1),
+			State:          SwapOrderStateFailed,
+			CreatedAt:      start.Add(3 * time.Hour),
+		},
+	}
+
+	long := ComputePositionFills(LoopPaymentTypeLong, "btc", "usdt", orders)
+	assert.True(t, long.Quantity.Equal(decimal.NewFromInt(1)), "expected 1, got %s", long.Quantity)
+	assert.True(t, long.EntryPrice.Equal(decimal.NewFromInt(200)), "expected 200, got %s", long.EntryPrice)
+	assert.True(t, long.RealizedPnl.Equal(decimal.NewFromInt(50)), "expected 50, got %s", long.RealizedPnl)
+
+	short := ComputePositionFills(LoopPaymentTypeShort, "btc", "usdt", orders[2:])
+	assert.True(t, short.Quantity.Equal(decimal.NewFromInt(1)), "expected 1, got %s", short.Quantity)
+	assert.True(t, short.EntryPrice.Equal(decimal.NewFromInt(250)), "expected 250, got %s", short.EntryPrice)
+	assert.True(t, short.RealizedPnl.IsZero(), "expected 0, got %s", short.RealizedPnl)
+}
+
+func TestNewPosition(t *testing.T) {
+	btc, usdt := newLoopBank("btc", 0.8, 0.9), newLoopBank("usdt", 0.9, 0.95)
+	deposit := NewBankAccountWrapper(&Balance{BankId: btc.Id, Active: true, AssetShares: decimal.NewFromFloat(3.01), LiabilityShares: decimal.Zero}, btc)
+	borrow := NewBankAccountWrapper(&Balance{BankId: usdt.Id, Active: true, AssetShares: decimal.Zero, LiabilityShares: decimal.NewFromInt(202)}, usdt)
+	operates := []Operate{
+		{Extra: OperateDetail{Actions: []ActionDetail{
+			{ActionType: MATSupply, BankId: btc.Id, Amount: ONE},
+			{ActionType: MATBorrow, BankId: usdt.Id, Amount: decimal.NewFromInt(200)},
+			{ActionType: MATSupply, BankId: btc.Id, Amount: decimal.NewFromInt(2)},
+		}}},
+	}
+	orders := []*SwapOrder{
+		{AssetId: "usdt", ReceiveAssetId: "btc", Amount: decimal.NewFromInt(200), ReceiveAmount: decimal.NewFromInt(2), State: SwapOrderStateSuccess},
+	}
+
+	position := NewPosition(LoopPaymentTypeLong, deposit, borrow, decimal.NewFromInt(110), ONE, operates, orders)
+	assert.True(t, position.Notional.Equal(decimal.NewFromFloat(331.1)))
+	assert.True(t, position.Equity.Equal(decimal.NewFromFloat(129.1)))
+	assert.True(t, position.InterestEarned.Equal(decimal.NewFromFloat(0.01)))
+	assert.True(t, position.InterestPaid.Equal(decimal.NewFromInt(2)))
+	assert.True(t, position.EntryPrice.Equal(decimal.NewFromInt(100)))
+	assert.True(t, position.UnrealizedPnl.Equal(decimal.NewFromFloat(30.1)), "got %s", position.UnrealizedPnl)
+}
+
+func TestListAccountOperates(t *testing.T) {
+	clk := clock.NewMock()
+	store := newLoopStore()
+	account := &Account{Id: uuid.Must(uuid.NewV4()), PubKey: "new"}
+
+	// more than a page within the same second, partly recorded under the pubkey before a transfer
+	for i := 0; i < POSITION_OPERATES_PAGE_SIZE+5; i++ {
+		pubKey := "old"
+		if i%2 == 0 {
+			pubKey = account.PubKey
+		}
+		store.operates = append(store.operates, NewOperate(clk, pubKey, account.Id, MATSupply, OperateDetail{}))
+	}
+	store.operates = append(store.operates, NewOperate(clk, account.PubKey, uuid.Must(uuid.NewV4()), MATSupply, OperateDetail{}))
+
+	operates, err := listAccountOperates(context.Background(), clk, store, account)
+	assert.NoError(t, err)
+	assert.Len(t, operates, POSITION_OPERATES_PAGE_SIZE+5)
+	seen := map[uuid.UUID]bool{}
+	for _, operate := range operates {
+		seen[operate.Id] = true
+	}
+	assert.Len(t, seen, POSITION_OPERATES_PAGE_SIZE+5)
+}
+
+func TestLoadPositionUsesRecordedType(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewMock()
+	store, btc, usdt, account := newLoopTest()
+	log := zerolog.Nop()
+
+	// a short of 1 btc against 300 usdt, opened by a MATLoop
+	lender := uuid.Must(uuid.NewV4())
+	btc.TotalAssetShares = decimal.NewFromInt(100)
+	store.balances = append(store.balances, &Balance{AccountId: lender, BankId: btc.Id, Active: true, AssetShares: decimal.NewFromInt(100), LiabilityShares: decimal.Zero})
+	openLoopPosition(store, account, usdt, btc, decimal.NewFromInt(300), ONE)
+	loop := newLoopPayment(clk, account, MATLoop)
+	WithLoopOptions(&LoopPaymentOptions{
+		Type:      LoopPaymentTypeShort,
+		LoopStep1: NewLoopPaymentStep(MATSupply, usdt.Id, decimal.NewFromInt(200)),
+		LoopStep2: NewLoopPaymentStep(MATBorrow, btc.Id, ONE),
+	})(loop)
+	store.operates = append(store.operates, NewOperate(clk, account.PubKey, account.Id, MATLoop, loop.OperationDetail()))
+
+	position, err := LoadPosition(ctx, clk, &log, store.service(), store, store, store.prices, account, uuid.Nil, "")
+	assert.NoError(t, err)
+	assert.Equal(t, LoopPaymentTypeShort, position.Type)
+	assert.True(t, position.Notional.Equal(decimal.NewFromInt(100)))
+
+	_, err = LoadPosition(ctx, clk, &log, store.service(), store, store, store.prices, account, uuid.Nil, LoopPaymentTypeLong)
+	assert.ErrorIs(t, err, ErrLoopTypeMismatch)
+}
+
+func TestPositionInterestAfterLiquidationAndTransfer(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewMock()
+	store, btc, usdt, account := newLoopTest()
+	other, liquidator := uuid.Must(uuid.NewV4()), uuid.Must(uuid.NewV4())
+
+	loop := newLoopPayment(clk, account, MATLoop)
+	WithLoopOptions(&LoopPaymentOptions{
+		LoopStep1: NewLoopPaymentStep(MATSupply, btc.Id, ONE),
+		LoopStep2: NewLoopPaymentStep(MATBorrow, usdt.Id, decimal.NewFromInt(200)),
+		LoopStep4: &LoopPaymentStep{Action: MATSupply, BankId: btc.Id, Amount: decimal.NewFromInt(2), State: PaymentStatusConfirmed},
+	})(loop)
+	liquidation := newLoopPayment(clk, &Account{Id: liquidator}, MATLiquidate)
+	liquidation.Extra.LiquidateResult = &LiquidateResult{
+		AssetBank:     btc,
+		LiabilityBank: usdt,
+		PreBalances: &LiquidationBalances{
+			LiquidateeAssetBalance:     &Balance{AccountId: account.Id, AssetShares: decimal.NewFromInt(3), LiabilityShares: decimal.Zero},
+			LiquidateeLiabilityBalance: &Balance{AccountId: account.Id, AssetShares: decimal.Zero, LiabilityShares: decimal.NewFromInt(202)},
+		},
+		PostBalances: &LiquidationBalances{
+			LiquidateeAssetBalance:     &Balance{AccountId: account.Id, AssetShares: decimal.NewFromFloat(2.5), LiabilityShares: decimal.Zero},
+			LiquidateeLiabilityBalance: &Balance{AccountId: account.Id, AssetShares: decimal.Zero, LiabilityShares: decimal.NewFromInt(162)},
+			LiquidatorAssetBalance:     &Balance{AccountId: liquidator, AssetShares: decimal.NewFromFloat(0.5), LiabilityShares: decimal.Zero},
+		},
+	}
+	detail := liquidation.OperationDetail()
+	assert.Equal(t, []uuid.UUID{account.Id, liquidator}, detail.AccountIds())
+	store.operates = append(store.operates,
+		NewOperate(clk, account.PubKey, account.Id, MATLoop, loop.OperationDetail()),
+		NewOperate(clk, "liquidator", account.Id, MATLiquidate, detail),
+		NewOperate(clk, "other", account.Id, MATTransferCollateral, OperateDetail{Actions: []ActionDetail{
+			{AccountId: other, ActionType: MATWithdraw, BankId: btc.Id, Amount: decimal.NewFromFloat(0.1)},
+			{AccountId: account.Id, ActionType: MATSupply, BankId: btc.Id, Amount: decimal.NewFromFloat(0.1)},
+		}}),
+	)
+
+	operates, err := listAccountOperates(ctx, clk, store, account)
+	assert.NoError(t, err)
+	assert.Len(t, operates, 3)
+
+	// 2.6 btc and 162 usdt are left of the loop, 0.01 btc and 2 usdt are interest
+	deposit := NewBankAccountWrapper(&Balance{BankId: btc.Id, Active: true, AssetShares: decimal.NewFromFloat(2.61), LiabilityShares: decimal.Zero}, btc)
+	borrow := NewBankAccountWrapper(&Balance{BankId: usdt.Id, Active: true, AssetShares: decimal.Zero, LiabilityShares: decimal.NewFromInt(162)}, usdt)
+	position := NewPosition(LoopPaymentTypeLong, deposit, borrow, decimal.NewFromInt(100), ONE, operates, nil)
+	assert.True(t, position.InterestEarned.Equal(decimal.NewFromFloat(0.01)), "got %s", position.InterestEarned)
+	assert.True(t, position.InterestPaid.Equal(decimal.NewFromInt(2)), "got %s", position.InterestPaid)
+}
+
+func TestLoadPositionFlagsPendingSwapOrders(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewMock()
+	store, btc, usdt, account := newLoopTest()
+	log := zerolog.Nop()
+	openLoopPosition(store, account, btc, usdt, decimal.NewFromInt(3), decimal.NewFromInt(200))
+
+	loop := newLoopPayment(clk, account, MATLoop)
+	WithLoopOptions(&LoopPaymentOptions{
+		LoopStep1: NewLoopPaymentStep(MATSupply, btc.Id, ONE),
+		LoopStep2: NewLoopPaymentStep(MATBorrow, usdt.Id, decimal.NewFromInt(200)),
+		LoopStep3: &LoopPaymentStep3{OrderId: "missing"},
+	})(loop)
+	adjust := newLoopPayment(clk, account, MATLoopAdjust)
+	WithLoopOptions(&LoopPaymentOptions{
+		LoopStep2: NewLoopPaymentStep(MATBorrow, usdt.Id, decimal.NewFromInt(10)),
+		LoopStep3: &LoopPaymentStep3{OrderId: "pending"},
+		LoopStep4: NewLoopPaymentStep(MATSupply, btc.Id, decimal.NewFromFloat(0.1)),
+	})(adjust)
+	store.orders["pending"] = &SwapOrder{OrderId: "pending", State: SwapOrderStatePending}
+	store.operates = append(store.operates,
+		NewOperate(clk, account.PubKey, account.Id, MATLoop, loop.OperationDetail()),
+		NewOperate(clk, account.PubKey, account.Id, MATLoopAdjust, adjust.OperationDetail()),
+	)
+
+	clk.Add(24 * time.Hour)
+	position, err := LoadPosition(ctx, clk, &log, store.service(), store, store, store.prices, account, uuid.Nil, "")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"missing", "pending"}, position.PendingSwapOrderIds)
+	// the interest is accrued on clones, the stored banks are left alone
+	assert.Zero(t, btc.LastUpdate)
+	assert.Zero(t, usdt.LastUpdate)
+}
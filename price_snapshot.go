@@ -0,0 +1,88 @@
+package core
+
+import (
+	"sync"
+
+	"github.com/gofrs/uuid"
+	"github.com/shopspring/decimal"
+)
+
+type (
+	// PriceSnapshot is a PriceAdapterMgr that reads every price once, so a scan over many accounts
+	// values them all with the same prices
+	PriceSnapshot struct {
+		priceFeedMgr PriceAdapterMgr
+
+		mu       sync.Mutex
+		adapters map[uuid.UUID]*snapshotPriceAdapter
+	}
+
+	snapshotPriceAdapter struct {
+		adapter PriceAdapter
+
+		mu     sync.Mutex
+		prices map[snapshotPriceKey]decimal.Decimal
+		all    []decimal.Decimal
+	}
+
+	snapshotPriceKey struct {
+		priceType OraclePriceType
+		bias      PriceBias
+	}
+)
+
+func NewPriceSnapshot(priceFeedMgr PriceAdapterMgr) *PriceSnapshot {
+	return &PriceSnapshot{
+		priceFeedMgr: priceFeedMgr,
+		adapters:     map[uuid.UUID]*snapshotPriceAdapter{},
+	}
+}
+
+func (s *PriceSnapshot) GetPriceAdapter(bank *Bank) (PriceAdapter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if adapter, ok := s.adapters[bank.Id]; ok {
+		return adapter, nil
+	}
+	adapter, err := s.priceFeedMgr.GetPriceAdapter(bank)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &snapshotPriceAdapter{
+		adapter: adapter,
+		prices:  map[snapshotPriceKey]decimal.Decimal{},
+	}
+	s.adapters[bank.Id] = snapshot
+	return snapshot, nil
+}
+
+func (a *snapshotPriceAdapter) GetPriceOfType(priceType OraclePriceType, bias PriceBias) (decimal.Decimal, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	key := snapshotPriceKey{priceType: priceType, bias: bias}
+	if price, ok := a.prices[key]; ok {
+		return price, nil
+	}
+	price, err := a.adapter.GetPriceOfType(priceType, bias)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	a.prices[key] = price
+	return price, nil
+}
+
+func (a *snapshotPriceAdapter) GetAllPriceType() (decimal.Decimal, decimal.Decimal, decimal.Decimal, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.all == nil {
+		price, priceLow, priceHigh, err := a.adapter.GetAllPriceType()
+		if err != nil {
+			return decimal.Zero, decimal.Zero, decimal.Zero, err
+		}
+		a.all = []decimal.Decimal{price, priceLow, priceHigh}
+	}
+	return a.all[0], a.all[1], a.all[2], nil
+}
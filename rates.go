@@ -0,0 +1,193 @@
+package core
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gofrs/uuid"
+	"github.com/shopspring/decimal"
+)
+
+type (
+	// BankRates are the current rates of a bank, EmissionsApr is the USD value of the emissions per
+	// USD of the emitting side and is reported apart from the interest rates
+	BankRates struct {
+		BankId           uuid.UUID       `json:"bankId"`
+		MixinSafeAssetId string          `json:"mixinSafeAssetId"`
+		UtilizationRate  decimal.Decimal `json:"utilizationRate"`
+		SupplyApr        decimal.Decimal `json:"supplyApr"`
+		BorrowApr        decimal.Decimal `json:"borrowApr"`
+		SupplyApy        decimal.Decimal `json:"supplyApy"`
+		BorrowApy        decimal.Decimal `json:"borrowApy"`
+
+		EmissionsApr       decimal.Decimal `json:"emissionsApr"`
+		LendingEmissions   bool            `json:"lendingEmissions"`
+		BorrowingEmissions bool            `json:"borrowingEmissions"`
+	}
+
+	// AccountApy is the yield of an account weighted by the USD value of its balances over its equity
+	AccountApy struct {
+		AccountId         uuid.UUID       `json:"accountId"`
+		AssetUsdValue     decimal.Decimal `json:"assetUsdValue"`
+		LiabilityUsdValue decimal.Decimal `json:"liabilityUsdValue"`
+		NetApy            decimal.Decimal `json:"netApy"`
+		EmissionsApr      decimal.Decimal `json:"emissionsApr"`
+	}
+)
+
+// ComputeRates returns the interest rates of the bank at its current utilization, without emissions
+func (b *Bank) ComputeRates() (*BankRates, error) {
+	utilizationRate := b.ComputeUtilizationRate()
+	lendingApr, borrowingApr, _, _, err := b.BankConfig.InterestRateConfig.CalcInterestRate(utilizationRate)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BankRates{
+		BankId:             b.Id,
+		MixinSafeAssetId:   b.MixinSafeAssetId,
+		UtilizationRate:    utilizationRate,
+		SupplyApr:          lendingApr,
+		BorrowApr:          borrowingApr,
+		SupplyApy:          AprToApy(lendingApr),
+		BorrowApy:          AprToApy(borrowingApr),
+		EmissionsApr:       decimal.Zero,
+		LendingEmissions:   b.GetFlag(BankFlagsLendingActive),
+		BorrowingEmissions: b.GetFlag(BankFlagsBorrowActive),
+	}, nil
+}
+
+// GetBankRates returns the rates of the bank including the emissions apr
+func GetBankRates(ctx context.Context, bankStore BankStore, priceFeedMgr PriceAdapterMgr, bank *Bank) (*BankRates, error) {
+	rates, err := bank.ComputeRates()
+	if err != nil {
+		return nil, err
+	}
+
+	if !rates.LendingEmissions && !rates.BorrowingEmissions {
+		return rates, nil
+	}
+	if !bank.EmissionsRate.IsPositive() || !bank.EmissionsRemaining.IsPositive() {
+		return rates, nil
+	}
+
+	emissions, err := GetEmissionsApr(ctx, bankStore, priceFeedMgr, bank)
+	if err != nil {
+		return nil, err
+	}
+	rates.EmissionsApr = emissions.Apr
+	return rates, nil
+}
+
+// ListBankRates returns the rates of every bank of the group
+func ListBankRates(ctx context.Context, bankStore BankStore, priceFeedMgr PriceAdapterMgr, groupId uuid.UUID) ([]*BankRates, error) {
+	banks, err := bankStore.ListBankByGroupId(ctx, groupId)
+	if err != nil {
+		return nil, err
+	}
+
+	banks = ExcludeDeletedBanks(banks)
+	rates := make([]*BankRates, 0, len(banks))
+	for _, bank := range banks {
+		bankRates, err := GetBankRates(ctx, bankStore, priceFeedMgr, bank)
+		if err != nil {
+			return nil, err
+		}
+		rates = append(rates, bankRates)
+	}
+	return rates, nil
+}
+
+// ComputeAccountApy weights the apy of every active balance by its USD value, the emissions are
+// weighted the same way on the side they are paid to
+func ComputeAccountApy(ctx context.Context, bankAccountService BankAccountService, priceFeedMgr PriceAdapterMgr, accountId uuid.UUID) (*AccountApy, error) {
+	if _, err := bankAccountService.GetAccountById(ctx, accountId); err != nil {
+		return nil, err
+	}
+	balances, err := bankAccountService.ListBalances(ctx, accountId, uuid.Nil)
+	if err != nil {
+		return nil, err
+	}
+
+	apy := &AccountApy{
+		AccountId:         accountId,
+		AssetUsdValue:     decimal.Zero,
+		LiabilityUsdValue: decimal.Zero,
+		NetApy:            decimal.Zero,
+		EmissionsApr:      decimal.Zero,
+	}
+	interest, emissions := decimal.Zero, decimal.Zero
+	for _, balance := range balances {
+		if !balance.Active {
+			continue
+		}
+
+		bank, err := bankAccountService.GetBankById(ctx, balance.BankId)
+		if err != nil {
+			return nil, err
+		}
+		price, err := getRealTimePrice(priceFeedMgr, bank)
+		if err != nil {
+			return nil, err
+		}
+		rates, err := GetBankRates(ctx, bankAccountService.BankStore, priceFeedMgr, bank)
+		if err != nil {
+			return nil, err
+		}
+
+		assetUsdValue := bank.ComputeAssetUsdValue(price, balance.AssetShares, Equity, Original)
+		liabilityUsdValue := bank.ComputeLiabilityUsdValue(price, balance.LiabilityShares, Equity, Original)
+		apy.AssetUsdValue = apy.AssetUsdValue.Add(assetUsdValue)
+		apy.LiabilityUsdValue = apy.LiabilityUsdValue.Add(liabilityUsdValue)
+
+		interest = interest.Add(rates.SupplyApy.Mul(assetUsdValue)).Sub(rates.BorrowApy.Mul(liabilityUsdValue))
+		if rates.LendingEmissions {
+			emissions = emissions.Add(rates.EmissionsApr.Mul(assetUsdValue))
+		}
+		if rates.BorrowingEmissions {
+			emissions = emissions.Add(rates.EmissionsApr.Mul(liabilityUsdValue))
+		}
+	}
+
+	equity := apy.AssetUsdValue.Sub(apy.LiabilityUsdValue)
+	if !equity.IsPositive() {
+		return apy, nil
+	}
+	apy.NetApy = interest.Div(equity).Round(8)
+	apy.EmissionsApr = emissions.Div(equity).Round(8)
+	return apy, nil
+}
+
+func getRealTimePrice(priceFeedMgr PriceAdapterMgr, bank *Bank) (decimal.Decimal, error) {
+	priceAdapter, err := priceFeedMgr.GetPriceAdapter(bank)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return priceAdapter.GetPriceOfType(RealTime, Original)
+}
+
+// getEmissionsPrice prices the emissions asset through the bank of that asset in the same group,
+// a missing bank, an emissions bank without an oracle or a price that is not positive counts as zero
+func getEmissionsPrice(ctx context.Context, bankStore BankStore, priceFeedMgr PriceAdapterMgr, bank *Bank) (decimal.Decimal, error) {
+	if bank.EmissionsMixinSafeAssetId == "" {
+		return decimal.Zero, nil
+	}
+	banks, err := bankStore.ListBankByGroupId(ctx, bank.GroupId)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	for _, emissionsBank := range ExcludeDeletedBanks(banks) {
+		if emissionsBank.MixinSafeAssetId != bank.EmissionsMixinSafeAssetId {
+			continue
+		}
+		price, err := getRealTimePrice(priceFeedMgr, emissionsBank)
+		if errors.Is(err, OracleNotSetup) {
+			return decimal.Zero, nil
+		}
+		if err != nil {
+			return decimal.Zero, err
+		}
+		return decimal.Max(price, decimal.Zero), nil
+	}
+	return decimal.Zero, nil
+}
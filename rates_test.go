@@ -0,0 +1,222 @@
+package core
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+type ratesStore struct {
+	BankStore
+	BalanceStore
+	AccountStore
+	banks    []*Bank
+	balances []*Balance
+}
+
+func (s *ratesStore) GetAccountById(ctx context.Context, accountId uuid.UUID) (*Account, error) {
+	return &Account{Id: accountId}, nil
+}
+
+func (s *ratesStore) ListBalances(ctx context.Context, accountId, bankId uuid.UUID) ([]*Balance, error) {
+	return s.balances, nil
+}
+
+func (s *ratesStore) GetBankById(ctx context.Context, bankId uuid.UUID) (*Bank, error) {
+	for _, bank := range s.banks {
+		if bank.Id == bankId {
+			return bank, nil
+		}
+	}
+	return nil, BankNotFound
+}
+
+func (s *ratesStore) ListBankByGroupId(ctx context.Context, groupId uuid.UUID) ([]*Bank, error) {
+	banks := []*Bank{}
+	for _, bank := range s.banks {
+		if bank.GroupId == groupId {
+			banks = append(banks, bank)
+		}
+	}
+	return banks, nil
+}
+
+func (s *ratesStore) GetBankByMixinSafeAssetId(ctx context.Context, assetId string) (*Bank, error) {
+	for _, bank := range s.banks {
+		if bank.MixinSafeAssetId == assetId {
+			return bank, nil
+		}
+	}
+	return nil, BankNotFound
+}
+
+type ratesPriceFeedMgr map[string]decimal.Decimal
+
+func (m ratesPriceFeedMgr) GetPriceAdapter(bank *Bank) (PriceAdapter, error) {
+	return ratesPriceAdapter(m[bank.MixinSafeAssetId]), nil
+}
+
+type ratesPriceAdapter decimal.Decimal
+
+func (p ratesPriceAdapter) GetPriceOfType(priceType OraclePriceType, bias PriceBias) (decimal.Decimal, error) {
+	return decimal.Decimal(p), nil
+}
+
+func (p ratesPriceAdapter) GetAllPriceType() (decimal.Decimal, decimal.Decimal, decimal.Decimal, error) {
+	price := decimal.Decimal(p)
+	return price, price, price, nil
+}
+
+func TestComputeAccountApy(t *testing.T) {
+	irConfig := InterestRateConfig{
+		OptimalUtilizationRate: decimal.NewFromFloat(0.8),
+		PlateauInterestRate:    decimal.NewFromFloat(0.1),
+		MaxInterestRate:        ONE,
+	}
+	btc := &Bank{
+		Id:                   uuid.Must(uuid.NewV4()),
+		MixinSafeAssetId:     "btc",
+		AssetShareValue:      decimal.NewFromInt(2),
+		LiabilityShareValue:  ONE,
+		TotalAssetShares:     decimal.NewFromInt(50),
+		TotalLiabilityShares: decimal.NewFromInt(40),
+		Flags:                BankFlagsLendingActive,
+		BankConfig:           BankConfig{InterestRateConfig: irConfig},
+
+		EmissionsMixinSafeAssetId: "dome",
+		EmissionsRate:             decimal.NewFromInt(100),
+		EmissionsRemaining:        decimal.NewFromInt(1000),
+	}
+	usdt := &Bank{
+		Id:                   uuid.Must(uuid.NewV4()),
+		MixinSafeAssetId:     "usdt",
+		AssetShareValue:      ONE,
+		LiabilityShareValue:  ONE,
+		TotalAssetShares:     decimal.NewFromInt(100),
+		TotalLiabilityShares: decimal.NewFromInt(20),
+		BankConfig:           BankConfig{InterestRateConfig: irConfig},
+	}
+	dome := &Bank{Id: uuid.Must(uuid.NewV4()), MixinSafeAssetId: "dome"}
+	store := &ratesStore{
+		banks: []*Bank{btc, usdt, dome},
+		balances: []*Balance{
+			{BankId: btc.Id, Active: true, AssetShares: decimal.NewFromFloat(0.5), LiabilityShares: decimal.Zero},
+			{BankId: usdt.Id, Active: true, AssetShares: decimal.Zero, LiabilityShares: decimal.NewFromInt(50)},
+		},
+	}
+	prices := ratesPriceFeedMgr{"btc": decimal.NewFromInt(100), "usdt": ONE, "dome": decimal.NewFromFloat(0.01)}
+	svc := BankAccountService{BalanceStore: store, BankStore: store, AccountStore: store}
+
+	rates, err := GetBankRates(context.Background(), store, prices, btc)
+	assert.NoError(t, err)
+	assert.True(t, rates.UtilizationRate.Equal(decimal.NewFromFloat(0.4)))
+	assert.True(t, rates.EmissionsApr.Equal(decimal.NewFromFloat(0.01)))
+
+	apy, err := ComputeAccountApy(context.Background(), svc, prices, uuid.Must(uuid.NewV4()))
+	assert.NoError(t, err)
+	assert.True(t, apy.AssetUsdValue.Equal(decimal.NewFromInt(100)))
+	assert.True(t, apy.LiabilityUsdValue.Equal(decimal.NewFromInt(50)))
+
+	btcRates, _ := btc.ComputeRates()
+	usdtRates, _ := usdt.ComputeRates()
+	expected := btcRates.SupplyApy.Mul(decimal.NewFromInt(100)).Sub(usdtRates.BorrowApy.Mul(decimal.NewFromInt(50))).Div(decimal.NewFromInt(50)).Round(8)
+	assert.True(t, apy.NetApy.Equal(expected), "expected %s, got %s", expected, apy.NetApy)
+	assert.True(t, apy.EmissionsApr.Equal(decimal.NewFromFloat(0.02)))
+
+	// an emissions bank of another group does not price the emissions
+	dome.GroupId = uuid.Must(uuid.NewV4())
+	rates, err = GetBankRates(context.Background(), store, prices, btc)
+	assert.NoError(t, err)
+	assert.True(t, rates.EmissionsApr.IsZero())
+
+	// neither does a missing price
+	dome.GroupId = btc.GroupId
+	delete(prices, "dome")
+	rates, err = GetBankRates(context.Background(), store, prices, btc)
+	assert.NoError(t, err)
+	assert.True(t, rates.EmissionsApr.IsZero())
+	apy, err = ComputeAccountApy(context.Background(), svc, prices, uuid.Must(uuid.NewV4()))
+	assert.NoError(t, err)
+	assert.True(t, apy.EmissionsApr.IsZero())
+}
+
+func TestBankComputeEmissionsApr(t *testing.T) {
+	bank := &Bank{
+		AssetShareValue:      ONE,
+		LiabilityShareValue:  ONE,
+		TotalAssetShares:     decimal.NewFromInt(1000),
+		TotalLiabilityShares: decimal.NewFromInt(400),
+		Flags:                BankFlagsLendingActive,
+		EmissionsRate:        decimal.NewFromFloat(0.365),
+		EmissionsRemaining:   decimal.NewFromInt(100),
+	}
+
+	emissions := bank.ComputeEmissionsApr(decimal.NewFromInt(2), decimal.NewFromInt(10))
+	assert.True(t, emissions.Apr.Equal(decimal.NewFromFloat(0.073)))
+	assert.True(t, emissions.EmittingUsdValue.Equal(decimal.NewFromInt(10000)))
+	assert.True(t, emissions.EmissionsPerDay.Equal(ONE))
+	assert.True(t, emissions.RunwayDays.Equal(decimal.NewFromInt(100)))
+
+	bank.Flags = BankFlagsEmissionsActive
+	emissions = bank.ComputeEmissionsApr(decimal.NewFromInt(2), decimal.NewFromInt(10))
+	assert.True(t, emissions.EmissionsPerDay.Equal(decimal.NewFromFloat(1.4)))
+	assert.True(t, emissions.RunwayDays.Equal(decimal.NewFromFloat(71.43)))
+}
+
+func TestListEmissionsAprWithoutEmissionsBank(t *testing.T) {
+	btc := &Bank{
+		Id:                   uuid.Must(uuid.NewV4()),
+		MixinSafeAssetId:     "btc",
+		AssetShareValue:      ONE,
+		LiabilityShareValue:  ONE,
+		TotalAssetShares:     decimal.NewFromInt(10),
+		TotalLiabilityShares: decimal.Zero,
+		Flags:                BankFlagsLendingActive,
+
+		EmissionsMixinSafeAssetId: "dome",
+		EmissionsRate:             decimal.NewFromInt(100),
+		EmissionsRemaining:        decimal.NewFromInt(1000),
+	}
+	// the dome bank belongs to another group
+	dome := &Bank{Id: uuid.Must(uuid.NewV4()), GroupId: uuid.Must(uuid.NewV4()), MixinSafeAssetId: "dome"}
+	store := &ratesStore{banks: []*Bank{btc, dome}}
+	prices := ratesPriceFeedMgr{"btc": decimal.NewFromInt(100), "dome": decimal.NewFromFloat(0.01)}
+
+	emissions, err := ListEmissionsApr(context.Background(), store, prices, btc.GroupId)
+	assert.NoError(t, err)
+	assert.Len(t, emissions, 1)
+	assert.True(t, emissions[0].Apr.IsZero())
+	assert.True(t, emissions[0].EmissionsPrice.IsZero())
+	assert.True(t, emissions[0].EmittingUsdValue.Equal(decimal.NewFromInt(1000)))
+
+	// an oracle error of the emissions bank is not a zero apr
+	dome.GroupId = btc.GroupId
+	_, err = ListEmissionsApr(context.Background(), store, failingPriceFeedMgr{ratesPriceFeedMgr: prices, assetId: "dome"}, btc.GroupId)
+	assert.ErrorIs(t, err, StaleOracle)
+	_, err = GetEmissionsApr(context.Background(), store, failingPriceFeedMgr{ratesPriceFeedMgr: prices, assetId: "btc"}, btc)
+	assert.ErrorIs(t, err, StaleOracle)
+	// an emissions asset without an oracle has no price
+	emissions, err = ListEmissionsApr(context.Background(), store, failingPriceFeedMgr{ratesPriceFeedMgr: prices, assetId: "dome", err: OracleNotSetup}, btc.GroupId)
+	assert.NoError(t, err)
+	assert.True(t, emissions[0].Apr.IsZero())
+}
+
+// failingPriceFeedMgr fails the price of assetId with err, StaleOracle by default
+type failingPriceFeedMgr struct {
+	ratesPriceFeedMgr
+	assetId string
+	err     error
+}
+
+func (m failingPriceFeedMgr) GetPriceAdapter(bank *Bank) (PriceAdapter, error) {
+	if bank.MixinSafeAssetId == m.assetId {
+		if m.err != nil {
+			return nil, m.err
+		}
+		return nil, StaleOracle
+	}
+	return m.ratesPriceFeedMgr.GetPriceAdapter(bank)
+}
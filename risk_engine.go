@@ -16,6 +16,9 @@ func NewRiskEngine(ctx context.Context, bankAccountService BankAccountService, a
 	if account.GetFlag(InFlashloanFlag) {
 		return nil, AccountInFlashloan
 	}
+	if account.GetFlag(ClosedFlag) {
+		return nil, AccountClosed
+	}
 
 	return NewRiskEngineNoFlashloanCheck(ctx, bankAccountService, account, bankAccounts, priceFeedMgr)
 }
@@ -166,6 +169,12 @@ func (r *RiskEngine) CheckPostLiquidationConditionAndGetAccountHealth(bankId uui
 }
 
 func (r *RiskEngine) CheckAccountBankrupt(log Log) error {
+	return r.CheckAccountBankruptWithConfig(log, DefaultGroupConfig())
+}
+
+// CheckAccountBankruptWithConfig checks the account against the bankrupt threshold of the group
+func (r *RiskEngine) CheckAccountBankruptWithConfig(log Log, config GroupConfig) error {
+	config = config.WithDefaults()
 	if r.Account.GetFlag(InFlashloanFlag) {
 		return AccountInFlashloan
 	}
@@ -181,7 +190,7 @@ func (r *RiskEngine) CheckAccountBankrupt(log Log) error {
 		return AccountNotBankrupt
 	}
 
-	if totalAssets.LessThan(BANKRUPT_THRESHOLD) {
+	if totalAssets.LessThan(*config.BankruptThreshold) {
 		return AccountNotBankrupt
 	}
 
@@ -0,0 +1,181 @@
+package core
+
+import (
+	"context"
+
+	"github.com/facebookgo/clock"
+	"github.com/gofrs/uuid"
+	"github.com/shopspring/decimal"
+)
+
+type SubAccountView struct {
+	Account          *Account        `json:"account"`
+	TotalAssets      decimal.Decimal `json:"totalAssets"`
+	TotalLiabilities decimal.Decimal `json:"totalLiabilities"`
+	Equity           decimal.Decimal `json:"equity"`
+}
+
+type SubAccountsView struct {
+	Accounts         []*SubAccountView `json:"accounts"`
+	TotalAssets      decimal.Decimal   `json:"totalAssets"`
+	TotalLiabilities decimal.Decimal   `json:"totalLiabilities"`
+	TotalEquity      decimal.Decimal   `json:"totalEquity"`
+}
+
+func ValidateAccountLabel(label string) error {
+	if len(label) > MAX_ACCOUNT_LABEL_LENGTH {
+		return ErrInvalidAccountLabel
+	}
+	return nil
+}
+
+// CreateSubAccount creates the account of pubKey at the lowest free index
+func CreateSubAccount(ctx context.Context, clk clock.Clock, accountStore AccountStore, group *Group, pubKey string, label string) (*Account, error) {
+	if err := ValidateAccountLabel(label); err != nil {
+		return nil, err
+	}
+
+	maxAccounts := group.GetConfig().MaxAccountsPerPubKey
+	accounts, err := ListOpenAccountsByPubkey(ctx, accountStore, group.Id, pubKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(accounts) >= maxAccounts {
+		return nil, TooManyAccounts
+	}
+
+	usedIndexes := make(map[uint8]bool, len(accounts))
+	for _, account := range accounts {
+		usedIndexes[account.Index] = true
+	}
+
+	for index := 0; index < maxAccounts; index++ {
+		if usedIndexes[uint8(index)] {
+			continue
+		}
+		return CreateAccountForPubkey(ctx, clk, accountStore, group.Id, pubKey, uint8(index), WithAccountLabel(label))
+	}
+	return nil, TooManyAccounts
+}
+
+func SetAccountLabel(ctx context.Context, clk clock.Clock, accountStore AccountStore, account *Account, label string) error {
+	if err := ValidateAccountLabel(label); err != nil {
+		return err
+	}
+
+	account.Label = label
+	account.UpdatedAt = clk.Now().Unix()
+	return accountStore.UpsertAccount(ctx, account)
+}
+
+// ListSubAccounts returns every account of pubKey in the group with its equity value
+func ListSubAccounts(ctx context.Context, bankAccountService BankAccountService, priceFeedMgr PriceAdapterMgr, groupId uuid.UUID, pubKey string) (*SubAccountsView, error) {
+	accounts, err := ListOpenAccountsByPubkey(ctx, bankAccountService, groupId, pubKey)
+	if err != nil {
+		return nil, err
+	}
+
+	view := &SubAccountsView{
+		Accounts:         make([]*SubAccountView, 0, len(accounts)),
+		TotalAssets:      decimal.Zero,
+		TotalLiabilities: decimal.Zero,
+		TotalEquity:      decimal.Zero,
+	}
+	for _, account := range accounts {
+		riskEngine, err := NewRiskEngineNoFlashloanCheck(ctx, bankAccountService, account, []*BankAccountWrapper{}, priceFeedMgr)
+		if err != nil {
+			return nil, err
+		}
+		totalAssets, totalLiabilities, err := riskEngine.GetAccountHealthComponents(Equity)
+		if err != nil {
+			return nil, err
+		}
+
+		view.Accounts = append(view.Accounts, &SubAccountView{
+			Account:          account,
+			TotalAssets:      totalAssets,
+			TotalLiabilities: totalLiabilities,
+			Equity:           totalAssets.Sub(totalLiabilities),
+		})
+		view.TotalAssets = view.TotalAssets.Add(totalAssets)
+		view.TotalLiabilities = view.TotalLiabilities.Add(totalLiabilities)
+	}
+	view.TotalEquity = view.TotalAssets.Sub(view.TotalLiabilities)
+
+	return view, nil
+}
+
+// TransferCollateral moves amount of bank collateral between two sub-accounts of the same owner
+// inside the bank, the source account has to stay above its initial requirement
+func TransferCollateral(ctx context.Context, clk clock.Clock, log Log, bankAccountService BankAccountService, bankAccountStore BankAccountWrapperStore, operateStore OperateStore, priceFeedMgr PriceAdapterMgr, from, to *Account, bank *Bank, amount decimal.Decimal) error {
+	if from.Id == to.Id || from.GroupId != to.GroupId || from.PubKey != to.PubKey || bank.GroupId != from.GroupId {
+		return InvalidTransfer
+	}
+	if !amount.IsPositive() {
+		return ErrTransferAmount
+	}
+	for _, account := range []*Account{from, to} {
+		if account.GetFlag(DisabledFlag) {
+			return AccountDisabled
+		}
+		if account.GetFlag(InFlashloanFlag) {
+			return AccountInFlashloan
+		}
+		if account.GetFlag(ClosedFlag) {
+			return AccountClosed
+		}
+	}
+
+	if err := bank.AccrueInterest(log, clk.Now().Unix()); err != nil {
+		return err
+	}
+
+	fromBa, err := FindBankAccountWrapper(ctx, bankAccountService, bank, from, WithClock(clk))
+	if err != nil {
+		return err
+	}
+	toBa, err := FindOrCreateBankAccountWrapper(ctx, clk, bankAccountService, bank, to)
+	if err != nil {
+		return err
+	}
+
+	if err := fromBa.Withdraw(log, amount); err != nil {
+		return err
+	}
+	// the bank totals do not change, so the deposit limit is not checked again
+	if err := toBa.IncreaseBalanceInternal(log, amount, BalanceIncreaseTypeBypassDepositLimit); err != nil {
+		return err
+	}
+
+	riskEngine, err := NewRiskEngine(ctx, bankAccountService, from, []*BankAccountWrapper{fromBa}, priceFeedMgr)
+	if err != nil {
+		return err
+	}
+	if err := riskEngine.CheckAccountHealth(Initial); err != nil {
+		return err
+	}
+
+	if err := bankAccountStore.StorageBankAccount(ctx, fromBa); err != nil {
+		return err
+	}
+	if err := bankAccountStore.StorageBankAccount(ctx, toBa); err != nil {
+		return err
+	}
+
+	detail := OperateDetail{
+		Type:      MATTransferCollateral,
+		AccountId: from.Id,
+		Actions: []ActionDetail{
+			{AccountId: from.Id, ActionType: MATWithdraw, BankId: bank.Id, Amount: amount},
+			{AccountId: to.Id, ActionType: MATSupply, BankId: bank.Id, Amount: amount},
+		},
+	}
+	// both accounts keep the transfer in their history
+	for _, account := range []*Account{from, to} {
+		operate := NewOperate(clk, account.PubKey, account.Id, MATTransferCollateral, detail)
+		if err := operateStore.CreateOperate(ctx, &operate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
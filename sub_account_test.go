@@ -0,0 +1,48 @@
+package core
+
+import (
+	"context"
+	"testing"
+
+	"github.com/facebookgo/clock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateSubAccount(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewMock()
+	store := newLoopStore()
+	group := &Group{Config: GroupConfig{MaxAccountsPerPubKey: 3}}
+
+	for index := uint8(0); index < 3; index++ {
+		account, err := CreateSubAccount(ctx, clk, store, group, "alice", "")
+		assert.NoError(t, err)
+		assert.Equal(t, index, account.Index)
+	}
+	_, err := CreateSubAccount(ctx, clk, store, group, "alice", "")
+	assert.ErrorIs(t, err, TooManyAccounts)
+
+	// another owner has its own limit
+	other, err := CreateSubAccount(ctx, clk, store, group, "bob", "trading")
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(0), other.Index)
+	assert.Equal(t, "trading", other.Label)
+
+	// the index of a closed account is handed out again under a new id
+	accounts, err := store.ListAccountByPubkey(ctx, group.Id, "alice")
+	assert.NoError(t, err)
+	var closed *Account
+	for _, account := range accounts {
+		if account.Index == 1 {
+			closed = account
+		}
+	}
+	closed.SetFlag(ClosedFlag)
+	reopened, err := CreateSubAccount(ctx, clk, store, group, "alice", "")
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(1), reopened.Index)
+	assert.NotEqual(t, closed.Id, reopened.Id)
+
+	_, err = CreateSubAccount(ctx, clk, store, group, "carol", string(make([]byte, MAX_ACCOUNT_LABEL_LENGTH+1)))
+	assert.ErrorIs(t, err, ErrInvalidAccountLabel)
+}
@@ -14,6 +14,17 @@ type BankAccountService struct {
 	BalanceStore
 	BankStore
 	AccountStore
+
+	// GroupStore is optional, without it every group runs on the default config
+	GroupStore GroupStore
+}
+
+// GetGroup loads the group, or a group with the default config when the service has no GroupStore
+func (s BankAccountService) GetGroup(ctx context.Context, groupId uuid.UUID) (*Group, error) {
+	if s.GroupStore == nil {
+		return &Group{Id: groupId, Config: DefaultGroupConfig()}, nil
+	}
+	return s.GroupStore.GetGroupById(ctx, groupId)
 }
 
 type PriceAdapterMgr interface {
@@ -25,6 +36,8 @@ type PriceAdapter interface {
 	GetAllPriceType() (price decimal.Decimal, priceLow decimal.Decimal, priceHigh decimal.Decimal, err error)
 }
 
+// ComputeLiquidationPriceForBank solves one bank including the changed bank accounts of a pending
+// request, ComputeLiquidationPrices solves every balance of a stored account at once
 func ComputeLiquidationPriceForBank(bankAccountService BankAccountService, banks map[string]*Bank, changedbankAccounts []*BankAccountWrapper, priceFeedMgr PriceAdapterMgr, accountId, bankId uuid.UUID, marginReqType RequirementType) (decimal.Decimal, error) {
 	var err error
 	bank, ok := banks[bankId.String()]
@@ -175,78 +188,14 @@ func CalculatePostFeeSplDepositAmount(amount decimal.Decimal) (decimal.Decimal,
 	return amount, nil
 }
 
-// ComputeNetApy 
+// ComputeNetApy returns the interest apy of the account over its equity, see ComputeAccountApy
+// for the emissions component
 func ComputeNetApy(bankAccountService BankAccountService, priceFeedMgr PriceAdapterMgr, accountId uuid.UUID) (decimal.Decimal, error) {
-	ctx := context.Background()
-	account, err := bankAccountService.GetAccountById(ctx, accountId)
+	apy, err := ComputeAccountApy(context.Background(), bankAccountService, priceFeedMgr, accountId)
 	if err != nil {
 		return decimal.Zero, err
 	}
-	balances, err := bankAccountService.ListBalances(ctx, accountId, uuid.Nil)
-	if err != nil {
-		return decimal.Zero, err
-	}
-	var activeBankAccounts []*Balance
-	for _, balance := range balances {
-		if balance.Active {
-			activeBankAccounts = append(activeBankAccounts, balance)
-		}
-	}
-
-	riskEngine, err := NewRiskEngine(ctx, bankAccountService, account, []*BankAccountWrapper{}, priceFeedMgr)
-	if err != nil {
-		return decimal.Zero, err
-	}
-	totalAssets, totalLiabilities, err := riskEngine.GetAccountHealthComponents(Equity)
-	if err != nil {
-		return decimal.Zero, err
-	}
-	totalUsdValue := totalAssets.Sub(totalLiabilities)
-
-	weightedApr := decimal.Zero
-	for _, activeBalance := range activeBankAccounts {
-		bank, err := bankAccountService.GetBankById(ctx, activeBalance.BankId)
-		if err != nil {
-			return decimal.Zero, err
-		}
-
-		priceAdapter, err := priceFeedMgr.GetPriceAdapter(bank)
-		if err != nil {
-			return decimal.Zero, err
-		}
-
-		priceInfo, err := priceAdapter.GetPriceOfType(RealTime, Original)
-		if err != nil {
-			return decimal.Zero, err
-		}
-		utilizationRatio := decimal.Zero
-		if !totalAssets.IsZero() {
-			utilizationRatio = totalLiabilities.Div(totalAssets)
-		}
-		lendingApr, borrowingApr, _, _, err := bank.BankConfig.InterestRateConfig.CalcInterestRate(utilizationRatio)
-		if err != nil {
-			return decimal.Zero, err
-		}
-
-		if totalUsdValue.IsZero() {
-			totalUsdValue = ONE
-		}
-
-		assetUsdValue := activeBalance.AssetShares.Mul(priceInfo)
-		assetApr := decimal.Zero
-		if !totalUsdValue.IsZero() {
-			assetApr = lendingApr.Mul(assetUsdValue).Div(totalUsdValue)
-		}
-		liabilityUsdValue := activeBalance.LiabilityShares.Mul(priceInfo)
-		liabilityApr := decimal.Zero
-		if !totalUsdValue.IsZero() {
-			liabilityApr = borrowingApr.Mul(liabilityUsdValue).Div(totalUsdValue)
-		}
-
-		weightedApr = weightedApr.Add(assetApr).Sub(liabilityApr)
-	}
-
-	return AprToApy(weightedApr), nil
+	return apy.NetApy, nil
 }
 
 /*
@@ -270,8 +219,13 @@ func CalcInterestRateAccrualStateChanges(log Log, timeDelta uint64, totalAssetsA
 		return decimal.Zero, decimal.Zero, decimal.Zero, decimal.Zero, err
 	}
 
-	log.Info().Msgf("timeDelta: %d,utilizationRate: %s, lendingApr: %s, borrowingApr: %s, groupFeeApr: %s, insuranceFeeApr: %s", timeDelta, utilizationRate, lendingApr, borrowingApr, groupFeeApr, insuranceFeeApr)
+	log.Debug().Msgf("timeDelta: %d,utilizationRate: %s, lendingApr: %s, borrowingApr: %s, groupFeeApr: %s, insuranceFeeApr: %s", timeDelta, utilizationRate, lendingApr, borrowingApr, groupFeeApr, insuranceFeeApr)
+
+	return CalcInterestAccrualForRates(timeDelta, totalLiabilitiesAmount, lendingApr, borrowingApr, groupFeeApr, insuranceFeeApr, assetShareValue, liabilityShareValue)
+}
 
+// CalcInterestAccrualForRates is CalcInterestRateAccrualStateChanges with the rates already computed
+func CalcInterestAccrualForRates(timeDelta uint64, totalLiabilitiesAmount, lendingApr, borrowingApr, groupFeeApr, insuranceFeeApr, assetShareValue, liabilityShareValue decimal.Decimal) (decimal.Decimal, decimal.Decimal, decimal.Decimal, decimal.Decimal, error) {
 	accruedAssetShareValue, err := CalcAccruedInterestPaymentPerPeriod(lendingApr, timeDelta, assetShareValue)
 	if err != nil {
 		return decimal.Zero, decimal.Zero, decimal.Zero, decimal.Zero, err